@@ -10,6 +10,7 @@ import (
 
 	_ "github.com/Komilov31/image-processor/docs"
 	"github.com/Komilov31/image-processor/internal/config"
+	"github.com/Komilov31/image-processor/internal/dto"
 	"github.com/Komilov31/image-processor/internal/handler"
 	"github.com/Komilov31/image-processor/internal/kafka"
 	repository "github.com/Komilov31/image-processor/internal/repository/db"
@@ -42,7 +43,20 @@ func Run() error {
 	repository := repository.NewPostgres(db)
 	queue := kafka.New()
 	fileStorage := minio.New()
-	service := service.New(repository, fileStorage, queue)
+	encoder := config.Cfg.Encoder
+	defaults := dto.Output{
+		JPEGQuality:    encoder.JPEGQuality,
+		PNGCompression: encoder.PNGCompression,
+		GIFColors:      encoder.GIFColors,
+		GIFQuantizer:   encoder.GIFQuantizer,
+		GIFDither:      encoder.GIFDither,
+		StripMetadata:  encoder.StripMetadata,
+		KeepICCProfile: encoder.KeepICCProfile,
+		KeepCopyright:  encoder.KeepCopyright,
+		ColorProfile:   encoder.ColorProfile,
+	}
+
+	service := service.New(repository, fileStorage, queue, defaults)
 	handler := handler.New(service)
 
 	router := ginext.New()
@@ -73,12 +87,17 @@ func registerRoutes(engine *ginext.Engine, handler *handler.Handler) {
 
 	// POST requests
 	engine.POST("/upload", handler.CreateImage)
+	engine.POST("/overlay", handler.CreateOverlay)
+	engine.POST("/font", handler.CreateFont)
+	engine.POST("/search/similar", handler.SearchSimilarImages)
 
 	// GET requests
 	engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	engine.GET("/", handler.GetMainPage)
 	engine.GET("/image/:id", handler.GetImageByID)
 	engine.GET("/image/info/:id", handler.GetImageInfo)
+	engine.GET("/image/:id/metadata", handler.GetImageMetadata)
+	engine.GET("/image/:id/similar", handler.GetSimilarImages)
 
 	// DELETE request
 	engine.DELETE("/image/:id", handler.DeleteImageByID)
@@ -47,6 +47,59 @@ const docTemplate = `{
                 }
             }
         },
+        "/font": {
+            "post": {
+                "description": "Upload a TrueType or OpenType font that text watermarks can reference by id",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "fonts"
+                ],
+                "summary": "Upload watermark font",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Font file to upload",
+                        "name": "font",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "id",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
         "/image/info/{id}": {
             "get": {
                 "description": "Get information about image processing status and metadata",
@@ -117,6 +170,12 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Variant name, the first variant is returned by default",
+                        "name": "variant",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -198,6 +257,227 @@ const docTemplate = `{
                 }
             }
         },
+        "/image/{id}/metadata": {
+            "get": {
+                "description": "Get EXIF, IPTC and XMP metadata of the original image, it is extracted when the image is picked up for processing",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "images"
+                ],
+                "summary": "Get image metadata",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Image ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Image metadata",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.Metadata"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/image/{id}/similar": {
+            "get": {
+                "description": "Find images whose perceptual hash is close to the one of the image, ranked by Hamming distance. Hashes are computed when the image is picked up for processing",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "images"
+                ],
+                "summary": "Find near duplicates of an image",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Image ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Hamming distance between the pHashes, from 0 to 64, 10 by default",
+                        "name": "max_distance",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Similar images",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.SimilarImage"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/overlay": {
+            "post": {
+                "description": "Upload an image (for example a PNG logo) that watermark operations can reference by id",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "overlays"
+                ],
+                "summary": "Upload watermark overlay",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Overlay image to upload",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "id",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/search/similar": {
+            "post": {
+                "description": "Find stored images whose perceptual hash is close to the one of the uploaded image, ranked by Hamming distance. The uploaded image is not stored",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "images"
+                ],
+                "summary": "Search near duplicates of an uploaded image",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image file to search for",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Hamming distance between the pHashes, from 0 to 64, 10 by default",
+                        "name": "max_distance",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Similar images",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.SimilarImage"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
         "/upload": {
             "post": {
                 "description": "Upload an image file with metadata for processing",
@@ -260,15 +540,183 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "github_com_Komilov31_image-processor_internal_model.EXIF": {
+            "type": "object",
+            "properties": {
+                "artist": {
+                    "type": "string"
+                },
+                "capture_time": {
+                    "type": "string"
+                },
+                "copyright": {
+                    "type": "string"
+                },
+                "exposure_time": {
+                    "type": "string"
+                },
+                "f_number": {
+                    "type": "number"
+                },
+                "focal_length": {
+                    "type": "number"
+                },
+                "gps": {
+                    "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.GPS"
+                },
+                "iso": {
+                    "type": "integer"
+                },
+                "lens_make": {
+                    "type": "string"
+                },
+                "lens_model": {
+                    "type": "string"
+                },
+                "make": {
+                    "type": "string"
+                },
+                "model": {
+                    "type": "string"
+                },
+                "orientation": {
+                    "type": "integer"
+                },
+                "software": {
+                    "type": "string"
+                }
+            }
+        },
+        "github_com_Komilov31_image-processor_internal_model.File": {
+            "type": "object",
+            "properties": {
+                "height": {
+                    "type": "integer"
+                },
+                "mime_type": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "github_com_Komilov31_image-processor_internal_model.GPS": {
+            "type": "object",
+            "properties": {
+                "altitude": {
+                    "type": "number"
+                },
+                "latitude": {
+                    "type": "number"
+                },
+                "longitude": {
+                    "type": "number"
+                }
+            }
+        },
         "github_com_Komilov31_image-processor_internal_model.Image": {
             "type": "object",
             "properties": {
+                "color_space": {
+                    "type": "string"
+                },
                 "create_at": {
                     "type": "string"
                 },
                 "id": {
                     "type": "string"
                 },
+                "metadata": {
+                    "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.Metadata"
+                },
+                "original": {
+                    "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.File"
+                },
+                "output_format": {
+                    "type": "string"
+                },
+                "processed": {
+                    "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.File"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.Variant"
+                    }
+                }
+            }
+        },
+        "github_com_Komilov31_image-processor_internal_model.Metadata": {
+            "type": "object",
+            "properties": {
+                "exif": {
+                    "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.EXIF"
+                },
+                "iptc": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "xmp": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "github_com_Komilov31_image-processor_internal_model.SimilarImage": {
+            "type": "object",
+            "properties": {
+                "ahash_distance": {
+                    "type": "integer"
+                },
+                "create_at": {
+                    "type": "string"
+                },
+                "dhash_distance": {
+                    "type": "integer"
+                },
+                "distance": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "github_com_Komilov31_image-processor_internal_model.Variant": {
+            "type": "object",
+            "properties": {
+                "format": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "processed": {
+                    "$ref": "#/definitions/github_com_Komilov31_image-processor_internal_model.File"
+                },
                 "status": {
                     "type": "string"
                 }
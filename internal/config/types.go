@@ -5,6 +5,7 @@ type Config struct {
 	HttpServer HttpServerConfig `mapstructure:"http_server"`
 	Minio      MinioConfig      `mapstructure:"minio"`
 	Kafka      KafkaConfig      `mapstructure:"kafka"`
+	Encoder    EncoderConfig    `mapstructure:"encoder"`
 }
 
 type PostgresConfig struct {
@@ -30,3 +31,19 @@ type KafkaConfig struct {
 	Host string `mapstructure:"host"`
 	Port string `mapstructure:"port"`
 }
+
+// EncoderConfig holds the default output options of every job. The switches
+// are left nil when the key is missing, which keeps the service defaults:
+// GIFs are dithered, metadata is stripped, the ICC profile and copyright are
+// kept.
+type EncoderConfig struct {
+	JPEGQuality    int    `mapstructure:"jpeg_quality"`
+	PNGCompression string `mapstructure:"png_compression"`
+	GIFColors      int    `mapstructure:"gif_colors"`
+	GIFQuantizer   string `mapstructure:"gif_quantizer"`
+	GIFDither      *bool  `mapstructure:"gif_dither"`
+	StripMetadata  *bool  `mapstructure:"strip_metadata"`
+	KeepICCProfile *bool  `mapstructure:"keep_icc_profile"`
+	KeepCopyright  *bool  `mapstructure:"keep_copyright"`
+	ColorProfile   string `mapstructure:"color_profile"`
+}
@@ -2,14 +2,229 @@ package dto
 
 import "github.com/google/uuid"
 
+// Message describes an image processing job. The image is processed by the
+// ordered Operations list, or by the single embedded Operation when the list
+// is empty. When Variants are given, each of them is produced by its own
+// pipeline and the job's own operations are ignored. The embedded Output
+// describes how the results are encoded, variants may override it.
 type Message struct {
-	ID            uuid.UUID `json:"id"`
-	FileName      string    `json:"file_name"`
-	ContentType   string    `json:"content_type"`
-	WatermarkText string    `json:"watermark_string"`
-	Task          string    `json:"task"`
-	Resize        struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"resize"`
+	ID          uuid.UUID `json:"id"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	AutoOrient  *bool     `json:"auto_orient,omitempty"`
+	Output
+	Operation
+	Operations []Operation `json:"operations,omitempty"`
+	Variants   []Variant   `json:"variants,omitempty"`
+}
+
+type Variant struct {
+	Name string `json:"name"`
+	Output
+	Operations []Operation `json:"operations"`
+}
+
+// Output describes the encoding of a processed image. Format defaults to the
+// format of the original, Background is the colour transparent pixels are
+// flattened onto for formats without an alpha channel. The remaining fields
+// only apply to their own format, zero values fall back to the server defaults.
+// StripMetadata removes the metadata of the original from the outputs except
+// for the colour profile and the copyright notices, as far as KeepICCProfile
+// and KeepCopyright allow. ColorProfile selects whether wide-gamut pixels are
+// converted to sRGB or kept together with the profile of the original.
+type Output struct {
+	Format         string `json:"output_format,omitempty"`
+	Background     string `json:"background,omitempty"`
+	JPEGQuality    int    `json:"jpeg_quality,omitempty"`
+	PNGCompression string `json:"png_compression,omitempty"`
+	GIFColors      int    `json:"gif_colors,omitempty"`
+	GIFQuantizer   string `json:"gif_quantizer,omitempty"`
+	GIFDither      *bool  `json:"gif_dither,omitempty"`
+	StripMetadata  *bool  `json:"strip_metadata,omitempty"`
+	KeepICCProfile *bool  `json:"keep_icc_profile,omitempty"`
+	KeepCopyright  *bool  `json:"keep_copyright,omitempty"`
+	ColorProfile   string `json:"color_profile,omitempty"`
+}
+
+type Operation struct {
+	Task          string       `json:"task"`
+	WatermarkText string       `json:"watermark_string"`
+	Watermark     Watermark    `json:"watermark"`
+	Resize        Resize       `json:"resize"`
+	Crop          Crop         `json:"crop"`
+	Rotate        Rotate       `json:"rotate"`
+	Flip          string       `json:"flip"`
+	Adjust        Adjust       `json:"adjust"`
+	Blur          Blur         `json:"blur"`
+	Sharpen       Sharpen      `json:"sharpen"`
+	Convolve      Convolve     `json:"convolve"`
+	Redact        Redact       `json:"redact"`
+	Border        Border       `json:"border"`
+	Padding       Padding      `json:"padding"`
+	RoundCorners  RoundCorners `json:"round_corners"`
+	Canvas        Canvas       `json:"canvas"`
+	Caption       Caption      `json:"caption"`
+}
+
+// Watermark describes the style of the watermark. Position is one of the crop
+// gravities, Margin is the distance from the image edges in pixels, Opacity
+// is in [0, 1] and FontSize is a fraction of the image width. Font is the name
+// of a builtin font or the id of an uploaded one. Rotation is in
+// degrees clockwise. When OverlayID references an uploaded overlay, it is
+// drawn instead of the text, scaled to the Scale fraction of the image width
+// or kept at its own size. Mode "tiled" repeats the mark over the whole image
+// on a rotated grid with Spacing pixels between the marks, Position and
+// Margin are ignored then. Zero values fall back to the defaults.
+type Watermark struct {
+	Mode      string  `json:"mode"`
+	Position  string  `json:"position"`
+	Margin    int     `json:"margin"`
+	Color     string  `json:"color"`
+	Opacity   float64 `json:"opacity"`
+	FontSize  float64 `json:"font_size"`
+	Font      string  `json:"font"`
+	Rotation  float64 `json:"rotation"`
+	OverlayID string  `json:"overlay_id"`
+	Scale     float64 `json:"scale"`
+	Spacing   int     `json:"spacing"`
+}
+
+// Adjust describes colour adjustments. Brightness, Contrast and Saturation
+// are in [-1, 1] where 0 keeps the image as is, Gamma is in (0, 10] where 0
+// and 1 keep the image, Hue is a shift in degrees in [-180, 180]. Grayscale,
+// Sepia and Invert are amounts in [0, 1].
+type Adjust struct {
+	Brightness float64 `json:"brightness"`
+	Contrast   float64 `json:"contrast"`
+	Gamma      float64 `json:"gamma"`
+	Saturation float64 `json:"saturation"`
+	Hue        float64 `json:"hue"`
+	Grayscale  float64 `json:"grayscale"`
+	Sepia      float64 `json:"sepia"`
+	Invert     float64 `json:"invert"`
+}
+
+// Blur is a Gaussian blur with the Sigma standard deviation in pixels, or a
+// box blur averaging the square of the given Radius when Type is "box".
+type Blur struct {
+	Type   string  `json:"type"`
+	Sigma  float64 `json:"sigma"`
+	Radius int     `json:"radius"`
+}
+
+// Sharpen is an unsharp mask. Sigma is the blur the image is compared with,
+// Amount scales the difference and only differences above Threshold (in
+// [0, 255]) are enhanced. Zero Sigma and Amount fall back to 1.
+type Sharpen struct {
+	Sigma     float64 `json:"sigma"`
+	Amount    float64 `json:"amount"`
+	Threshold int     `json:"threshold"`
+}
+
+// Convolve applies a 3x3 or 5x5 Kernel given row by row. The weighted sum is
+// divided by Divisor, the sum of the kernel when not set, and Bias is added.
+type Convolve struct {
+	Kernel  []float64 `json:"kernel"`
+	Divisor float64   `json:"divisor"`
+	Bias    float64   `json:"bias"`
+}
+
+// Redact hides the Regions of the image with Method "pixelate" (the default),
+// "blur" or "fill". BlockSize is the pixelation block in pixels, Sigma the
+// strength of the blur and Color the fill colour. Regions are in pixels of the
+// original image, redactions run before every other operation of the pipeline.
+type Redact struct {
+	Regions   []Region `json:"regions"`
+	Method    string   `json:"method"`
+	BlockSize int      `json:"block_size"`
+	Sigma     float64  `json:"sigma"`
+	Color     string   `json:"color"`
+}
+
+type Region struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Border extends the canvas by Width pixels of Color on every side.
+type Border struct {
+	Width int    `json:"width"`
+	Color string `json:"color"`
+}
+
+// Padding extends the canvas by the given number of pixels on each side,
+// filled with Color or left transparent when it is not set.
+type Padding struct {
+	Top    int    `json:"top"`
+	Right  int    `json:"right"`
+	Bottom int    `json:"bottom"`
+	Left   int    `json:"left"`
+	Color  string `json:"color"`
+}
+
+// RoundCorners makes the corners transparent outside of circles with the given
+// Radius in pixels. Radii larger than half of the shorter side are reduced to it,
+// which turns a square image into a circle.
+type RoundCorners struct {
+	Radius int `json:"radius"`
+}
+
+// Canvas places the image on a Width x Height background of Color at the
+// Gravity position, centered by default. Images larger than the canvas are
+// scaled down to fit it, smaller ones keep their size.
+type Canvas struct {
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Color   string `json:"color"`
+	Gravity string `json:"gravity"`
+}
+
+// Caption lays Text out in a box taking the Width fraction of the space
+// between the margins, placed at the Position gravity within Margin pixels of
+// the edges. Lines are
+// wrapped at word boundaries (and at explicit line breaks) and aligned to the
+// left, center or right. FontSize is a fraction of the image width,
+// LineSpacing a multiple of the font line height. With a Background colour
+// the whole box is filled, Padding is the space between its edges and the
+// text. Zero values fall back to the defaults.
+type Caption struct {
+	Text        string  `json:"text"`
+	Font        string  `json:"font"`
+	FontSize    float64 `json:"font_size"`
+	Color       string  `json:"color"`
+	Align       string  `json:"align"`
+	LineSpacing float64 `json:"line_spacing"`
+	Position    string  `json:"position"`
+	Margin      int     `json:"margin"`
+	Width       float64 `json:"width"`
+	Background  string  `json:"background"`
+	Padding     int     `json:"padding"`
+}
+
+// Resize scales the image into the Width x Height box according to Mode.
+// Filter is the interpolation used for resampling, Lanczos3 by default.
+// Gravity is the part of the image kept by the fill and cover modes, one of
+// the crop gravities or "smart", the centre by default.
+type Resize struct {
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Mode       string `json:"mode"`
+	Background string `json:"background"`
+	Filter     string `json:"filter"`
+	Gravity    string `json:"gravity"`
+}
+
+type Crop struct {
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Gravity string `json:"gravity"`
+}
+
+type Rotate struct {
+	Angle      float64 `json:"angle"`
+	Background string  `json:"background"`
 }
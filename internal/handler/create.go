@@ -56,9 +56,9 @@ func (h *Handler) CreateImage(c *ginext.Context) {
 		return
 	}
 
-	id, err := h.service.CreateImage(fileBytes, message)
+	id, err := h.service.CreateImage(fileBytes, fileHeader.Filename, message)
 	if err != nil {
-		if errors.Is(err, service.ErrInvalidImageFormat) || errors.Is(err, service.ErrInvalidTask) {
+		if isInvalidRequest(err) {
 			zlog.Logger.Error().Msg("could not create file: " + err.Error())
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
 			return
@@ -71,3 +71,142 @@ func (h *Handler) CreateImage(c *ginext.Context) {
 	zlog.Logger.Info().Msg("successfully handled GET request and created image with id: " + id.String())
 	c.JSON(http.StatusOK, ginext.H{"id": id.String()})
 }
+
+// CreateOverlay godoc
+// @Summary      Upload watermark overlay
+// @Description  Upload an image (for example a PNG logo) that watermark operations can reference by id
+// @Tags         overlays
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        image    formData file     true  "Overlay image to upload"
+// @Success      200      {object} map[string]string "id"
+// @Failure      400      {object} map[string]string "error"
+// @Failure      500      {object} map[string]string "error"
+// @Router       /overlay [post]
+func (h *Handler) CreateOverlay(c *ginext.Context) {
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		zlog.Logger.Error().Msg("invalid overlay: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid overlay: " + err.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		zlog.Logger.Error().Msg("could not open the overlay: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "could not open the overlay"})
+		return
+	}
+	defer file.Close()
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		zlog.Logger.Error().Msg("could not open the overlay: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "could not open the overlay"})
+		return
+	}
+
+	id, err := h.service.CreateOverlay(fileBytes)
+	if err != nil {
+		if isInvalidRequest(err) {
+			zlog.Logger.Error().Msg("could not create overlay: " + err.Error())
+			c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		zlog.Logger.Error().Msg("could not create overlay: " + err.Error())
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": "could not create overlay"})
+		return
+	}
+
+	zlog.Logger.Info().Msg("successfully created overlay with id: " + id.String())
+	c.JSON(http.StatusOK, ginext.H{"id": id.String()})
+}
+
+// CreateFont godoc
+// @Summary      Upload watermark font
+// @Description  Upload a TrueType or OpenType font that text watermarks can reference by id
+// @Tags         fonts
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        font     formData file     true  "Font file to upload"
+// @Success      200      {object} map[string]string "id"
+// @Failure      400      {object} map[string]string "error"
+// @Failure      500      {object} map[string]string "error"
+// @Router       /font [post]
+func (h *Handler) CreateFont(c *ginext.Context) {
+	fileHeader, err := c.FormFile("font")
+	if err != nil {
+		zlog.Logger.Error().Msg("invalid font: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid font: " + err.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		zlog.Logger.Error().Msg("could not open the font: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "could not open the font"})
+		return
+	}
+	defer file.Close()
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		zlog.Logger.Error().Msg("could not open the font: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "could not open the font"})
+		return
+	}
+
+	id, err := h.service.CreateFont(fileBytes)
+	if err != nil {
+		if isInvalidRequest(err) {
+			zlog.Logger.Error().Msg("could not create font: " + err.Error())
+			c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		zlog.Logger.Error().Msg("could not create font: " + err.Error())
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": "could not create font"})
+		return
+	}
+
+	zlog.Logger.Info().Msg("successfully created font with id: " + id.String())
+	c.JSON(http.StatusOK, ginext.H{"id": id.String()})
+}
+
+func isInvalidRequest(err error) bool {
+	invalidRequestErrors := []error{
+		service.ErrInvalidImageFormat,
+		service.ErrInvalidImage,
+		service.ErrInvalidTask,
+		service.ErrInvalidOperations,
+		service.ErrInvalidResize,
+		service.ErrInvalidCrop,
+		service.ErrInvalidRotate,
+		service.ErrInvalidWatermark,
+		service.ErrInvalidOverlay,
+		service.ErrNoSuchOverlay,
+		service.ErrInvalidFont,
+		service.ErrNoSuchFont,
+		service.ErrInvalidFlip,
+		service.ErrInvalidAdjust,
+		service.ErrInvalidBlur,
+		service.ErrInvalidSharpen,
+		service.ErrInvalidConvolve,
+		service.ErrInvalidRedact,
+		service.ErrInvalidBorder,
+		service.ErrInvalidPadding,
+		service.ErrInvalidRoundCorners,
+		service.ErrInvalidCanvas,
+		service.ErrInvalidCaption,
+		service.ErrInvalidOutput,
+		service.ErrInvalidVariants,
+		service.ErrInvalidSimilarSearch,
+	}
+
+	for _, target := range invalidRequestErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -19,7 +19,8 @@ import (
 // @Tags         images
 // @Accept       json
 // @Produce      application/octet-stream
-// @Param        id   path     string true  "Image ID"
+// @Param        id      path     string true  "Image ID"
+// @Param        variant query    string false "Variant name, the first variant is returned by default"
 // @Success      200  {file}   file   "Processed image file"
 // @Failure      400  {object} map[string]string "error"
 // @Failure      500  {object} map[string]string "error"
@@ -33,18 +34,23 @@ func (h *Handler) GetImageByID(c *ginext.Context) {
 		return
 	}
 
-	image, err := h.service.GetImageById(id)
+	image, err := h.service.GetImageById(id, c.Query("variant"))
 	if err != nil {
 		if errors.Is(err, service.ErrNotProcessdYet) {
 			c.JSON(http.StatusOK, ginext.H{"status": "in processing, not ready yet"})
 			return
 		}
 
-		if errors.Is(err, repository.ErrNoSuchImage) {
+		if errors.Is(err, repository.ErrNoSuchImage) || errors.Is(err, service.ErrNoSuchVariant) {
 			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 			return
 		}
 
+		if errors.Is(err, service.ErrProcessingFailed) {
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+			return
+		}
+
 		zlog.Logger.Error().Msg("could not get image: " + err.Error())
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": "could not get image"})
 		return
@@ -93,6 +99,47 @@ func (h *Handler) GetImageInfo(c *ginext.Context) {
 	c.JSON(http.StatusOK, info)
 }
 
+// GetImageMetadata godoc
+// @Summary      Get image metadata
+// @Description  Get EXIF, IPTC and XMP metadata of the original image, it is extracted when the image is picked up for processing
+// @Tags         images
+// @Accept       json
+// @Produce      json
+// @Param        id   path     string true  "Image ID"
+// @Success      200  {object} model.Metadata "Image metadata"
+// @Failure      400  {object} map[string]string "error"
+// @Failure      500  {object} map[string]string "error"
+// @Router       /image/{id}/metadata [get]
+func (h *Handler) GetImageMetadata(c *ginext.Context) {
+	uid := c.Param("id")
+	id, err := uuid.Parse(uid)
+	if err != nil {
+		zlog.Logger.Error().Msg("could not parse id to uuid: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid id was provided"})
+		return
+	}
+
+	metadata, err := h.service.GetImageMetadata(id)
+	if err != nil {
+		if errors.Is(err, service.ErrNotProcessdYet) {
+			c.JSON(http.StatusOK, ginext.H{"status": "in processing, not ready yet"})
+			return
+		}
+
+		if errors.Is(err, repository.ErrNoSuchImage) {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+			return
+		}
+
+		zlog.Logger.Error().Msg("could not get image metadata: " + err.Error())
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": "could not get image metadata"})
+		return
+	}
+
+	zlog.Logger.Info().Msg("sucessfully handled GET request and returned image metadata to user")
+	c.JSON(http.StatusOK, metadata)
+}
+
 // GetMainPage godoc
 // @Summary      Get main page
 // @Description  Get the main HTML page of the application
@@ -9,9 +9,14 @@ import (
 type ImageProcessorService interface {
 	ProcessImage(dto.Message) error
 	GetImageStatus(uuid.UUID) (*model.Image, error)
-	GetImageById(uuid.UUID) (string, error)
-	CreateImage([]byte, dto.Message) (*uuid.UUID, error)
+	GetImageById(uuid.UUID, string) (string, error)
+	GetImageMetadata(uuid.UUID) (*model.Metadata, error)
+	GetSimilarImages(uuid.UUID, int) ([]model.SimilarImage, error)
+	SearchSimilarImages([]byte, int) ([]model.SimilarImage, error)
+	CreateImage([]byte, string, dto.Message) (*uuid.UUID, error)
 	DeleteImage(uuid.UUID) error
+	CreateOverlay([]byte) (*uuid.UUID, error)
+	CreateFont([]byte) (*uuid.UUID, error)
 }
 
 type Handler struct {
@@ -7,6 +7,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"testing"
 
 	"github.com/Komilov31/image-processor/internal/dto"
 	"github.com/Komilov31/image-processor/internal/model"
@@ -35,13 +36,37 @@ func (m *MockImageProcessorService) GetImageStatus(id uuid.UUID) (*model.Image,
 	return args.Get(0).(*model.Image), args.Error(1)
 }
 
-func (m *MockImageProcessorService) GetImageById(id uuid.UUID) (string, error) {
-	args := m.Called(id)
+func (m *MockImageProcessorService) GetImageById(id uuid.UUID, variant string) (string, error) {
+	args := m.Called(id, variant)
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockImageProcessorService) CreateImage(data []byte, message dto.Message) (*uuid.UUID, error) {
-	args := m.Called(data, message)
+func (m *MockImageProcessorService) GetImageMetadata(id uuid.UUID) (*model.Metadata, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Metadata), args.Error(1)
+}
+
+func (m *MockImageProcessorService) GetSimilarImages(id uuid.UUID, maxDistance int) ([]model.SimilarImage, error) {
+	args := m.Called(id, maxDistance)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.SimilarImage), args.Error(1)
+}
+
+func (m *MockImageProcessorService) SearchSimilarImages(data []byte, maxDistance int) ([]model.SimilarImage, error) {
+	args := m.Called(data, maxDistance)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.SimilarImage), args.Error(1)
+}
+
+func (m *MockImageProcessorService) CreateImage(data []byte, name string, message dto.Message) (*uuid.UUID, error) {
+	args := m.Called(data, name, message)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -54,6 +79,24 @@ func (m *MockImageProcessorService) DeleteImage(id uuid.UUID) error {
 	return args.Error(0)
 }
 
+func (m *MockImageProcessorService) CreateOverlay(data []byte) (*uuid.UUID, error) {
+	args := m.Called(data)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	id := args.Get(0).(*uuid.UUID)
+	return id, args.Error(1)
+}
+
+func (m *MockImageProcessorService) CreateFont(data []byte) (*uuid.UUID, error) {
+	args := m.Called(data)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	id := args.Get(0).(*uuid.UUID)
+	return id, args.Error(1)
+}
+
 type HandlerTestSuite struct {
 	suite.Suite
 	handler     *Handler
@@ -100,18 +143,17 @@ func (suite *HandlerTestSuite) createGinContext(req *http.Request) (*gin.Context
 func (suite *HandlerTestSuite) TestCreateImage_Success() {
 	imageData := []byte("fake image data")
 	metadata := dto.Message{
-		Task: "resize",
-		Resize: struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		}{
-			Width:  100,
-			Height: 100,
+		Operation: dto.Operation{
+			Task: "resize",
+			Resize: dto.Resize{
+				Width:  100,
+				Height: 100,
+			},
 		},
 	}
 
 	expectedID := uuid.New()
-	suite.mockService.On("CreateImage", imageData, metadata).Return(&expectedID, nil)
+	suite.mockService.On("CreateImage", imageData, "test.jpg", metadata).Return(&expectedID, nil)
 
 	req, _ := suite.createMultipartRequest(imageData, metadata)
 	c, w := suite.createGinContext(req)
@@ -171,17 +213,16 @@ func (suite *HandlerTestSuite) TestCreateImage_InvalidMetadata() {
 func (suite *HandlerTestSuite) TestCreateImage_ServiceError_InvalidFormat() {
 	imageData := []byte("fake image data")
 	metadata := dto.Message{
-		Task: "resize",
-		Resize: struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		}{
-			Width:  100,
-			Height: 100,
+		Operation: dto.Operation{
+			Task: "resize",
+			Resize: dto.Resize{
+				Width:  100,
+				Height: 100,
+			},
 		},
 	}
 
-	suite.mockService.On("CreateImage", imageData, metadata).Return(nil, service.ErrInvalidImageFormat)
+	suite.mockService.On("CreateImage", imageData, "test.jpg", metadata).Return(nil, service.ErrInvalidImageFormat)
 
 	req, _ := suite.createMultipartRequest(imageData, metadata)
 	c, w := suite.createGinContext(req)
@@ -199,17 +240,16 @@ func (suite *HandlerTestSuite) TestCreateImage_ServiceError_InvalidFormat() {
 func (suite *HandlerTestSuite) TestCreateImage_ServiceError_Internal() {
 	imageData := []byte("fake image data")
 	metadata := dto.Message{
-		Task: "resize",
-		Resize: struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		}{
-			Width:  100,
-			Height: 100,
+		Operation: dto.Operation{
+			Task: "resize",
+			Resize: dto.Resize{
+				Width:  100,
+				Height: 100,
+			},
 		},
 	}
 
-	suite.mockService.On("CreateImage", imageData, metadata).Return(nil, errors.New("internal error"))
+	suite.mockService.On("CreateImage", imageData, "test.jpg", metadata).Return(nil, errors.New("internal error"))
 
 	req, _ := suite.createMultipartRequest(imageData, metadata)
 	c, w := suite.createGinContext(req)
@@ -224,6 +264,67 @@ func (suite *HandlerTestSuite) TestCreateImage_ServiceError_Internal() {
 	suite.mockService.AssertExpectations(suite.T())
 }
 
+func (suite *HandlerTestSuite) TestCreateOverlay_Success() {
+	imageData := []byte("fake overlay data")
+	expectedID := uuid.New()
+	suite.mockService.On("CreateOverlay", imageData).Return(&expectedID, nil)
+
+	req, _ := suite.createMultipartRequest(imageData, dto.Message{})
+	c, w := suite.createGinContext(req)
+
+	suite.handler.CreateOverlay(c)
+
+	suite.Equal(http.StatusOK, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal(expectedID.String(), response["id"])
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestCreateOverlay_InvalidOverlay() {
+	imageData := []byte("fake overlay data")
+	suite.mockService.On("CreateOverlay", imageData).Return(nil, service.ErrInvalidOverlay)
+
+	req, _ := suite.createMultipartRequest(imageData, dto.Message{})
+	c, w := suite.createGinContext(req)
+
+	suite.handler.CreateOverlay(c)
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Contains(response["error"], "invalid overlay")
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestCreateFont_InvalidFont() {
+	fontData := []byte("fake font data")
+	suite.mockService.On("CreateFont", fontData).Return(nil, service.ErrInvalidFont)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	filePart, err := writer.CreateFormFile("font", "font.ttf")
+	suite.Require().NoError(err)
+	_, err = filePart.Write(fontData)
+	suite.Require().NoError(err)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/font", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	c, w := suite.createGinContext(req)
+
+	suite.handler.CreateFont(c)
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+	var response map[string]string
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Contains(response["error"], "invalid font")
+	suite.mockService.AssertExpectations(suite.T())
+}
+
 func (suite *HandlerTestSuite) TestGetImageByID_InvalidUUID() {
 	req := httptest.NewRequest("GET", "/image/invalid-uuid", nil)
 	c, w := suite.createGinContext(req)
@@ -240,7 +341,7 @@ func (suite *HandlerTestSuite) TestGetImageByID_InvalidUUID() {
 
 func (suite *HandlerTestSuite) TestGetImageByID_NotProcessedYet() {
 	testID := uuid.New()
-	suite.mockService.On("GetImageById", testID).Return("", service.ErrNotProcessdYet)
+	suite.mockService.On("GetImageById", testID, "").Return("", service.ErrNotProcessdYet)
 
 	req := httptest.NewRequest("GET", "/image/"+testID.String(), nil)
 	c, w := suite.createGinContext(req)
@@ -256,9 +357,27 @@ func (suite *HandlerTestSuite) TestGetImageByID_NotProcessedYet() {
 	suite.mockService.AssertExpectations(suite.T())
 }
 
+func (suite *HandlerTestSuite) TestGetImageByID_NoSuchVariant() {
+	testID := uuid.New()
+	suite.mockService.On("GetImageById", testID, "huge").Return("", service.ErrNoSuchVariant)
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"?variant=huge", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetImageByID(c)
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal(service.ErrNoSuchVariant.Error(), response["error"])
+	suite.mockService.AssertExpectations(suite.T())
+}
+
 func (suite *HandlerTestSuite) TestGetImageByID_ServiceError() {
 	testID := uuid.New()
-	suite.mockService.On("GetImageById", testID).Return("", errors.New("service error"))
+	suite.mockService.On("GetImageById", testID, "").Return("", errors.New("service error"))
 
 	req := httptest.NewRequest("GET", "/image/"+testID.String(), nil)
 	c, w := suite.createGinContext(req)
@@ -348,12 +467,209 @@ func (suite *HandlerTestSuite) TestGetImageInfo_ServiceError() {
 	suite.mockService.AssertExpectations(suite.T())
 }
 
+func (suite *HandlerTestSuite) TestGetImageMetadata_Success() {
+	testID := uuid.New()
+	expected := &model.Metadata{
+		EXIF: &model.EXIF{Make: "Canon", GPS: &model.GPS{Latitude: 55.75, Longitude: 37.62}},
+		IPTC: map[string][]string{"keywords": {"sunset", "beach"}},
+	}
+
+	suite.mockService.On("GetImageMetadata", testID).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/metadata", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetImageMetadata(c)
+
+	suite.Equal(http.StatusOK, w.Code)
+	var response model.Metadata
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal(*expected, response)
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestGetImageMetadata_InvalidUUID() {
+	req := httptest.NewRequest("GET", "/image/invalid-uuid/metadata", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: "invalid-uuid"}}
+
+	suite.handler.GetImageMetadata(c)
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal("invalid id was provided", response["error"])
+}
+
+func (suite *HandlerTestSuite) TestGetImageMetadata_NotProcessedYet() {
+	testID := uuid.New()
+	suite.mockService.On("GetImageMetadata", testID).Return(nil, service.ErrNotProcessdYet)
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/metadata", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetImageMetadata(c)
+
+	suite.Equal(http.StatusOK, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal("in processing, not ready yet", response["status"])
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestGetImageMetadata_NoSuchImage() {
+	testID := uuid.New()
+	suite.mockService.On("GetImageMetadata", testID).Return(nil, repository.ErrNoSuchImage)
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/metadata", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetImageMetadata(c)
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal(repository.ErrNoSuchImage.Error(), response["error"])
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestGetImageMetadata_ServiceError() {
+	testID := uuid.New()
+	suite.mockService.On("GetImageMetadata", testID).Return(nil, errors.New("service error"))
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/metadata", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetImageMetadata(c)
+
+	suite.Equal(http.StatusInternalServerError, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal("could not get image metadata", response["error"])
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestGetSimilarImages_Success() {
+	testID := uuid.New()
+	expected := []model.SimilarImage{{ID: uuid.New(), Distance: 3, AHashDistance: 5, DHashDistance: 4}}
+	suite.mockService.On("GetSimilarImages", testID, 4).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/similar?max_distance=4", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetSimilarImages(c)
+
+	suite.Equal(http.StatusOK, w.Code)
+	var response []model.SimilarImage
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal(expected[0].ID, response[0].ID)
+	suite.Equal(3, response[0].Distance)
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestGetSimilarImages_DefaultDistance() {
+	testID := uuid.New()
+	suite.mockService.On("GetSimilarImages", testID, service.DefaultMaxDistance).Return([]model.SimilarImage{}, nil)
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/similar", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetSimilarImages(c)
+
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("[]", w.Body.String())
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestGetSimilarImages_InvalidDistance() {
+	testID := uuid.New()
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/similar?max_distance=close", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetSimilarImages(c)
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal("invalid max_distance was provided", response["error"])
+}
+
+func (suite *HandlerTestSuite) TestGetSimilarImages_NotProcessedYet() {
+	testID := uuid.New()
+	suite.mockService.On("GetSimilarImages", testID, service.DefaultMaxDistance).Return(nil, service.ErrNotProcessdYet)
+
+	req := httptest.NewRequest("GET", "/image/"+testID.String()+"/similar", nil)
+	c, w := suite.createGinContext(req)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+
+	suite.handler.GetSimilarImages(c)
+
+	suite.Equal(http.StatusOK, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal("in processing, not ready yet", response["status"])
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestSearchSimilarImages_Success() {
+	imageData := []byte("fake image data")
+	expected := []model.SimilarImage{{ID: uuid.New(), Distance: 0}}
+	suite.mockService.On("SearchSimilarImages", imageData, 6).Return(expected, nil)
+
+	req, _ := suite.createMultipartRequest(imageData, dto.Message{})
+	req.URL.RawQuery = "max_distance=6"
+	c, w := suite.createGinContext(req)
+
+	suite.handler.SearchSimilarImages(c)
+
+	suite.Equal(http.StatusOK, w.Code)
+	var response []model.SimilarImage
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Equal(expected[0].ID, response[0].ID)
+	suite.mockService.AssertExpectations(suite.T())
+}
+
+func (suite *HandlerTestSuite) TestSearchSimilarImages_InvalidImage() {
+	imageData := []byte("fake image data")
+	suite.mockService.On("SearchSimilarImages", imageData, service.DefaultMaxDistance).Return(nil, service.ErrInvalidImage)
+
+	req, _ := suite.createMultipartRequest(imageData, dto.Message{})
+	c, w := suite.createGinContext(req)
+
+	suite.handler.SearchSimilarImages(c)
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	suite.NoError(err)
+	suite.Contains(response["error"], "invalid image")
+	suite.mockService.AssertExpectations(suite.T())
+}
+
 func (suite *HandlerTestSuite) TestGetMainPage_Success() {
 	gin.SetMode(gin.TestMode)
 	req := httptest.NewRequest("GET", "/", nil)
-	c, w := suite.createGinContext(req)
-
-	gin.DefaultWriter = w
+	w := httptest.NewRecorder()
+	c, engine := gin.CreateTestContext(w)
+	c.Request = req
+	engine.LoadHTMLFiles("../../static/index.html")
 
 	suite.handler.GetMainPage(c)
 
@@ -410,3 +726,7 @@ func (suite *HandlerTestSuite) TestDeleteImageByID_ServiceError() {
 	suite.Equal("could not delete image: delete error", response["error"])
 	suite.mockService.AssertExpectations(suite.T())
 }
+
+func TestHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(HandlerTestSuite))
+}
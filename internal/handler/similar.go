@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	_ "github.com/Komilov31/image-processor/internal/model"
+	repository "github.com/Komilov31/image-processor/internal/repository/db"
+	"github.com/Komilov31/image-processor/internal/service"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// GetSimilarImages godoc
+// @Summary      Find near duplicates of an image
+// @Description  Find images whose perceptual hash is close to the one of the image, ranked by Hamming distance. Hashes are computed when the image is picked up for processing
+// @Tags         images
+// @Accept       json
+// @Produce      json
+// @Param        id           path     string  true  "Image ID"
+// @Param        max_distance query    int     false "Maximum Hamming distance between the pHashes, from 0 to 64, 10 by default"
+// @Success      200  {array}  model.SimilarImage "Similar images"
+// @Failure      400  {object} map[string]string "error"
+// @Failure      500  {object} map[string]string "error"
+// @Router       /image/{id}/similar [get]
+func (h *Handler) GetSimilarImages(c *ginext.Context) {
+	uid := c.Param("id")
+	id, err := uuid.Parse(uid)
+	if err != nil {
+		zlog.Logger.Error().Msg("could not parse id to uuid: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid id was provided"})
+		return
+	}
+
+	maxDistance, err := parseMaxDistance(c)
+	if err != nil {
+		zlog.Logger.Error().Msg("could not parse max distance: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid max_distance was provided"})
+		return
+	}
+
+	similar, err := h.service.GetSimilarImages(id, maxDistance)
+	if err != nil {
+		if errors.Is(err, service.ErrNotProcessdYet) {
+			c.JSON(http.StatusOK, ginext.H{"status": "in processing, not ready yet"})
+			return
+		}
+
+		if errors.Is(err, repository.ErrNoSuchImage) || errors.Is(err, service.ErrInvalidSimilarSearch) {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+			return
+		}
+
+		zlog.Logger.Error().Msg("could not get similar images: " + err.Error())
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": "could not get similar images"})
+		return
+	}
+
+	zlog.Logger.Info().Msg("sucessfully handled GET request and returned similar images to user")
+	c.JSON(http.StatusOK, similar)
+}
+
+// SearchSimilarImages godoc
+// @Summary      Search near duplicates of an uploaded image
+// @Description  Find stored images whose perceptual hash is close to the one of the uploaded image, ranked by Hamming distance. The uploaded image is not stored
+// @Tags         images
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        image        formData file    true  "Image file to search for"
+// @Param        max_distance query    int     false "Maximum Hamming distance between the pHashes, from 0 to 64, 10 by default"
+// @Success      200  {array}  model.SimilarImage "Similar images"
+// @Failure      400  {object} map[string]string "error"
+// @Failure      500  {object} map[string]string "error"
+// @Router       /search/similar [post]
+func (h *Handler) SearchSimilarImages(c *ginext.Context) {
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		zlog.Logger.Error().Msg("invalid image: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid image: " + err.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		zlog.Logger.Error().Msg("could not open the image: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "could not open the image"})
+		return
+	}
+	defer file.Close()
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		zlog.Logger.Error().Msg("could not open the image: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "could not open the image"})
+		return
+	}
+
+	maxDistance, err := parseMaxDistance(c)
+	if err != nil {
+		zlog.Logger.Error().Msg("could not parse max distance: " + err.Error())
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid max_distance was provided"})
+		return
+	}
+
+	similar, err := h.service.SearchSimilarImages(fileBytes, maxDistance)
+	if err != nil {
+		if isInvalidRequest(err) {
+			zlog.Logger.Error().Msg("could not search similar images: " + err.Error())
+			c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		zlog.Logger.Error().Msg("could not search similar images: " + err.Error())
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": "could not search similar images"})
+		return
+	}
+
+	zlog.Logger.Info().Msg("sucessfully handled POST request and returned similar images to user")
+	c.JSON(http.StatusOK, similar)
+}
+
+// parseMaxDistance reads the max_distance query parameter, searches without
+// it use the default distance.
+func parseMaxDistance(c *ginext.Context) (int, error) {
+	value := c.Query("max_distance")
+	if value == "" {
+		return service.DefaultMaxDistance, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -7,8 +7,87 @@ import (
 )
 
 type Image struct {
-	ID       uuid.UUID `json:"id"`
-	Format   string    `json:"-"`
-	Status   string    `json:"status"`
-	CreateAt time.Time `json:"create_at"`
+	ID           uuid.UUID `json:"id"`
+	Format       string    `json:"-"`
+	OutputFormat string    `json:"output_format"`
+	Status       string    `json:"status"`
+	CreateAt     time.Time `json:"create_at"`
+	ColorSpace   string    `json:"color_space,omitempty"`
+	Original     *File     `json:"original,omitempty"`
+	Processed    *File     `json:"processed,omitempty"`
+	Variants     []Variant `json:"variants,omitempty"`
+	Metadata     *Metadata `json:"metadata,omitempty"`
+	Hashes       *Hashes   `json:"-"`
+}
+
+type Variant struct {
+	Name      string `json:"name"`
+	Format    string `json:"format"`
+	Status    string `json:"status"`
+	Processed *File  `json:"processed,omitempty"`
+}
+
+// Hashes are the perceptual hashes of the original image: the average hash,
+// the difference hash and the DCT based hash.
+type Hashes struct {
+	AHash uint64
+	DHash uint64
+	PHash uint64
+}
+
+// SimilarImage is an image found by a similarity search. Distance is the
+// Hamming distance between the pHashes, the other distances are reported
+// for reference.
+type SimilarImage struct {
+	ID            uuid.UUID `json:"id"`
+	CreateAt      time.Time `json:"create_at"`
+	Distance      int       `json:"distance"`
+	AHashDistance int       `json:"ahash_distance"`
+	DHashDistance int       `json:"dhash_distance"`
+}
+
+// File describes a stored image file. Size is in bytes, SHA256 is the hex
+// encoded digest of the file. Name is the file name of the upload and is only
+// known for originals.
+type File struct {
+	Name     string `json:"name,omitempty"`
+	MIMEType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// Metadata is the descriptive information embedded in the original image.
+// IPTC datasets and XMP properties are keyed by their names, XMP names carry
+// the conventional prefix of their namespace, e.g. "dc:title".
+type Metadata struct {
+	EXIF *EXIF               `json:"exif,omitempty"`
+	IPTC map[string][]string `json:"iptc,omitempty"`
+	XMP  map[string][]string `json:"xmp,omitempty"`
+}
+
+type EXIF struct {
+	Make         string  `json:"make,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	LensMake     string  `json:"lens_make,omitempty"`
+	LensModel    string  `json:"lens_model,omitempty"`
+	Software     string  `json:"software,omitempty"`
+	Artist       string  `json:"artist,omitempty"`
+	Copyright    string  `json:"copyright,omitempty"`
+	CaptureTime  string  `json:"capture_time,omitempty"`
+	Orientation  int     `json:"orientation,omitempty"`
+	ExposureTime string  `json:"exposure_time,omitempty"`
+	FNumber      float64 `json:"f_number,omitempty"`
+	ISO          int     `json:"iso,omitempty"`
+	FocalLength  float64 `json:"focal_length,omitempty"`
+	GPS          *GPS    `json:"gps,omitempty"`
+}
+
+// GPS is the position the image was taken at, in decimal degrees and metres
+// above sea level.
+type GPS struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
 }
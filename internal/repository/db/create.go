@@ -7,12 +7,39 @@ import (
 )
 
 func (p *Postgres) CreateImage(image model.Image) error {
-	query := "INSERT INTO images(id, format, status) VALUES ($1, $2, $3)"
+	tx, err := p.db.Master.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var original model.File
+	if image.Original != nil {
+		original = *image.Original
+	}
+
+	query := `INSERT INTO images(id, format, output_format, status, color_space,
+	original_name, original_mime_type, original_width, original_height, original_size, original_sha256)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 
-	_, err := p.db.Master.Exec(query, image.ID, image.Format, image.Status)
+	_, err = tx.Exec(query, image.ID, image.Format, image.OutputFormat, image.Status, image.ColorSpace,
+		original.Name, original.MIMEType, original.Width, original.Height, original.Size, original.SHA256)
 	if err != nil {
 		return fmt.Errorf("could not save image info in db: %w", err)
 	}
 
+	query = "INSERT INTO image_variants(image_id, name, position, format, status) VALUES ($1, $2, $3, $4, $5)"
+
+	for i, variant := range image.Variants {
+		_, err = tx.Exec(query, image.ID, variant.Name, i, variant.Format, variant.Status)
+		if err != nil {
+			return fmt.Errorf("could not save image variant info in db: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
 	return nil
 }
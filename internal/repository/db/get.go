@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 
 	"github.com/Komilov31/image-processor/internal/model"
@@ -9,14 +10,39 @@ import (
 )
 
 func (p *Postgres) GetImageInfo(id uuid.UUID) (*model.Image, error) {
-	query := "SELECT * FROM images WHERE id = $1"
+	query := `SELECT id, format, COALESCE(output_format, format), status, created_at, COALESCE(color_space, ''), metadata,
+	COALESCE(original_name, ''), COALESCE(original_mime_type, ''), COALESCE(original_width, 0),
+	COALESCE(original_height, 0), COALESCE(original_size, 0), COALESCE(original_sha256, ''),
+	COALESCE(processed_mime_type, ''), COALESCE(processed_width, 0), COALESCE(processed_height, 0),
+	COALESCE(processed_size, 0), COALESCE(processed_sha256, ''), ahash, dhash, phash
+	FROM images WHERE id = $1`
 
 	var image model.Image
+	var metadata []byte
+	var original, processed model.File
+	var aHash, dHash, pHash sql.NullInt64
 	err := p.db.Master.QueryRow(query, id).Scan(
 		&image.ID,
 		&image.Format,
+		&image.OutputFormat,
 		&image.Status,
 		&image.CreateAt,
+		&image.ColorSpace,
+		&metadata,
+		&original.Name,
+		&original.MIMEType,
+		&original.Width,
+		&original.Height,
+		&original.Size,
+		&original.SHA256,
+		&processed.MIMEType,
+		&processed.Width,
+		&processed.Height,
+		&processed.Size,
+		&processed.SHA256,
+		&aHash,
+		&dHash,
+		&pHash,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -26,5 +52,114 @@ func (p *Postgres) GetImageInfo(id uuid.UUID) (*model.Image, error) {
 		return nil, fmt.Errorf("could not get image from db: %w", err)
 	}
 
+	image.Original = recordedFile(original)
+	image.Processed = recordedFile(processed)
+
+	if aHash.Valid && dHash.Valid && pHash.Valid {
+		image.Hashes = &model.Hashes{
+			AHash: uint64(aHash.Int64),
+			DHash: uint64(dHash.Int64),
+			PHash: uint64(pHash.Int64),
+		}
+	}
+
+	if metadata != nil {
+		image.Metadata = &model.Metadata{}
+		if err := json.Unmarshal(metadata, image.Metadata); err != nil {
+			return nil, fmt.Errorf("could not decode image metadata: %w", err)
+		}
+	}
+
+	variants, err := p.getImageVariants(id)
+	if err != nil {
+		return nil, err
+	}
+	image.Variants = variants
+
 	return &image, nil
 }
+
+func (p *Postgres) getImageVariants(id uuid.UUID) ([]model.Variant, error) {
+	query := `SELECT v.name, COALESCE(v.format, i.format), v.status,
+	COALESCE(v.mime_type, ''), COALESCE(v.width, 0), COALESCE(v.height, 0), COALESCE(v.size, 0), COALESCE(v.sha256, '')
+	FROM image_variants v JOIN images i ON i.id = v.image_id WHERE v.image_id = $1 ORDER BY v.position`
+
+	rows, err := p.db.Master.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get image variants from db: %w", err)
+	}
+	defer rows.Close()
+
+	var variants []model.Variant
+	for rows.Next() {
+		var variant model.Variant
+		var processed model.File
+		err := rows.Scan(
+			&variant.Name,
+			&variant.Format,
+			&variant.Status,
+			&processed.MIMEType,
+			&processed.Width,
+			&processed.Height,
+			&processed.Size,
+			&processed.SHA256,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan image variant: %w", err)
+		}
+		variant.Processed = recordedFile(processed)
+		variants = append(variants, variant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get image variants from db: %w", err)
+	}
+
+	return variants, nil
+}
+
+// FindSimilarImages returns the images whose pHash differs from the given one
+// in at most maxDistance bits, nearest first. The excluded image is the one
+// the search is made for.
+func (p *Postgres) FindSimilarImages(hashes model.Hashes, maxDistance int, exclude uuid.UUID) ([]model.SimilarImage, error) {
+	query := `SELECT id, created_at, distance, ahash_distance, dhash_distance FROM (
+		SELECT id, created_at,
+		bit_count((phash # $1)::bit(64)) AS distance,
+		bit_count((ahash # $2)::bit(64)) AS ahash_distance,
+		bit_count((dhash # $3)::bit(64)) AS dhash_distance
+		FROM images WHERE phash IS NOT NULL AND id <> $4
+	) AS candidates
+	WHERE distance <= $5
+	ORDER BY distance, dhash_distance, ahash_distance, created_at
+	LIMIT $6`
+
+	rows, err := p.db.Master.Query(query, int64(hashes.PHash), int64(hashes.AHash), int64(hashes.DHash), exclude, maxDistance, similarImagesLimit)
+	if err != nil {
+		return nil, fmt.Errorf("could not get similar images from db: %w", err)
+	}
+	defer rows.Close()
+
+	similar := []model.SimilarImage{}
+	for rows.Next() {
+		var image model.SimilarImage
+		if err := rows.Scan(&image.ID, &image.CreateAt, &image.Distance, &image.AHashDistance, &image.DHashDistance); err != nil {
+			return nil, fmt.Errorf("could not scan similar image: %w", err)
+		}
+		similar = append(similar, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get similar images from db: %w", err)
+	}
+
+	return similar, nil
+}
+
+// recordedFile returns nil for files that were not recorded: images uploaded
+// before file information was stored and outputs that are not processed yet.
+func recordedFile(file model.File) *model.File {
+	if file.SHA256 == "" {
+		return nil
+	}
+	return &file
+}
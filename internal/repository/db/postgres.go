@@ -10,6 +10,9 @@ var (
 	ErrNoSuchImage = errors.New("there is no image with such id")
 )
 
+// similarImagesLimit caps the number of images a similarity search returns.
+const similarImagesLimit = 50
+
 type Postgres struct {
 	db *dbpg.DB
 }
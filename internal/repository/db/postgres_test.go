@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Komilov31/image-processor/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+const migrationsDir = "../../../migrations"
+
+// imageStatuses are the statuses the service writes to images.
+var imageStatuses = []string{"in progress", "finished", "failed"}
+
+// migrations returns the goose sections of every migration in the order they
+// are applied.
+func migrations(t *testing.T) (up, down []string) {
+	files, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	sort.Strings(files)
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		sections := strings.SplitN(string(data), "-- +goose Down", 2)
+		require.Len(t, sections, 2, file)
+		up = append(up, strings.TrimPrefix(sections[0], "-- +goose Up"))
+		down = append([]string{sections[1]}, down...)
+	}
+
+	return up, down
+}
+
+func TestMigrations_ImageStatus(t *testing.T) {
+	up, _ := migrations(t)
+
+	// The last status check of the images table is the one in effect.
+	table := regexp.MustCompile(`(?:CREATE TABLE IF NOT EXISTS|ALTER TABLE) (\w+)`)
+	check := regexp.MustCompile(`CHECK \(status IN \(([^)]*)\)\)`)
+
+	var current, allowed string
+	for _, migration := range up {
+		for _, line := range strings.Split(migration, "\n") {
+			if match := table.FindStringSubmatch(line); match != nil {
+				current = match[1]
+			}
+			if match := check.FindStringSubmatch(line); match != nil && current == "images" {
+				allowed = match[1]
+			}
+		}
+	}
+
+	for _, status := range imageStatuses {
+		assert.Contains(t, allowed, "'"+status+"'")
+	}
+}
+
+// TestPostgres_UpdateImageStatus runs the migrations against the database of
+// TEST_DATABASE_URL, which must be an empty database that can be written to.
+func TestPostgres_UpdateImageStatus(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := dbpg.New(dsn, nil, nil)
+	require.NoError(t, err)
+	defer db.Master.Close()
+
+	up, down := migrations(t)
+	for _, migration := range up {
+		_, err := db.Master.Exec(migration)
+		require.NoError(t, err)
+	}
+	defer func() {
+		for _, migration := range down {
+			_, err := db.Master.Exec(migration)
+			assert.NoError(t, err)
+		}
+	}()
+
+	repo := NewPostgres(db)
+	id := uuid.New()
+	require.NoError(t, repo.CreateImage(model.Image{ID: id, Format: "jpeg", OutputFormat: "jpeg", Status: "in progress"}))
+
+	for _, status := range imageStatuses {
+		require.NoError(t, repo.UpdateImageStatus(id, status))
+
+		image, err := repo.GetImageInfo(id)
+		require.NoError(t, err)
+		assert.Equal(t, status, image.Status)
+	}
+
+	assert.ErrorIs(t, repo.UpdateImageStatus(uuid.New(), "failed"), ErrNoSuchImage)
+}
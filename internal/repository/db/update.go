@@ -1,8 +1,10 @@
 package repository
 
 import (
+	"encoding/json"
 	"fmt"
 
+	"github.com/Komilov31/image-processor/internal/model"
 	"github.com/google/uuid"
 )
 
@@ -27,3 +29,120 @@ func (p *Postgres) UpdateImageStatus(id uuid.UUID, newStatus string) error {
 
 	return nil
 }
+
+func (p *Postgres) UpdateVariantStatus(id uuid.UUID, name, newStatus string) error {
+	query := `UPDATE image_variants
+	SET status = $1
+	WHERE image_id = $2 AND name = $3`
+
+	result, err := p.db.Master.Exec(query, newStatus, id, name)
+	if err != nil {
+		return fmt.Errorf("could not update image variant status: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update image variant status: %w", err)
+	}
+
+	if affected == 0 {
+		return ErrNoSuchImage
+	}
+
+	return nil
+}
+
+func (p *Postgres) UpdateImageMetadata(id uuid.UUID, metadata *model.Metadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("could not encode image metadata: %w", err)
+	}
+
+	query := `UPDATE images
+	SET metadata = $1
+	WHERE id = $2`
+
+	// lib/pq sends byte slices as bytea, JSONB takes the text form.
+	result, err := p.db.Master.Exec(query, string(data), id)
+	if err != nil {
+		return fmt.Errorf("could not update image metadata: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update image metadata: %w", err)
+	}
+
+	if affected == 0 {
+		return ErrNoSuchImage
+	}
+
+	return nil
+}
+
+func (p *Postgres) UpdateProcessedFile(id uuid.UUID, file model.File) error {
+	query := `UPDATE images
+	SET processed_mime_type = $1, processed_width = $2, processed_height = $3, processed_size = $4, processed_sha256 = $5
+	WHERE id = $6`
+
+	result, err := p.db.Master.Exec(query, file.MIMEType, file.Width, file.Height, file.Size, file.SHA256, id)
+	if err != nil {
+		return fmt.Errorf("could not update processed file info: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update processed file info: %w", err)
+	}
+
+	if affected == 0 {
+		return ErrNoSuchImage
+	}
+
+	return nil
+}
+
+func (p *Postgres) UpdateVariantFile(id uuid.UUID, name string, file model.File) error {
+	query := `UPDATE image_variants
+	SET mime_type = $1, width = $2, height = $3, size = $4, sha256 = $5
+	WHERE image_id = $6 AND name = $7`
+
+	result, err := p.db.Master.Exec(query, file.MIMEType, file.Width, file.Height, file.Size, file.SHA256, id, name)
+	if err != nil {
+		return fmt.Errorf("could not update image variant file info: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update image variant file info: %w", err)
+	}
+
+	if affected == 0 {
+		return ErrNoSuchImage
+	}
+
+	return nil
+}
+
+func (p *Postgres) UpdateImageHashes(id uuid.UUID, hashes model.Hashes) error {
+	query := `UPDATE images
+	SET ahash = $1, dhash = $2, phash = $3
+	WHERE id = $4`
+
+	// The hashes are stored bit for bit in signed BIGINT columns.
+	result, err := p.db.Master.Exec(query, int64(hashes.AHash), int64(hashes.DHash), int64(hashes.PHash), id)
+	if err != nil {
+		return fmt.Errorf("could not update image hashes: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update image hashes: %w", err)
+	}
+
+	if affected == 0 {
+		return ErrNoSuchImage
+	}
+
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"mime"
 	"os"
+	"path/filepath"
 
 	"github.com/Komilov31/image-processor/internal/config"
 	"github.com/minio/minio-go/v7"
@@ -35,6 +37,8 @@ func New() *Minio {
 
 	originalExists, _ := minioClient.BucketExists(ctx, "images")
 	processedExists, _ := minioClient.BucketExists(ctx, "processed")
+	overlaysExists, _ := minioClient.BucketExists(ctx, "overlays")
+	fontsExists, _ := minioClient.BucketExists(ctx, "fonts")
 
 	if !originalExists {
 		err = minioClient.MakeBucket(ctx, "images", minio.MakeBucketOptions{Region: "ru-moscow"})
@@ -50,6 +54,20 @@ func New() *Minio {
 		}
 	}
 
+	if !overlaysExists {
+		err = minioClient.MakeBucket(ctx, "overlays", minio.MakeBucketOptions{Region: "ru-moscow"})
+		if err != nil {
+			log.Fatal("could not create bucket in minio to save overlays: ", err)
+		}
+	}
+
+	if !fontsExists {
+		err = minioClient.MakeBucket(ctx, "fonts", minio.MakeBucketOptions{Region: "ru-moscow"})
+		if err != nil {
+			log.Fatal("could not create bucket in minio to save fonts: ", err)
+		}
+	}
+
 	return &m
 }
 
@@ -59,7 +77,7 @@ func (m *Minio) SaveImage(fileName, filePath, bucketName string) error {
 		bucketName,
 		fileName,
 		filePath,
-		minio.PutObjectOptions{},
+		minio.PutObjectOptions{ContentType: mime.TypeByExtension(filepath.Ext(fileName))},
 	)
 	if err != nil {
 		return fmt.Errorf("could not save image in minio: %w", err)
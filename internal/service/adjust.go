@@ -0,0 +1,178 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+)
+
+const (
+	maxGamma    = 10
+	maxHueShift = 180
+)
+
+// Luminance weights of the colour matrices, the same as in the CSS filter
+// effects specification.
+const (
+	lumR = 0.2126
+	lumG = 0.7152
+	lumB = 0.0722
+)
+
+// colorMatrix transforms the RGB channels of a pixel, each row is one output channel.
+type colorMatrix [3][3]float64
+
+func isCorrectAdjust(opts dto.Adjust) bool {
+	values := []float64{opts.Brightness, opts.Contrast, opts.Gamma, opts.Saturation, opts.Hue, opts.Grayscale, opts.Sepia, opts.Invert}
+	for _, value := range values {
+		if math.IsNaN(value) {
+			return false
+		}
+	}
+
+	if opts == (dto.Adjust{}) {
+		return false
+	}
+
+	if math.Abs(opts.Brightness) > 1 || math.Abs(opts.Contrast) > 1 || math.Abs(opts.Saturation) > 1 {
+		return false
+	}
+
+	if opts.Gamma < 0 || opts.Gamma > maxGamma || math.Abs(opts.Hue) > maxHueShift {
+		return false
+	}
+
+	for _, amount := range []float64{opts.Grayscale, opts.Sepia, opts.Invert} {
+		if amount < 0 || amount > 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// adjustImage applies the colour adjustments in a fixed order: brightness,
+// contrast and gamma, then saturation, hue, grayscale and sepia, and finally
+// inversion. Values are clamped after every step and alpha is kept.
+func adjustImage(src image.Image, opts dto.Adjust) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+
+	levels := adjustLevels(opts)
+	matrices := adjustMatrices(opts)
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if dst.Pix[i+3] == 0 {
+			continue
+		}
+
+		rgb := [3]float64{levels[dst.Pix[i]], levels[dst.Pix[i+1]], levels[dst.Pix[i+2]]}
+		for _, m := range matrices {
+			rgb = m.apply(rgb)
+		}
+
+		for c := range 3 {
+			v := rgb[c]
+			if opts.Invert != 0 {
+				v += (1 - 2*v) * opts.Invert
+			}
+			dst.Pix[i+c] = uint8(math.Round(clamp01(v) * 0xff))
+		}
+	}
+
+	return dst
+}
+
+// adjustLevels returns the lookup table of brightness, contrast and gamma,
+// which change every channel independently.
+func adjustLevels(opts dto.Adjust) [256]float64 {
+	var levels [256]float64
+	for i := range levels {
+		v := float64(i) / 0xff
+
+		v = clamp01(v * (1 + opts.Brightness))
+		v = clamp01((v-0.5)*(1+opts.Contrast) + 0.5)
+		if opts.Gamma != 0 {
+			v = math.Pow(v, 1/opts.Gamma)
+		}
+
+		levels[i] = v
+	}
+	return levels
+}
+
+// adjustMatrices returns the colour matrices of the adjustments that mix
+// channels, skipping the ones that are not set.
+func adjustMatrices(opts dto.Adjust) []colorMatrix {
+	var matrices []colorMatrix
+	if opts.Saturation != 0 {
+		matrices = append(matrices, saturateMatrix(1+opts.Saturation))
+	}
+	if opts.Hue != 0 {
+		matrices = append(matrices, hueRotateMatrix(opts.Hue))
+	}
+	if opts.Grayscale != 0 {
+		matrices = append(matrices, grayscaleMatrix(opts.Grayscale))
+	}
+	if opts.Sepia != 0 {
+		matrices = append(matrices, sepiaMatrix(opts.Sepia))
+	}
+	return matrices
+}
+
+func (m colorMatrix) apply(rgb [3]float64) [3]float64 {
+	var out [3]float64
+	for row := range 3 {
+		out[row] = clamp01(m[row][0]*rgb[0] + m[row][1]*rgb[1] + m[row][2]*rgb[2])
+	}
+	return out
+}
+
+func saturateMatrix(s float64) colorMatrix {
+	return colorMatrix{
+		{lumR + (1-lumR)*s, lumG - lumG*s, lumB - lumB*s},
+		{lumR - lumR*s, lumG + (1-lumG)*s, lumB - lumB*s},
+		{lumR - lumR*s, lumG - lumG*s, lumB + (1-lumB)*s},
+	}
+}
+
+func hueRotateMatrix(degrees float64) colorMatrix {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return colorMatrix{
+		{lumR + cos*(1-lumR) - sin*lumR, lumG - cos*lumG - sin*lumG, lumB - cos*lumB + sin*(1-lumB)},
+		{lumR - cos*lumR + sin*0.143, lumG + cos*(1-lumG) + sin*0.140, lumB - cos*lumB - sin*0.283},
+		{lumR - cos*lumR - sin*(1-lumR), lumG - cos*lumG + sin*lumG, lumB + cos*(1-lumB) + sin*lumB},
+	}
+}
+
+// grayscaleMatrix is the saturation matrix with the saturation reduced by amount.
+func grayscaleMatrix(amount float64) colorMatrix {
+	return saturateMatrix(1 - amount)
+}
+
+func sepiaMatrix(amount float64) colorMatrix {
+	sepia := colorMatrix{
+		{0.393, 0.769, 0.189},
+		{0.349, 0.686, 0.168},
+		{0.272, 0.534, 0.131},
+	}
+
+	var m colorMatrix
+	for row := range 3 {
+		for col := range 3 {
+			identity := 0.0
+			if row == col {
+				identity = 1
+			}
+			m[row][col] = identity + (sepia[row][col]-identity)*amount
+		}
+	}
+	return m
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
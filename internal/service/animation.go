@@ -0,0 +1,150 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	res "github.com/nfnt/resize"
+)
+
+// picture is a decoded original. Frames of animated GIFs are composited onto
+// the full canvas so every operation sees the complete image, still images
+// have a single frame and no timing. Metadata holds the blocks the picture
+// is written with, profile the colour profile its pixels are in.
+type picture struct {
+	frames    []image.Image
+	delays    []int
+	disposals []byte
+	loopCount int
+	metadata  metadataBlocks
+	profile   *iccProfile
+}
+
+func still(img image.Image) *picture {
+	return &picture{frames: []image.Image{img}}
+}
+
+func (p *picture) animated() bool {
+	return len(p.frames) > 1
+}
+
+// first returns a still picture of the first frame.
+func (p *picture) first() *picture {
+	pic := still(p.frames[0])
+	pic.metadata = p.metadata
+	pic.profile = p.profile
+	return pic
+}
+
+// transform returns a copy of the picture with fn applied to every frame.
+// Delays, disposal methods, loop count, metadata and profile are kept.
+func (p *picture) transform(fn func(image.Image) (image.Image, error)) (*picture, error) {
+	result := &picture{
+		frames:    make([]image.Image, 0, len(p.frames)),
+		delays:    p.delays,
+		disposals: p.disposals,
+		loopCount: p.loopCount,
+		metadata:  p.metadata,
+		profile:   p.profile,
+	}
+
+	for _, frame := range p.frames {
+		transformed, err := fn(frame)
+		if err != nil {
+			return nil, err
+		}
+		result.frames = append(result.frames, transformed)
+	}
+
+	return result, nil
+}
+
+// isSmartCover reports whether the resize crops the covering image to the
+// most detailed region.
+func isSmartCover(opts dto.Resize) bool {
+	return opts.Width > 0 && opts.Height > 0 && opts.Gravity == GravitySmart &&
+		(opts.Mode == ResizeFill || opts.Mode == ResizeCover)
+}
+
+// smartResizeFrames is the smart cover resize of resizeWithMode for all
+// frames at once: every frame is scaled to cover the box and then cut at the
+// region picked from the edges of all of them.
+func smartResizeFrames(pic *picture, opts dto.Resize) (*picture, error) {
+	bounds := pic.frames[0].Bounds()
+	w, h := coverSize(bounds.Dx(), bounds.Dy(), opts.Width, opts.Height)
+	filter := resizeFilter(opts.Filter)
+
+	resized, err := pic.transform(func(img image.Image) (image.Image, error) {
+		return res.Resize(uint(w), uint(h), img, filter), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rect := framesSmartRect(resized.frames, opts.Width, opts.Height)
+	return resized.transform(func(img image.Image) (image.Image, error) {
+		return cropToRect(img, rect), nil
+	})
+}
+
+// smartCropFrames is the smart crop of cropImage for all frames at once.
+func smartCropFrames(pic *picture, opts dto.Crop) (*picture, error) {
+	rect, err := cropRect(pic.frames[0].Bounds(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rect = framesSmartRect(pic.frames, rect.Dx(), rect.Dy())
+	return pic.transform(func(img image.Image) (image.Image, error) {
+		return cropToRect(img, rect), nil
+	})
+}
+
+// decodeAnimation decodes every frame of a GIF and renders it the way a
+// viewer would show it, applying the disposal method of the previous frame.
+func decodeAnimation(r io.Reader) (*picture, error) {
+	decoded, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, decoded.Config.Width, decoded.Config.Height))
+	result := &picture{
+		delays:    decoded.Delay,
+		disposals: decoded.Disposal,
+		loopCount: decoded.LoopCount,
+	}
+
+	for i, frame := range decoded.Image {
+		var disposal byte
+		if i < len(decoded.Disposal) {
+			disposal = decoded.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		result.frames = append(result.frames, cloneRGBA(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return result, nil
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	return dst
+}
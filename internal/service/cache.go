@@ -0,0 +1,39 @@
+package service
+
+import "sync"
+
+// maxCacheEntries bounds the number of decoded resources kept in memory.
+const maxCacheEntries = 32
+
+// cache keeps decoded resources that never change once uploaded, such as
+// overlays and fonts. When it is full an arbitrary entry is dropped.
+type cache[K comparable, V any] struct {
+	mu    sync.Mutex
+	items map[K]V
+}
+
+func (c *cache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.items[key]
+	return value, ok
+}
+
+func (c *cache[K, V]) put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items == nil {
+		c.items = make(map[K]V)
+	}
+
+	if _, ok := c.items[key]; !ok && len(c.items) >= maxCacheEntries {
+		for k := range c.items {
+			delete(c.items, k)
+			break
+		}
+	}
+
+	c.items[key] = value
+}
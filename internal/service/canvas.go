@@ -0,0 +1,197 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	res "github.com/nfnt/resize"
+)
+
+const (
+	defaultBorderColor = "#000000"
+	maxBorderWidth     = 1000
+)
+
+func isCorrectBorder(opts dto.Border) bool {
+	if opts.Width <= 0 || opts.Width > maxBorderWidth {
+		return false
+	}
+	return opts.Color == "" || isCorrectColor(opts.Color)
+}
+
+func isCorrectPadding(opts dto.Padding) bool {
+	sides := []int{opts.Top, opts.Right, opts.Bottom, opts.Left}
+	total := 0
+	for _, side := range sides {
+		if side < 0 || side > maxBorderWidth {
+			return false
+		}
+		total += side
+	}
+
+	if total == 0 {
+		return false
+	}
+	return opts.Color == "" || isCorrectColor(opts.Color)
+}
+
+func isCorrectRoundCorners(opts dto.RoundCorners) bool {
+	return opts.Radius > 0 && opts.Radius <= maxDimension
+}
+
+func isCorrectCanvas(opts dto.Canvas) bool {
+	if opts.Width <= 0 || opts.Height <= 0 || opts.Width > maxDimension || opts.Height > maxDimension {
+		return false
+	}
+
+	if opts.Gravity != "" && !isCorrectGravity(opts.Gravity) {
+		return false
+	}
+	return opts.Color == "" || isCorrectColor(opts.Color)
+}
+
+func isCorrectColor(s string) bool {
+	_, err := parseColor(s)
+	return err == nil
+}
+
+func addBorder(src image.Image, opts dto.Border) (image.Image, error) {
+	col := opts.Color
+	if col == "" {
+		col = defaultBorderColor
+	}
+
+	return extendCanvas(src, borderInsets(opts), col)
+}
+
+func addPadding(src image.Image, opts dto.Padding) (image.Image, error) {
+	col := opts.Color
+	if col == "" {
+		col = "transparent"
+	}
+
+	return extendCanvas(src, paddingInsets(opts), col)
+}
+
+// The insets of the frame are packed into a rectangle: Min holds the left and
+// top widths, Max the right and bottom ones. It is built as a literal since
+// image.Rect would swap the sides to make the rectangle well-formed.
+func paddingInsets(opts dto.Padding) image.Rectangle {
+	return image.Rectangle{Min: image.Pt(opts.Left, opts.Top), Max: image.Pt(opts.Right, opts.Bottom)}
+}
+
+func borderInsets(opts dto.Border) image.Rectangle {
+	w := opts.Width
+	return image.Rectangle{Min: image.Pt(w, w), Max: image.Pt(w, w)}
+}
+
+// extendCanvas surrounds the image with a frame of the given colour and
+// widths.
+func extendCanvas(src image.Image, insets image.Rectangle, background string) (image.Image, error) {
+	bg, err := parseColor(background)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	size := paddedSize(bounds.Size(), insets)
+
+	canvas := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(canvas, bounds.Sub(bounds.Min).Add(insets.Min), src, bounds.Min, draw.Src)
+
+	return canvas, nil
+}
+
+func paddedSize(size image.Point, insets image.Rectangle) image.Point {
+	return size.Add(insets.Min).Add(insets.Max)
+}
+
+// roundCorners returns a copy of the image with the corners outside of the
+// circles of the given radius made transparent. The edge of the circles is
+// antialiased by the share of each pixel covered by the circle.
+func roundCorners(src image.Image, opts dto.RoundCorners) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	radius := min(opts.Radius, w/2, h/2)
+	if radius == 0 {
+		return dst
+	}
+	r := float64(radius)
+
+	for y := range h {
+		// Distance to the circle centres is measured from the pixel centres.
+		dy := 0.0
+		if y < radius {
+			dy = r - (float64(y) + 0.5)
+		} else if y >= h-radius {
+			dy = float64(y) + 0.5 - float64(h-radius)
+		}
+		if dy == 0 {
+			continue
+		}
+
+		for x := range w {
+			dx := 0.0
+			if x < radius {
+				dx = r - (float64(x) + 0.5)
+			} else if x >= w-radius {
+				dx = float64(x) + 0.5 - float64(w-radius)
+			}
+			if dx == 0 {
+				continue
+			}
+
+			coverage := clamp01(r - math.Hypot(dx, dy) + 0.5)
+			if coverage == 1 {
+				continue
+			}
+
+			i := dst.PixOffset(x, y)
+			for c := range 4 {
+				dst.Pix[i+c] = uint8(math.Round(float64(dst.Pix[i+c]) * coverage))
+			}
+		}
+	}
+
+	return dst
+}
+
+// placeOnCanvas draws the image on a background of a fixed size. Images that
+// do not fit are scaled down keeping their aspect ratio.
+func placeOnCanvas(src image.Image, opts dto.Canvas) (image.Image, error) {
+	background := opts.Color
+	if background == "" {
+		background = defaultBackground
+	}
+
+	bg, err := parseColor(background)
+	if err != nil {
+		return nil, err
+	}
+
+	gravity := opts.Gravity
+	if gravity == "" {
+		gravity = GravityCenter
+	}
+
+	bounds := src.Bounds()
+	if bounds.Dx() > opts.Width || bounds.Dy() > opts.Height {
+		w, h := fitSize(bounds.Dx(), bounds.Dy(), opts.Width, opts.Height)
+		src = res.Resize(uint(w), uint(h), src, res.Lanczos3)
+		bounds = src.Bounds()
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	rect := gravityRect(canvas.Bounds(), bounds.Dx(), bounds.Dy(), gravity)
+	draw.Draw(canvas, rect, src, bounds.Min, draw.Over)
+
+	return canvas, nil
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	AlignLeft   = "left"
+	AlignCenter = "center"
+	AlignRight  = "right"
+)
+
+const (
+	defaultCaptionColor       = "#ffffff"
+	defaultCaptionFontSize    = 0.05
+	defaultCaptionPosition    = GravitySouth
+	defaultCaptionAlign       = AlignCenter
+	defaultCaptionWidth       = 1
+	defaultCaptionLineSpacing = 1
+	maxCaptionLength          = 1000
+	minLineSpacing            = 0.5
+	maxLineSpacing            = 5
+	maxCaptionPadding         = 1000
+)
+
+func isCorrectCaption(opts dto.Caption) bool {
+	if strings.TrimSpace(opts.Text) == "" || utf8.RuneCountInString(opts.Text) > maxCaptionLength {
+		return false
+	}
+
+	if opts.Font != "" && !isCorrectFont(opts.Font) {
+		return false
+	}
+
+	if math.IsNaN(opts.FontSize) || opts.FontSize < 0 || opts.FontSize > 1 {
+		return false
+	}
+
+	if opts.Color != "" && !isCorrectColor(opts.Color) {
+		return false
+	}
+
+	switch opts.Align {
+	case "", AlignLeft, AlignCenter, AlignRight:
+	default:
+		return false
+	}
+
+	if opts.LineSpacing != 0 && !(opts.LineSpacing >= minLineSpacing && opts.LineSpacing <= maxLineSpacing) {
+		return false
+	}
+
+	if opts.Position != "" && !isCorrectGravity(opts.Position) {
+		return false
+	}
+
+	if opts.Margin < 0 || opts.Margin > maxWatermarkMargin {
+		return false
+	}
+
+	if math.IsNaN(opts.Width) || opts.Width < 0 || opts.Width > 1 {
+		return false
+	}
+
+	if opts.Background != "" && !isCorrectColor(opts.Background) {
+		return false
+	}
+
+	return opts.Padding >= 0 && opts.Padding <= maxCaptionPadding
+}
+
+// captionDefaults fills in every option that is not set.
+func captionDefaults(opts dto.Caption) dto.Caption {
+	if opts.FontSize == 0 {
+		opts.FontSize = defaultCaptionFontSize
+	}
+	if opts.Color == "" {
+		opts.Color = defaultCaptionColor
+	}
+	if opts.Align == "" {
+		opts.Align = defaultCaptionAlign
+	}
+	if opts.LineSpacing == 0 {
+		opts.LineSpacing = defaultCaptionLineSpacing
+	}
+	if opts.Position == "" {
+		opts.Position = defaultCaptionPosition
+	}
+	if opts.Width == 0 {
+		opts.Width = defaultCaptionWidth
+	}
+	return opts
+}
+
+// addCaption draws the caption box onto a copy of the image. Captions that
+// cannot be made small enough to fit are skipped, like watermarks.
+func (s *Service) addCaption(img image.Image, opts dto.Caption) (image.Image, error) {
+	opts = captionDefaults(opts)
+
+	ft, err := s.loadFont(opts.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	box, err := captionBox(dst.Bounds(), ft, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if box == nil {
+		return dst, nil
+	}
+
+	area := watermarkArea(dst.Bounds(), opts.Margin)
+	boxBounds := box.Bounds()
+	rect := gravityRect(area, boxBounds.Dx(), boxBounds.Dy(), opts.Position)
+	draw.Draw(dst, rect, box, boxBounds.Min, draw.Over)
+
+	return dst, nil
+}
+
+// captionBox renders the caption for an image with the given bounds. The font
+// size is relative to the image width and is reduced until the wrapped text
+// fits the height between the margins. It returns nil when the text cannot be
+// made small enough.
+func captionBox(bounds image.Rectangle, ft *opentype.Font, opts dto.Caption) (image.Image, error) {
+	area := watermarkArea(bounds, opts.Margin)
+	boxWidth := int(math.Round(opts.Width * float64(area.Dx())))
+	if boxWidth-2*opts.Padding < 1 || area.Dy()-2*opts.Padding < 1 {
+		return nil, nil
+	}
+
+	size := opts.FontSize * float64(bounds.Dx())
+	for range maxLabelAttempts {
+		if size < minLabelFontSize {
+			break
+		}
+
+		box, err := renderCaption(ft, size, boxWidth, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		height := box.Bounds().Dy()
+		if height <= area.Dy() {
+			return box, nil
+		}
+
+		// Wrapped text takes an area growing with the square of the font
+		// size, so the height follows it roughly quadratically.
+		textHeight := float64(height - 2*opts.Padding)
+		size *= math.Sqrt(float64(area.Dy()-2*opts.Padding)/textHeight) * 0.95
+	}
+
+	return nil, nil
+}
+
+// renderCaption lays the text out in a box of the given width. The height of
+// the box covers every line and any glyph parts reaching beyond the line
+// height, so the text is never clipped vertically.
+func renderCaption(ft *opentype.Font, size float64, width int, opts dto.Caption) (*image.RGBA, error) {
+	col, err := parseColor(opts.Color)
+	if err != nil {
+		return nil, err
+	}
+
+	face, err := opentype.NewFace(ft, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	textWidth := width - 2*opts.Padding
+	lines := wrapText(face, opts.Text, fixed.I(textWidth))
+
+	// Whole pixel line heights keep every line rendered the same way.
+	metrics := face.Metrics()
+	lineHeight := fixed.I(int(math.Round(float64(metrics.Height.Round()) * opts.LineSpacing)))
+
+	top := -metrics.Ascent
+	bottom := lineHeight*fixed.Int26_6(len(lines)-1) + metrics.Descent
+	for i, line := range lines {
+		ink, _ := font.BoundString(face, line)
+		if ink.Empty() {
+			continue
+		}
+		offset := lineHeight * fixed.Int26_6(i)
+		top = min(top, ink.Min.Y+offset)
+		bottom = max(bottom, ink.Max.Y+offset)
+	}
+
+	originY := top.Floor()
+	height := bottom.Ceil() - originY + 2*opts.Padding
+	box := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	if opts.Background != "" {
+		bg, err := parseColor(opts.Background)
+		if err != nil {
+			return nil, err
+		}
+		draw.Draw(box, box.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+
+	d := &font.Drawer{
+		Dst:  box,
+		Src:  image.NewUniform(col),
+		Face: face,
+	}
+
+	for i, line := range lines {
+		x := fixed.I(opts.Padding)
+		switch opts.Align {
+		case AlignCenter:
+			x += (fixed.I(textWidth) - font.MeasureString(face, line)) / 2
+		case AlignRight:
+			x += fixed.I(textWidth) - font.MeasureString(face, line)
+		}
+
+		d.Dot = fixed.Point26_6{
+			X: fixed.I(x.Round()),
+			Y: fixed.I(opts.Padding-originY) + lineHeight*fixed.Int26_6(i),
+		}
+		d.DrawString(line)
+	}
+
+	return box, nil
+}
+
+// wrapText splits the text into lines no wider than the width. Explicit line
+// breaks are kept, words wider than the width are broken between characters.
+func wrapText(face font.Face, text string, width fixed.Int26_6) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+
+			if font.MeasureString(face, candidate) <= width {
+				line = candidate
+				continue
+			}
+
+			if line != "" {
+				lines = append(lines, line)
+			}
+
+			for font.MeasureString(face, word) > width {
+				n := fittingPrefix(face, word, width)
+				lines = append(lines, word[:n])
+				word = word[n:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fittingPrefix returns the length in bytes of the longest prefix of s that
+// is not wider than the width, but at least one character.
+func fittingPrefix(face font.Face, s string, width fixed.Int26_6) int {
+	end := 0
+	for i, r := range s {
+		next := i + utf8.RuneLen(r)
+		if end > 0 && font.MeasureString(face, s[:next]) > width {
+			break
+		}
+		end = next
+	}
+	return end
+}
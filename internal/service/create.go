@@ -7,28 +7,50 @@ import (
 	"github.com/Komilov31/image-processor/internal/dto"
 	"github.com/Komilov31/image-processor/internal/model"
 	"github.com/google/uuid"
+	"github.com/wb-go/wbf/zlog"
 )
 
-func (s *Service) CreateImage(data []byte, imageData dto.Message) (*uuid.UUID, error) {
+// CreateImage saves the original under a new id and queues its processing.
+// The original name is the file name of the upload, it is only recorded.
+func (s *Service) CreateImage(data []byte, originalName string, imageData dto.Message) (*uuid.UUID, error) {
 	format, err := parseFormat(imageData.ContentType)
 	if err != nil {
 		return nil, err
 	}
 
-	if !isCorrectTask(imageData.Task) {
-		return nil, ErrInvalidTask
+	if err := validateMessage(data, format, imageData); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkReferences(imageData); err != nil {
+		return nil, err
 	}
 
+	original := describeFile(data, format)
+	original.Name = originalName
+
 	id := uuid.New()
 	image := model.Image{
-		ID:     id,
-		Format: format,
-		Status: "in progress",
+		ID:           id,
+		Format:       format,
+		OutputFormat: resolveOutput(imageData.Output, dto.Output{}, format).Format,
+		Status:       statusInProgress,
+		ColorSpace:   imageColorSpace(data, format),
+		Original:     &original,
+	}
+
+	for _, variant := range imageData.Variants {
+		image.Variants = append(image.Variants, model.Variant{
+			Name:   variant.Name,
+			Format: resolveOutput(imageData.Output, variant.Output, format).Format,
+			Status: statusInProgress,
+		})
 	}
 
 	fileName := id.String() + "." + format
 	imageData.ID = id
 	imageData.FileName = fileName
+	imageData.Output = mergeOutput(s.defaults, imageData.Output)
 
 	if err := os.WriteFile(originDirName+"/"+fileName, data, 0666); err != nil {
 		return nil, fmt.Errorf("could not save image: %w", err)
@@ -39,11 +61,16 @@ func (s *Service) CreateImage(data []byte, imageData dto.Message) (*uuid.UUID, e
 		return nil, err
 	}
 
-	if err := s.queue.ProduceMessage(imageData); err != nil {
+	// The row is inserted first, the worker updates it as soon as it picks the
+	// message up.
+	if err := s.storage.CreateImage(image); err != nil {
 		return nil, err
 	}
 
-	if err := s.storage.CreateImage(image); err != nil {
+	if err := s.queue.ProduceMessage(imageData); err != nil {
+		if err := s.storage.UpdateImageStatus(id, statusFailed); err != nil {
+			zlog.Logger.Error().Msgf("could not mark unqueued image %s as failed: %s", id, err.Error())
+		}
 		return nil, err
 	}
 
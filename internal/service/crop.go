@@ -0,0 +1,109 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+)
+
+const (
+	GravityCenter    = "center"
+	GravityNorth     = "north"
+	GravitySouth     = "south"
+	GravityEast      = "east"
+	GravityWest      = "west"
+	GravityNorthEast = "north-east"
+	GravityNorthWest = "north-west"
+	GravitySouthEast = "south-east"
+	GravitySouthWest = "south-west"
+
+	// GravitySmart picks the most detailed region of the image, it is only
+	// accepted where the image content is known, for crops and cover resizes.
+	GravitySmart = "smart"
+)
+
+func isCorrectGravity(gravity string) bool {
+	gravities := map[string]struct{}{
+		GravityCenter:    struct{}{},
+		GravityNorth:     struct{}{},
+		GravitySouth:     struct{}{},
+		GravityEast:      struct{}{},
+		GravityWest:      struct{}{},
+		GravityNorthEast: struct{}{},
+		GravityNorthWest: struct{}{},
+		GravitySouthEast: struct{}{},
+		GravitySouthWest: struct{}{},
+	}
+
+	_, ok := gravities[gravity]
+	return ok
+}
+
+func isCorrectCropGravity(gravity string) bool {
+	return gravity == GravitySmart || isCorrectGravity(gravity)
+}
+
+// cropRect resolves the crop options to a rectangle inside bounds. An explicit
+// x/y rectangle is used unless a gravity is given, in which case the
+// width x height region is anchored to that side of the image. The smart
+// gravity resolves to the centre here, as the image content is not known.
+func cropRect(bounds image.Rectangle, opts dto.Crop) (image.Rectangle, error) {
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return image.Rectangle{}, ErrInvalidCrop
+	}
+
+	if opts.Gravity != "" {
+		if !isCorrectCropGravity(opts.Gravity) || opts.Width > bounds.Dx() || opts.Height > bounds.Dy() {
+			return image.Rectangle{}, ErrInvalidCrop
+		}
+		return gravityRect(bounds, opts.Width, opts.Height, opts.Gravity), nil
+	}
+
+	if opts.X < 0 || opts.Y < 0 {
+		return image.Rectangle{}, ErrInvalidCrop
+	}
+
+	rect := image.Rect(opts.X, opts.Y, opts.X+opts.Width, opts.Y+opts.Height).Add(bounds.Min)
+	if !rect.In(bounds) {
+		return image.Rectangle{}, ErrInvalidCrop
+	}
+
+	return rect, nil
+}
+
+// anchorRect returns the width x height region of the image at the gravity,
+// the smart gravity looks at the image itself to place it.
+func anchorRect(img image.Image, width, height int, gravity string) image.Rectangle {
+	if gravity == GravitySmart {
+		return smartRect(img, width, height)
+	}
+	return gravityRect(img.Bounds(), width, height, gravity)
+}
+
+func gravityRect(bounds image.Rectangle, width, height int, gravity string) image.Rectangle {
+	x := bounds.Min.X + (bounds.Dx()-width)/2
+	y := bounds.Min.Y + (bounds.Dy()-height)/2
+
+	switch gravity {
+	case GravityNorth, GravityNorthEast, GravityNorthWest:
+		y = bounds.Min.Y
+	case GravitySouth, GravitySouthEast, GravitySouthWest:
+		y = bounds.Max.Y - height
+	}
+
+	switch gravity {
+	case GravityWest, GravityNorthWest, GravitySouthWest:
+		x = bounds.Min.X
+	case GravityEast, GravityNorthEast, GravitySouthEast:
+		x = bounds.Max.X - width
+	}
+
+	return image.Rect(x, y, x+width, y+height)
+}
+
+func cropToRect(src image.Image, rect image.Rectangle) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, rect.Min, draw.Src)
+	return dst
+}
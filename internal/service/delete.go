@@ -1,13 +1,32 @@
 package service
 
-import "github.com/google/uuid"
+import (
+	"errors"
+
+	repository "github.com/Komilov31/image-processor/internal/repository/db"
+	"github.com/google/uuid"
+)
+
+var extensions = []string{"jpg", "jpeg", "png", "gif", "webp", "bmp", "tiff"}
 
 func (s *Service) DeleteImage(id uuid.UUID) error {
-	images := []string{
-		id.String() + ".jpg",
-		id.String() + ".jpeg",
-		id.String() + ".png",
-		id.String() + ".gif",
+	imageInfo, err := s.storage.GetImageInfo(id)
+	if err != nil && !errors.Is(err, repository.ErrNoSuchImage) {
+		return err
+	}
+
+	var images, processed []string
+	for _, extension := range extensions {
+		images = append(images, id.String()+"."+extension)
+	}
+	processed = append(processed, images...)
+
+	if imageInfo != nil {
+		for _, variant := range imageInfo.Variants {
+			for _, extension := range extensions {
+				processed = append(processed, variantFileName(id, variant.Name, extension))
+			}
+		}
 	}
 
 	if err := s.storage.DeleteImage(id); err != nil {
@@ -18,7 +37,7 @@ func (s *Service) DeleteImage(id uuid.UUID) error {
 		return err
 	}
 
-	if err := s.fileStorage.DeleteImages("processed", images...); err != nil {
+	if err := s.fileStorage.DeleteImages("processed", processed...); err != nil {
 		return err
 	}
 
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const (
+	// maxSegmentLength is the largest payload of a JPEG marker segment.
+	maxSegmentLength = 0xffff - 2
+	maxICCChunks     = 255
+	pngICCName       = "ICC Profile"
+)
+
+// WebP extended format flags.
+const (
+	vp8xICC  = 0x20
+	vp8xEXIF = 0x08
+	vp8xXMP  = 0x04
+)
+
+// embedMetadata writes the metadata blocks into an encoded image. JPEG, PNG
+// and WebP can carry them, other formats are returned as they are. Blocks
+// that do not fit the container of the format are left out.
+func embedMetadata(format string, data []byte, blocks metadataBlocks) []byte {
+	if blocks.empty() {
+		return data
+	}
+
+	switch format {
+	case "jpeg":
+		return embedJPEG(data, blocks)
+	case "png":
+		return embedPNG(data, blocks)
+	case "webp":
+		return embedWebP(data, blocks)
+	}
+	return data
+}
+
+// embedJPEG inserts the metadata segments right after the SOI marker. The
+// colour profile is split over as many APP2 segments as it needs.
+func embedJPEG(data []byte, blocks metadataBlocks) []byte {
+	if len(data) < 2 {
+		return data
+	}
+
+	var segments []byte
+	if blocks.exif != nil {
+		segments = appendJPEGSegment(segments, markerAPP1, exifHeader, blocks.exif)
+	}
+
+	if blocks.icc != nil {
+		chunkSize := maxSegmentLength - len(iccHeader) - 2
+		count := (len(blocks.icc) + chunkSize - 1) / chunkSize
+		if count <= maxICCChunks {
+			for i := range count {
+				chunk := blocks.icc[i*chunkSize : min((i+1)*chunkSize, len(blocks.icc))]
+				segments = appendJPEGSegment(segments, markerAPP2, iccHeader, []byte{byte(i + 1), byte(count)}, chunk)
+			}
+		}
+	}
+
+	if blocks.xmp != nil {
+		segments = appendJPEGSegment(segments, markerAPP1, xmpHeader, blocks.xmp)
+	}
+
+	if blocks.iptc != nil {
+		resource := append([]byte("8BIM"), 0x04, 0x04, 0, 0)
+		resource = binary.BigEndian.AppendUint32(resource, uint32(len(blocks.iptc)))
+		resource = append(resource, blocks.iptc...)
+		if len(blocks.iptc)%2 == 1 {
+			resource = append(resource, 0)
+		}
+		segments = appendJPEGSegment(segments, markerAPP13, photoshopHeader, resource)
+	}
+
+	result := make([]byte, 0, len(data)+len(segments))
+	result = append(result, data[:2]...)
+	result = append(result, segments...)
+	return append(result, data[2:]...)
+}
+
+// appendJPEGSegment appends a marker segment with the concatenated parts as
+// its payload, segments that would be too long are skipped.
+func appendJPEGSegment(dst []byte, marker byte, parts ...[]byte) []byte {
+	length := 0
+	for _, part := range parts {
+		length += len(part)
+	}
+	if length > maxSegmentLength {
+		return dst
+	}
+
+	dst = append(dst, 0xff, marker)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(length+2))
+	for _, part := range parts {
+		dst = append(dst, part...)
+	}
+	return dst
+}
+
+// embedPNG inserts the metadata chunks after the IHDR chunk, the colour
+// profile has to precede the image data. PNG has no chunk for IPTC.
+func embedPNG(data []byte, blocks metadataBlocks) []byte {
+	chunks := pngChunks(data)
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+		return data
+	}
+	headerEnd := len(pngSignature) + 12 + len(chunks[0].data)
+
+	var inserted []byte
+	if blocks.icc != nil {
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		_, _ = w.Write(blocks.icc)
+		_ = w.Close()
+
+		profile := append([]byte(pngICCName), 0, 0)
+		inserted = appendPNGChunk(inserted, "iCCP", append(profile, compressed.Bytes()...))
+	}
+
+	if blocks.exif != nil {
+		inserted = appendPNGChunk(inserted, "eXIf", blocks.exif)
+	}
+
+	if blocks.xmp != nil {
+		text := append([]byte(xmpKeyword), 0, 0, 0, 0, 0)
+		inserted = appendPNGChunk(inserted, "iTXt", append(text, blocks.xmp...))
+	}
+
+	result := make([]byte, 0, len(data)+len(inserted))
+	result = append(result, data[:headerEnd]...)
+	result = append(result, inserted...)
+	return append(result, data[headerEnd:]...)
+}
+
+func appendPNGChunk(dst []byte, typ string, data []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(data)))
+	start := len(dst)
+	dst = append(dst, typ...)
+	dst = append(dst, data...)
+	return binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst[start:]))
+}
+
+// embedWebP converts a simple lossless WebP into the extended format, which
+// announces the metadata chunks in the VP8X header. The canvas size is taken
+// from the VP8L header. The alpha flag stays unset: VP8L carries its own
+// alpha channel and golang.org/x/image rejects the flag in front of it.
+// WebP has no chunk for IPTC.
+func embedWebP(data []byte, blocks metadataBlocks) []byte {
+	chunks := webpChunks(data)
+	if len(chunks) != 1 || chunks[0].fourCC != "VP8L" || len(chunks[0].data) < 5 {
+		return data
+	}
+
+	bitstream := chunks[0].data
+	header := binary.LittleEndian.Uint32(bitstream[1:])
+	width, height := header&0x3fff, (header>>14)&0x3fff
+
+	var flags byte
+	if blocks.icc != nil {
+		flags |= vp8xICC
+	}
+	if blocks.exif != nil {
+		flags |= vp8xEXIF
+	}
+	if blocks.xmp != nil {
+		flags |= vp8xXMP
+	}
+
+	vp8x := []byte{flags, 0, 0, 0}
+	vp8x = append(vp8x, byte(width), byte(width>>8), byte(width>>16))
+	vp8x = append(vp8x, byte(height), byte(height>>8), byte(height>>16))
+
+	body := []byte("WEBP")
+	body = appendRIFFChunk(body, "VP8X", vp8x)
+	if blocks.icc != nil {
+		body = appendRIFFChunk(body, "ICCP", blocks.icc)
+	}
+	body = appendRIFFChunk(body, "VP8L", bitstream)
+	if blocks.exif != nil {
+		body = appendRIFFChunk(body, "EXIF", blocks.exif)
+	}
+	if blocks.xmp != nil {
+		body = appendRIFFChunk(body, "XMP ", blocks.xmp)
+	}
+
+	result := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(result, body...)
+}
+
+func appendRIFFChunk(dst []byte, fourCC string, data []byte) []byte {
+	dst = append(dst, fourCC...)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(data)))
+	dst = append(dst, data...)
+	if len(data)%2 == 1 {
+		dst = append(dst, 0)
+	}
+	return dst
+}
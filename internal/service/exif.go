@@ -0,0 +1,256 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+)
+
+const (
+	tagOrientation = 0x0112
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+
+	errInvalidTIFF = errors.New("invalid tiff structure")
+)
+
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegSegments returns the marker segments of a JPEG stream up to the start of scan.
+// The returned data does not include the marker and the length field.
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil
+	}
+
+	var segments []jpegSegment
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			break
+		}
+
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+
+		if marker == markerSOS || marker == markerEOI {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+
+		segments = append(segments, jpegSegment{marker: marker, data: data[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+
+	return segments
+}
+
+// exifPayload returns the TIFF structure stored in the Exif APP1 segment of a JPEG.
+func exifPayload(data []byte) []byte {
+	for _, segment := range jpegSegments(data) {
+		if segment.marker == markerAPP1 && bytes.HasPrefix(segment.data, exifHeader) {
+			return segment.data[len(exifHeader):]
+		}
+	}
+	return nil
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTIFFReader(data []byte) (*tiffReader, error) {
+	if len(data) < 8 {
+		return nil, errInvalidTIFF
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errInvalidTIFF
+	}
+
+	if order.Uint16(data[2:]) != 42 {
+		return nil, errInvalidTIFF
+	}
+
+	return &tiffReader{data: data, order: order}, nil
+}
+
+func (t *tiffReader) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:])
+}
+
+// typeSize returns the size in bytes of a single value of the given TIFF type.
+func typeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 0
+}
+
+// ifd reads the directory at offset and returns its entries and the offset of the next one.
+func (t *tiffReader) ifd(offset uint32) ([]tiffEntry, uint32, error) {
+	if int(offset)+2 > len(t.data) {
+		return nil, 0, errInvalidTIFF
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12+4 > len(t.data) {
+		return nil, 0, errInvalidTIFF
+	}
+
+	entries := make([]tiffEntry, 0, count)
+	for i := range count {
+		raw := t.data[start+i*12 : start+(i+1)*12]
+		entry := tiffEntry{
+			tag:   t.order.Uint16(raw),
+			typ:   t.order.Uint16(raw[2:]),
+			count: t.order.Uint32(raw[4:]),
+		}
+
+		size := typeSize(entry.typ) * int(entry.count)
+		if size == 0 {
+			continue
+		}
+
+		if size <= 4 {
+			entry.value = raw[8 : 8+size]
+		} else {
+			valueOffset := int(t.order.Uint32(raw[8:]))
+			if valueOffset < 0 || valueOffset+size > len(t.data) {
+				continue
+			}
+			entry.value = t.data[valueOffset : valueOffset+size]
+		}
+
+		entries = append(entries, entry)
+	}
+
+	next := t.order.Uint32(t.data[start+count*12:])
+	return entries, next, nil
+}
+
+// uint returns the i-th value of an integer entry.
+func (t *tiffReader) uint(entry tiffEntry, i int) (uint32, bool) {
+	size := typeSize(entry.typ)
+	if (i+1)*size > len(entry.value) {
+		return 0, false
+	}
+
+	switch entry.typ {
+	case 1, 7:
+		return uint32(entry.value[i]), true
+	case 3:
+		return uint32(t.order.Uint16(entry.value[i*2:])), true
+	case 4:
+		return t.order.Uint32(entry.value[i*4:]), true
+	}
+	return 0, false
+}
+
+// ascii returns the value of an ASCII entry without the terminating NULs.
+func (t *tiffReader) ascii(entry tiffEntry) string {
+	if entry.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+// rational returns the i-th value of a rational entry.
+func (t *tiffReader) rational(entry tiffEntry, i int) (float64, bool) {
+	num, den, ok := t.fraction(entry, i)
+	if !ok || den == 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// fraction returns the numerator and denominator of the i-th value of a
+// rational entry.
+func (t *tiffReader) fraction(entry tiffEntry, i int) (int64, int64, bool) {
+	if (i+1)*8 > len(entry.value) {
+		return 0, 0, false
+	}
+
+	num, den := t.order.Uint32(entry.value[i*8:]), t.order.Uint32(entry.value[i*8+4:])
+	switch entry.typ {
+	case 5:
+		return int64(num), int64(den), true
+	case 10:
+		return int64(int32(num)), int64(int32(den)), true
+	}
+	return 0, 0, false
+}
+
+// exifOrientation returns the value of the EXIF Orientation tag, or 1 if the image has none.
+func exifOrientation(data []byte) int {
+	payload := exifPayload(data)
+	if payload == nil {
+		return 1
+	}
+
+	reader, err := newTIFFReader(payload)
+	if err != nil {
+		return 1
+	}
+
+	entries, _, err := reader.ifd(reader.firstIFD())
+	if err != nil {
+		return 1
+	}
+
+	for _, entry := range entries {
+		if entry.tag != tagOrientation {
+			continue
+		}
+
+		value, ok := reader.uint(entry, 0)
+		if !ok || value < 1 || value > 8 {
+			return 1
+		}
+		return int(value)
+	}
+
+	return 1
+}
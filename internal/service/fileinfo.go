@@ -0,0 +1,29 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+
+	"github.com/Komilov31/image-processor/internal/model"
+)
+
+// describeFile returns the size, digest, MIME type and dimensions of an
+// encoded image. Dimensions of data that cannot be decoded are left zero, the
+// worker reports such images when it processes them.
+func describeFile(data []byte, format string) model.File {
+	digest := sha256.Sum256(data)
+	file := model.File{
+		MIMEType: "image/" + format,
+		Size:     int64(len(data)),
+		SHA256:   hex.EncodeToString(digest[:]),
+	}
+
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		file.Width = config.Width
+		file.Height = config.Height
+	}
+
+	return file
+}
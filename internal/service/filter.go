@@ -0,0 +1,245 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+)
+
+const (
+	BlurGaussian = "gaussian"
+	BlurBox      = "box"
+)
+
+const (
+	maxBlurSigma        = 50
+	maxBlurRadius       = 100
+	maxSharpenAmount    = 5
+	maxKernelValue      = 1000
+	maxConvolveBias     = 255
+	defaultSharpenSigma = 1.0
+
+	// Gaussian kernels are cut off at three standard deviations, where the
+	// weights are negligible.
+	gaussianExtent = 3
+)
+
+func isCorrectBlur(opts dto.Blur) bool {
+	switch opts.Type {
+	case "", BlurGaussian:
+		return !math.IsNaN(opts.Sigma) && opts.Sigma > 0 && opts.Sigma <= maxBlurSigma
+	case BlurBox:
+		return opts.Radius >= 1 && opts.Radius <= maxBlurRadius
+	}
+	return false
+}
+
+func isCorrectSharpen(opts dto.Sharpen) bool {
+	if math.IsNaN(opts.Sigma) || opts.Sigma < 0 || opts.Sigma > maxBlurSigma {
+		return false
+	}
+
+	if math.IsNaN(opts.Amount) || opts.Amount < 0 || opts.Amount > maxSharpenAmount {
+		return false
+	}
+
+	return opts.Threshold >= 0 && opts.Threshold <= 0xff
+}
+
+func isCorrectConvolve(opts dto.Convolve) bool {
+	if len(opts.Kernel) != 9 && len(opts.Kernel) != 25 {
+		return false
+	}
+
+	for _, value := range opts.Kernel {
+		if math.IsNaN(value) || math.Abs(value) > maxKernelValue {
+			return false
+		}
+	}
+
+	if math.IsNaN(opts.Divisor) || math.IsInf(opts.Divisor, 0) {
+		return false
+	}
+
+	return !math.IsNaN(opts.Bias) && math.Abs(opts.Bias) <= maxConvolveBias
+}
+
+func blurImage(src image.Image, opts dto.Blur) image.Image {
+	if opts.Type == BlurBox {
+		return boxBlur(src, opts.Radius)
+	}
+	return gaussianBlur(src, opts.Sigma)
+}
+
+func gaussianBlur(src image.Image, sigma float64) *image.RGBA {
+	return separableFilter(toRGBA(src), gaussianKernel(sigma))
+}
+
+func boxBlur(src image.Image, radius int) *image.RGBA {
+	kernel := make([]float64, 2*radius+1)
+	for i := range kernel {
+		kernel[i] = 1 / float64(len(kernel))
+	}
+	return separableFilter(toRGBA(src), kernel)
+}
+
+// gaussianKernel returns normalized weights of a one-dimensional Gaussian.
+func gaussianKernel(sigma float64) []float64 {
+	radius := max(int(math.Ceil(sigma*gaussianExtent)), 1)
+	kernel := make([]float64, 2*radius+1)
+
+	sum := 0.0
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	return kernel
+}
+
+// separableFilter convolves every channel of the premultiplied image with the
+// kernel horizontally and then vertically. Pixels beyond the edges repeat the
+// edge pixels.
+func separableFilter(src *image.RGBA, kernel []float64) *image.RGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	radius := len(kernel) / 2
+
+	rows := make([]float64, w*h*4)
+	for y := range h {
+		for x := range w {
+			var sum [4]float64
+			for k, weight := range kernel {
+				sx := min(max(x+k-radius, 0), w-1)
+				i := src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+y)
+				for c := range 4 {
+					sum[c] += float64(src.Pix[i+c]) * weight
+				}
+			}
+			copy(rows[(y*w+x)*4:], sum[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			var sum [4]float64
+			for k, weight := range kernel {
+				sy := min(max(y+k-radius, 0), h-1)
+				i := (sy*w + x) * 4
+				for c := range 4 {
+					sum[c] += rows[i+c] * weight
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			alpha := clampByte(sum[3])
+			for c := range 3 {
+				dst.Pix[i+c] = min(clampByte(sum[c]), alpha)
+			}
+			dst.Pix[i+3] = alpha
+		}
+	}
+
+	return dst
+}
+
+// sharpenImage applies an unsharp mask: the difference between the image and
+// its Gaussian blur is scaled by the amount and added back wherever it is
+// larger than the threshold.
+func sharpenImage(src image.Image, opts dto.Sharpen) image.Image {
+	sigma, amount := opts.Sigma, opts.Amount
+	if sigma == 0 {
+		sigma = defaultSharpenSigma
+	}
+	if amount == 0 {
+		amount = 1
+	}
+
+	original := toRGBA(src)
+	blurred := gaussianBlur(original, sigma)
+
+	w, h := original.Rect.Dx(), original.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			i := original.PixOffset(original.Rect.Min.X+x, original.Rect.Min.Y+y)
+			j := dst.PixOffset(x, y)
+
+			alpha := original.Pix[i+3]
+			for c := range 3 {
+				value := float64(original.Pix[i+c])
+				diff := value - float64(blurred.Pix[j+c])
+				if math.Abs(diff) > float64(opts.Threshold) {
+					value += diff * amount
+				}
+				dst.Pix[j+c] = min(clampByte(value), alpha)
+			}
+			dst.Pix[j+3] = alpha
+		}
+	}
+
+	return dst
+}
+
+// convolveImage applies a 3x3 or 5x5 kernel to the colour channels, alpha is
+// kept. The weighted sum is divided by the divisor, the sum of the kernel when
+// the divisor is not set, and the bias is added.
+func convolveImage(src image.Image, opts dto.Convolve) image.Image {
+	size := 3
+	if len(opts.Kernel) == 25 {
+		size = 5
+	}
+	radius := size / 2
+
+	divisor := opts.Divisor
+	if divisor == 0 {
+		for _, value := range opts.Kernel {
+			divisor += value
+		}
+	}
+	if divisor == 0 {
+		divisor = 1
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	in := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(in, in.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewNRGBA(in.Rect)
+	for y := range h {
+		for x := range w {
+			var sum [3]float64
+			for ky := range size {
+				sy := min(max(y+ky-radius, 0), h-1)
+				for kx := range size {
+					sx := min(max(x+kx-radius, 0), w-1)
+					weight := opts.Kernel[ky*size+kx]
+					i := in.PixOffset(sx, sy)
+					for c := range 3 {
+						sum[c] += float64(in.Pix[i+c]) * weight
+					}
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			for c := range 3 {
+				dst.Pix[i+c] = clampByte(sum[c]/divisor + opts.Bias)
+			}
+			dst.Pix[i+3] = in.Pix[i+3]
+		}
+	}
+
+	return dst
+}
+
+func clampByte(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(0xff, math.Round(v))))
+}
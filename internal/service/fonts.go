@@ -0,0 +1,156 @@
+package service
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	fontsBucket = "fonts"
+	defaultFont = "roboto-black"
+)
+
+//go:embed fonts/*.ttf
+var embeddedFonts embed.FS
+
+// fontExtensions are the extensions uploaded fonts are stored with, the
+// extension follows the outline format of the font.
+var fontExtensions = []string{"ttf", "otf"}
+
+// builtinFonts are the fonts shipped with the service, keyed by the name
+// watermark jobs refer to them with.
+var builtinFonts = loadBuiltinFonts()
+
+func loadBuiltinFonts() map[string][]byte {
+	fonts := map[string][]byte{
+		"go-regular": goregular.TTF,
+		"go-bold":    gobold.TTF,
+		"go-italic":  goitalic.TTF,
+		"go-mono":    gomono.TTF,
+	}
+
+	entries, _ := embeddedFonts.ReadDir("fonts")
+	for _, entry := range entries {
+		data, err := embeddedFonts.ReadFile("fonts/" + entry.Name())
+		if err != nil {
+			continue
+		}
+		fonts[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = data
+	}
+
+	return fonts
+}
+
+// isCorrectFont reports whether the name refers to a builtin font or has the
+// form of an uploaded font id.
+func isCorrectFont(name string) bool {
+	if _, ok := builtinFonts[name]; ok {
+		return true
+	}
+
+	_, err := uuid.Parse(name)
+	return err == nil
+}
+
+// fontExtension returns the extension matching the outline format: CFF based
+// OpenType fonts start with "OTTO", TrueType fonts with a version number.
+func fontExtension(data []byte) string {
+	if strings.HasPrefix(string(data), "OTTO") {
+		return "otf"
+	}
+	return "ttf"
+}
+
+// CreateFont stores a TrueType or OpenType font that watermarks can use by
+// the returned id.
+func (s *Service) CreateFont(data []byte) (*uuid.UUID, error) {
+	ft, err := opentype.Parse(data)
+	if err != nil {
+		return nil, ErrInvalidFont
+	}
+
+	id := uuid.New()
+	fileName := id.String() + "." + fontExtension(data)
+	filePath := originDirName + "/" + fileName
+
+	if err := os.WriteFile(filePath, data, 0666); err != nil {
+		return nil, fmt.Errorf("could not save font: %w", err)
+	}
+	defer os.Remove(filePath)
+
+	if err := s.fileStorage.SaveImage(fileName, filePath, fontsBucket); err != nil {
+		return nil, err
+	}
+
+	s.fonts.put(id.String(), ft)
+
+	return &id, nil
+}
+
+// loadFont returns a parsed font by its name, the default font when the name
+// is empty. Fonts are parsed once, uploaded fonts are downloaded from the
+// file storage the first time they are used.
+func (s *Service) loadFont(name string) (*opentype.Font, error) {
+	if name == "" {
+		name = defaultFont
+	}
+
+	if ft, ok := s.fonts.get(name); ok {
+		return ft, nil
+	}
+
+	data, err := s.fontData(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ft, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse font %s: %w", name, err)
+	}
+
+	s.fonts.put(name, ft)
+
+	return ft, nil
+}
+
+func (s *Service) fontData(name string) ([]byte, error) {
+	if data, ok := builtinFonts[name]; ok {
+		return data, nil
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchFont, name)
+	}
+
+	// Workers may load the same font at once, each downloads its own copy.
+	for _, extension := range fontExtensions {
+		fileName := id.String() + "." + extension
+		filePath := originDirName + "/" + uuid.NewString() + "-" + fileName
+
+		if err := s.fileStorage.GetImage(fileName, filePath, fontsBucket); err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filePath)
+		os.Remove(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read font: %w", err)
+		}
+
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoSuchFont, name)
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+)
+
+const (
+	CompressionDefault = "default"
+	CompressionNone    = "none"
+	CompressionFast    = "fast"
+	CompressionBest    = "best"
+)
+
+const (
+	maxJPEGQuality = 100
+	minGIFColors   = 2
+	maxGIFColors   = 256
+)
+
+func isCorrectCompression(compression string) bool {
+	compressions := map[string]struct{}{
+		CompressionDefault: struct{}{},
+		CompressionNone:    struct{}{},
+		CompressionFast:    struct{}{},
+		CompressionBest:    struct{}{},
+	}
+
+	_, ok := compressions[compression]
+
+	return ok
+}
+
+func validateOutput(out dto.Output) error {
+	if out.Format != "" && !isCorrectFormat(out.Format) {
+		return ErrInvalidOutput
+	}
+
+	if out.Background != "" {
+		if _, err := parseColor(out.Background); err != nil {
+			return ErrInvalidOutput
+		}
+	}
+
+	if out.JPEGQuality < 0 || out.JPEGQuality > maxJPEGQuality {
+		return ErrInvalidOutput
+	}
+
+	if out.PNGCompression != "" && !isCorrectCompression(out.PNGCompression) {
+		return ErrInvalidOutput
+	}
+
+	if out.GIFColors != 0 && (out.GIFColors < minGIFColors || out.GIFColors > maxGIFColors) {
+		return ErrInvalidOutput
+	}
+
+	if out.GIFQuantizer != "" && !isCorrectQuantizer(out.GIFQuantizer) {
+		return ErrInvalidOutput
+	}
+
+	if out.ColorProfile != "" && out.ColorProfile != ColorProfileSRGB && out.ColorProfile != ColorProfilePreserve {
+		return ErrInvalidOutput
+	}
+
+	return nil
+}
+
+// mergeOutput returns base with every option that is set in override replaced.
+func mergeOutput(base, override dto.Output) dto.Output {
+	result := base
+	if override.Format != "" {
+		result.Format = override.Format
+	}
+	if override.Background != "" {
+		result.Background = override.Background
+	}
+	if override.JPEGQuality != 0 {
+		result.JPEGQuality = override.JPEGQuality
+	}
+	if override.PNGCompression != "" {
+		result.PNGCompression = override.PNGCompression
+	}
+	if override.GIFColors != 0 {
+		result.GIFColors = override.GIFColors
+	}
+	if override.GIFQuantizer != "" {
+		result.GIFQuantizer = override.GIFQuantizer
+	}
+	if override.GIFDither != nil {
+		result.GIFDither = override.GIFDither
+	}
+	if override.StripMetadata != nil {
+		result.StripMetadata = override.StripMetadata
+	}
+	if override.KeepICCProfile != nil {
+		result.KeepICCProfile = override.KeepICCProfile
+	}
+	if override.KeepCopyright != nil {
+		result.KeepCopyright = override.KeepCopyright
+	}
+	if override.ColorProfile != "" {
+		result.ColorProfile = override.ColorProfile
+	}
+
+	return result
+}
+
+// resolveOutput merges the output options of a variant over the options of
+// the job and fills in the defaults: the format of the original and a white
+// background.
+func resolveOutput(job, variant dto.Output, format string) dto.Output {
+	result := mergeOutput(job, variant)
+
+	if result.Format == "" {
+		result.Format = format
+	}
+	if alias, ok := formatAliases[result.Format]; ok {
+		result.Format = alias
+	}
+	if result.Background == "" {
+		result.Background = defaultBackground
+	}
+
+	return result
+}
+
+func jpegOptions(opts dto.Output) *jpeg.Options {
+	if opts.JPEGQuality == 0 {
+		return nil
+	}
+	return &jpeg.Options{Quality: opts.JPEGQuality}
+}
+
+func pngCompressionLevel(compression string) png.CompressionLevel {
+	switch compression {
+	case CompressionNone:
+		return png.NoCompression
+	case CompressionFast:
+		return png.BestSpeed
+	case CompressionBest:
+		return png.BestCompression
+	}
+	return png.DefaultCompression
+}
+
+// gifOptions returns the palette options of the GIF encoder. Dithering is
+// enabled unless it is explicitly turned off.
+func gifOptions(opts dto.Output) *gif.Options {
+	options := &gif.Options{
+		NumColors: maxGIFColors,
+		Quantizer: gifQuantizer(opts.GIFQuantizer),
+	}
+
+	if opts.GIFColors != 0 {
+		options.NumColors = opts.GIFColors
+	}
+
+	if opts.GIFDither != nil && !*opts.GIFDither {
+		options.Drawer = draw.Src
+	}
+
+	return options
+}
+
+// processedFileName replaces the extension of the original file name with the output format.
+func processedFileName(fileName, format string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "." + format
+}
+
+// supportsAlpha reports whether the format can store partially transparent
+// pixels. BMP is flattened too, since most readers ignore its alpha channel.
+func supportsAlpha(format string) bool {
+	return format == "png" || format == "webp" || format == "tiff"
+}
+
+// flatten composites the image over an opaque background. Opaque images are
+// returned as is.
+func flatten(img image.Image, background string) (image.Image, error) {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img, nil
+	}
+
+	bg, err := parseColor(background)
+	if err != nil {
+		return nil, err
+	}
+	bg.A = 0xff
+
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Over)
+
+	return canvas, nil
+}
@@ -11,17 +11,55 @@ func (s *Service) GetImageStatus(id uuid.UUID) (*model.Image, error) {
 	return s.storage.GetImageInfo(id)
 }
 
-func (s *Service) GetImageById(id uuid.UUID) (string, error) {
+// GetImageMetadata returns the EXIF, IPTC and XMP metadata of the original
+// image. It is extracted by the worker, so it is not known before the job is
+// picked up; images without metadata get an empty one.
+func (s *Service) GetImageMetadata(id uuid.UUID) (*model.Metadata, error) {
+	imageInfo, err := s.storage.GetImageInfo(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if imageInfo.Metadata != nil {
+		return imageInfo.Metadata, nil
+	}
+
+	if imageInfo.Status == statusInProgress {
+		return nil, ErrNotProcessdYet
+	}
+
+	return &model.Metadata{}, nil
+}
+
+// GetImageById downloads the processed image into the local directory and
+// returns its path. For images with variants the requested variant is
+// returned, or the first one when no variant name is given.
+func (s *Service) GetImageById(id uuid.UUID, variant string) (string, error) {
 	imageInfo, err := s.storage.GetImageInfo(id)
 	if err != nil {
 		return "", err
 	}
 
-	if imageInfo.Status == "in progress" {
+	fileName := id.String() + "." + storedFormat(imageInfo.OutputFormat, imageInfo.Format)
+	status := imageInfo.Status
+
+	if len(imageInfo.Variants) > 0 || variant != "" {
+		found, err := findVariant(imageInfo, variant)
+		if err != nil {
+			return "", err
+		}
+
+		fileName = variantFileName(id, found.Name, storedFormat(found.Format, imageInfo.Format))
+		status = found.Status
+	}
+
+	switch status {
+	case statusInProgress:
 		return "", ErrNotProcessdYet
+	case statusFailed:
+		return "", ErrProcessingFailed
 	}
 
-	fileName := id.String() + "." + imageInfo.Format
 	filePath := processedDirName + "/" + fileName
 	if err := s.fileStorage.GetImage(fileName, filePath, "processed"); err != nil {
 		return "", fmt.Errorf("could not get image from file storage: %w", err)
@@ -29,3 +67,12 @@ func (s *Service) GetImageById(id uuid.UUID) (string, error) {
 
 	return filePath, nil
 }
+
+// storedFormat returns the format the processed image was stored in. Images
+// created before output formats were introduced keep the original format.
+func storedFormat(outputFormat, format string) string {
+	if outputFormat == "" {
+		return format
+	}
+	return outputFormat
+}
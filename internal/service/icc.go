@@ -0,0 +1,423 @@
+package service
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	ColorProfileSRGB     = "srgb"
+	ColorProfilePreserve = "preserve"
+)
+
+const (
+	ColorSpaceSRGB     = "sRGB"
+	ColorSpaceP3       = "Display P3"
+	ColorSpaceAdobeRGB = "Adobe RGB (1998)"
+	ColorSpaceProPhoto = "ProPhoto RGB"
+	ColorSpaceGray     = "Gray"
+	ColorSpaceCMYK     = "CMYK"
+	ColorSpaceUnknown  = "unknown"
+)
+
+const (
+	iccHeaderSize = 128
+	// primariesTolerance absorbs the rounding of the colorants to the
+	// s15Fixed16 numbers of the profile and the differences between the
+	// chromatic adaptations used by profile vendors.
+	primariesTolerance = 0.005
+	srgbEncodeSteps    = 4095
+)
+
+// knownPrimaries are the colorant matrices of the common RGB colour spaces,
+// adapted to the D50 white point of the profile connection space. Columns
+// are the XYZ coordinates of the red, green and blue primaries.
+var knownPrimaries = []struct {
+	name   string
+	matrix [3][3]float64
+}{
+	{ColorSpaceSRGB, srgbPrimaries},
+	{ColorSpaceP3, [3][3]float64{
+		{0.5151, 0.2920, 0.1571},
+		{0.2412, 0.6922, 0.0666},
+		{-0.0011, 0.0419, 0.7841},
+	}},
+	{ColorSpaceAdobeRGB, [3][3]float64{
+		{0.6097559, 0.2052401, 0.1492240},
+		{0.3111242, 0.6256560, 0.0632197},
+		{0.0194811, 0.0608902, 0.7448387},
+	}},
+	{ColorSpaceProPhoto, [3][3]float64{
+		{0.7976749, 0.1351917, 0.0313534},
+		{0.2880402, 0.7118741, 0.0000857},
+		{0.0000000, 0.0000000, 0.8252100},
+	}},
+}
+
+var srgbPrimaries = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// srgbEncodeTable maps linear light, quantized to srgbEncodeSteps, to the
+// gamma encoded sRGB values.
+var srgbEncodeTable = func() [srgbEncodeSteps + 1]uint8 {
+	var table [srgbEncodeSteps + 1]uint8
+	for i := range table {
+		v := float64(i) / srgbEncodeSteps
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		table[i] = uint8(math.Round(v * 255))
+	}
+	return table
+}()
+
+// iccProfile is an ICC colour profile. Only RGB profiles built from tone
+// curves and a colorant matrix can be converted, which covers the profiles
+// cameras, phones and image editors embed. LUT based profiles are detected
+// but their pixels are left as they are.
+type iccProfile struct {
+	space       string
+	description string
+	primaries   [3][3]float64
+	curves      [3]toneCurve
+	matrixBased bool
+}
+
+// toneCurve converts an encoded channel value in [0, 1] to linear light.
+type toneCurve func(float64) float64
+
+// parseICCProfile reads the header and the tags of a profile, it returns nil
+// for data that is not an ICC profile.
+func parseICCProfile(data []byte) *iccProfile {
+	if len(data) < iccHeaderSize+4 || string(data[36:40]) != "acsp" {
+		return nil
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[iccHeaderSize:]))
+	for i := range count {
+		entry := iccHeaderSize + 4 + i*12
+		if entry+12 > len(data) {
+			return nil
+		}
+
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			continue
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile := &iccProfile{
+		space:       string(data[16:20]),
+		description: iccText(tags["desc"]),
+	}
+
+	if profile.space != "RGB " {
+		return profile
+	}
+
+	for i, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, ok := iccXYZ(tags[name])
+		if !ok {
+			return profile
+		}
+		for row := range 3 {
+			profile.primaries[row][i] = xyz[row]
+		}
+	}
+
+	for i, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, ok := iccCurve(tags[name])
+		if !ok {
+			return profile
+		}
+		profile.curves[i] = curve
+	}
+
+	profile.matrixBased = true
+	return profile
+}
+
+// colorSpace names the colour space of the profile. Matrix based profiles are
+// recognised by their primaries, whatever their description says; the other
+// ones are named by the description.
+func (p *iccProfile) colorSpace() string {
+	if p.matrixBased {
+		for _, known := range knownPrimaries {
+			if samePrimaries(p.primaries, known.matrix) {
+				return known.name
+			}
+		}
+	}
+
+	switch {
+	case p.description != "":
+		return p.description
+	case p.space == "GRAY":
+		return ColorSpaceGray
+	case p.space == "CMYK":
+		return ColorSpaceCMYK
+	}
+	return ColorSpaceUnknown
+}
+
+// convertible reports whether the pixels described by the profile can and
+// have to be converted to sRGB.
+func (p *iccProfile) convertible() bool {
+	return p != nil && p.matrixBased && !samePrimaries(p.primaries, srgbPrimaries)
+}
+
+func samePrimaries(a, b [3][3]float64) bool {
+	for row := range 3 {
+		for col := range 3 {
+			if math.Abs(a[row][col]-b[row][col]) > primariesTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// imageColorSpace returns the colour space of an encoded image. Images
+// without a profile are taken as sRGB, like browsers do.
+func imageColorSpace(data []byte, format string) string {
+	icc := findMetadataBlocks(data, format).icc
+	if icc == nil {
+		return ColorSpaceSRGB
+	}
+
+	profile := parseICCProfile(icc)
+	if profile == nil {
+		return ColorSpaceUnknown
+	}
+	return profile.colorSpace()
+}
+
+// toSRGB converts the pixels to sRGB: the tone curves of the profile give
+// linear light, which the colorants take to the connection space and the
+// inverse sRGB colorants to linear sRGB. Colours outside of sRGB are clipped.
+// The pixels are converted without premultiplied alpha, which would cost
+// precision in translucent areas.
+func (p *iccProfile) toSRGB(src image.Image) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+
+	toSRGB := multiply(invert(srgbPrimaries), p.primaries)
+
+	var decode [3][256]float64
+	for c := range 3 {
+		for v := range 256 {
+			decode[c][v] = p.curves[c](float64(v) / 255)
+		}
+	}
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if dst.Pix[i+3] == 0 {
+			continue
+		}
+
+		var linear [3]float64
+		for c := range 3 {
+			linear[c] = decode[c][dst.Pix[i+c]]
+		}
+
+		for c := range 3 {
+			value := toSRGB[c][0]*linear[0] + toSRGB[c][1]*linear[1] + toSRGB[c][2]*linear[2]
+			dst.Pix[i+c] = srgbEncodeTable[int(math.Round(clamp01(value)*srgbEncodeSteps))]
+		}
+	}
+
+	return dst
+}
+
+func iccXYZ(tag []byte) ([3]float64, bool) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, false
+	}
+
+	var xyz [3]float64
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+i*4:])
+	}
+	return xyz, true
+}
+
+// iccCurve reads a curveType, a single gamma or a sampled curve, or a
+// parametricCurveType tag.
+func iccCurve(tag []byte) (toneCurve, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case count == 0:
+			return func(v float64) float64 { return v }, true
+		case count == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, true
+		case len(tag) >= 12+count*2:
+			table := make([]float64, count)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 0xffff
+			}
+			return sampledCurve(table), true
+		}
+	case "para":
+		return parametricCurve(tag)
+	}
+	return nil, false
+}
+
+// sampledCurve interpolates linearly between the samples of the curve.
+func sampledCurve(table []float64) toneCurve {
+	return func(v float64) float64 {
+		pos := v * float64(len(table)-1)
+		i := min(int(pos), len(table)-2)
+		frac := pos - float64(i)
+		return table[i]*(1-frac) + table[i+1]*frac
+	}
+}
+
+// parametricCurve reads one of the five function types of a
+// parametricCurveType tag.
+func parametricCurve(tag []byte) (toneCurve, bool) {
+	counts := []int{1, 3, 4, 5, 7}
+	function := int(binary.BigEndian.Uint16(tag[8:]))
+	if function >= len(counts) || len(tag) < 12+counts[function]*4 {
+		return nil, false
+	}
+
+	var p [7]float64
+	for i := range counts[function] {
+		p[i] = s15Fixed16(tag[12+i*4:])
+	}
+	g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+	pow := func(v float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, g)
+	}
+
+	switch function {
+	case 0:
+		return func(v float64) float64 { return pow(v) }, true
+	case 1:
+		return func(v float64) float64 {
+			if v >= -b/a {
+				return pow(a*v + b)
+			}
+			return 0
+		}, true
+	case 2:
+		return func(v float64) float64 {
+			if v >= -b/a {
+				return pow(a*v+b) + c
+			}
+			return c
+		}, true
+	case 3:
+		return func(v float64) float64 {
+			if v >= d {
+				return pow(a*v + b)
+			}
+			return c * v
+		}, true
+	default:
+		return func(v float64) float64 {
+			if v >= d {
+				return pow(a*v+b) + e
+			}
+			return c*v + f
+		}, true
+	}
+}
+
+// iccText reads a textDescriptionType tag of version 2 profiles or the first
+// record of a multiLocalizedUnicodeType tag of version 4 profiles.
+func iccText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[:4]) {
+	case "desc":
+		length := int(binary.BigEndian.Uint32(tag[8:]))
+		if length <= 0 || 12+length > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+length]), "\x00")
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if offset < 0 || length < 0 || offset+length > len(tag) {
+			return ""
+		}
+
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func multiply(a, b [3][3]float64) [3][3]float64 {
+	var result [3][3]float64
+	for row := range 3 {
+		for col := range 3 {
+			for k := range 3 {
+				result[row][col] += a[row][k] * b[k][col]
+			}
+		}
+	}
+	return result
+}
+
+func invert(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	return [3][3]float64{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}
+}
@@ -1,82 +1,320 @@
 package service
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	"image/draw"
 	"os"
 
 	"github.com/Komilov31/image-processor/internal/dto"
+	"github.com/google/uuid"
 )
 
 const (
-	Resize    = "resize"
-	Watermark = "watermark"
-	Thumbnail = "miniature generating"
+	Resize       = "resize"
+	Watermark    = "watermark"
+	Thumbnail    = "miniature generating"
+	Crop         = "crop"
+	Rotate       = "rotate"
+	Flip         = "flip"
+	AutoOrient   = "auto-orient"
+	Adjust       = "adjust"
+	Blur         = "blur"
+	Sharpen      = "sharpen"
+	Convolve     = "convolve"
+	Redact       = "redact"
+	Border       = "border"
+	Padding      = "padding"
+	RoundCorners = "round-corners"
+	Canvas       = "canvas"
+	Caption      = "caption"
 )
 
+// ProcessImage decodes the original once and writes every output of the job
+// to the processed directory. A failing variant does not stop the others,
+// the errors of all failed variants are returned together.
 func (s *Service) ProcessImage(config dto.Message) error {
 	format, err := parseFormat(config.ContentType)
 	if err != nil {
 		return err
 	}
 
-	switch config.Task {
+	pic, orientation, err := loadImage(config.FileName, format)
+	if err != nil {
+		return err
+	}
+
+	return s.processPicture(pic, orientation, format, config)
+}
+
+// processPicture is ProcessImage for an original that is already decoded.
+func (s *Service) processPicture(pic *picture, orientation int, format string, config dto.Message) error {
+	var errs []error
+	for _, out := range outputs(config, format) {
+		err := s.processOutput(pic, orientation, autoOrientEnabled(config), out)
+		if err == nil {
+			continue
+		}
+
+		if out.variant == "" {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("could not process variant %s: %w", out.variant, err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// processOutput runs the pipeline of the output on every frame of the
+// picture. Only GIF keeps the animation, other formats get the first frame.
+// The metadata of the original is stripped unless the output keeps it.
+// Wide-gamut pixels are converted to sRGB first, unless the output preserves
+// the colour profile, which is then always embedded.
+func (s *Service) processOutput(pic *picture, orientation int, autoOrient bool, out output) error {
+	if pic.animated() && out.encoding.Format != "gif" {
+		pic = pic.first()
+	}
+
+	convert := out.encoding.ColorProfile != ColorProfilePreserve && pic.profile.convertible()
+
+	result, err := pic.transform(func(img image.Image) (image.Image, error) {
+		if convert {
+			img = pic.profile.toSRGB(img)
+		}
+		if autoOrient && !hasTask(out.operations, AutoOrient) {
+			img = orient(img, orientation)
+		}
+		return img, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, operation := range redactionsFirst(out.operations) {
+		result, err = s.applyToFrames(result, operation, orientation)
+		if err != nil {
+			return fmt.Errorf("could not apply operation %d (%s): %w", i+1, operation.Task, err)
+		}
+	}
+
+	if !supportsAlpha(out.encoding.Format) {
+		result, err = result.transform(func(img image.Image) (image.Image, error) {
+			return flatten(img, out.encoding.Background)
+		})
+		if err != nil {
+			return fmt.Errorf("could not flatten transparent image: %w", err)
+		}
+	}
+
+	oriented := orientation != 1 && (autoOrient || hasTask(out.operations, AutoOrient))
+	result.metadata = outputMetadata(pic.metadata, out.encoding, oriented)
+	switch {
+	case convert:
+		result.metadata.icc = nil
+	case out.encoding.ColorProfile == ColorProfilePreserve:
+		result.metadata.icc = pic.metadata.icc
+	}
+
+	return saveImage(out.fileName, out.encoding, result)
+}
+
+// applyToFrames applies the operation to every frame of the picture. Smart
+// regions of animations are picked once from all frames, so the thumbnail or
+// crop does not jump around while the animation plays.
+func (s *Service) applyToFrames(pic *picture, operation dto.Operation, orientation int) (*picture, error) {
+	if pic.animated() {
+		switch {
+		case operation.Task == Thumbnail:
+			return smartResizeFrames(pic, thumbnailOptions)
+		case operation.Task == Resize && isSmartCover(operation.Resize):
+			return smartResizeFrames(pic, operation.Resize)
+		case operation.Task == Crop && operation.Crop.Gravity == GravitySmart:
+			return smartCropFrames(pic, operation.Crop)
+		}
+	}
+
+	return pic.transform(func(img image.Image) (image.Image, error) {
+		return s.applyOperation(img, operation, orientation)
+	})
+}
+
+func (s *Service) applyOperation(img image.Image, operation dto.Operation, orientation int) (image.Image, error) {
+	switch operation.Task {
 	case Resize:
-		return s.resizeImage(config.FileName, format, config.Resize.Width, config.Resize.Height)
+		return s.resizeImage(img, operation.Resize)
 	case Watermark:
-		return s.addWatermark(config.FileName, format, config.WatermarkText)
+		return s.addWatermark(img, operation.WatermarkText, operation.Watermark)
 	case Thumbnail:
-		return s.createThumbnail(config.FileName, format)
+		return s.createThumbnail(img)
+	case Crop:
+		return s.cropImage(img, operation.Crop)
+	case Rotate:
+		return rotateImage(img, operation.Rotate)
+	case Flip:
+		return flipImage(img, operation.Flip)
+	case AutoOrient:
+		return orient(img, orientation), nil
+	case Adjust:
+		return adjustImage(img, operation.Adjust), nil
+	case Blur:
+		return blurImage(img, operation.Blur), nil
+	case Sharpen:
+		return sharpenImage(img, operation.Sharpen), nil
+	case Convolve:
+		return convolveImage(img, operation.Convolve), nil
+	case Redact:
+		return redactImage(img, operation.Redact)
+	case Border:
+		return addBorder(img, operation.Border)
+	case Padding:
+		return addPadding(img, operation.Padding)
+	case RoundCorners:
+		return roundCorners(img, operation.RoundCorners), nil
+	case Canvas:
+		return placeOnCanvas(img, operation.Canvas)
+	case Caption:
+		return s.addCaption(img, operation.Caption)
 	}
 
-	return fmt.Errorf("invalid task")
+	return nil, fmt.Errorf("invalid task")
 }
 
-func (s *Service) addWatermark(fileName, format, watermarkText string) error {
-	input, err := os.Open(originDirName + "/" + fileName)
+// loadImage decodes the original image and returns it together with its EXIF
+// orientation. Orientation is only read from JPEGs and is 1 for other formats.
+// GIFs are decoded with all their frames. The picture keeps the metadata
+// blocks and the colour profile of the original.
+func loadImage(fileName, format string) (*picture, int, error) {
+	data, err := os.ReadFile(originDirName + "/" + fileName)
 	if err != nil {
-		return fmt.Errorf("no file with name: %s", fileName)
+		return nil, 0, fmt.Errorf("no file with name: %s", fileName)
 	}
 
-	img, err := decode(format, input)
+	var pic *picture
+	if format == "gif" {
+		pic, err = decodeAnimation(bytes.NewReader(data))
+	} else {
+		var img image.Image
+		img, err = decode(format, bytes.NewReader(data))
+		pic = still(img)
+	}
 	if err != nil {
-		return fmt.Errorf("could not read image: %w", err)
+		return nil, 0, fmt.Errorf("could not read image: %w", err)
 	}
 
-	rect := img.Bounds()
-	x := rect.Min.X + rect.Dx()/2
-	y := rect.Min.Y + rect.Dy() - 30
+	pic.metadata = findMetadataBlocks(data, format)
+	pic.profile = parseICCProfile(pic.metadata.icc)
 
-	rgbaImg := image.NewRGBA(img.Bounds())
-	draw.Draw(rgbaImg, rgbaImg.Bounds(), img, image.Point{0, 0}, draw.Src)
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(data)
+	}
+
+	return pic, orientation, nil
+}
 
-	if err := addLabel(rgbaImg, x, y, watermarkText, 60); err != nil {
-		return fmt.Errorf("could not draw watermark: %w", err)
+// saveImage encodes the picture with its metadata into the processed
+// directory.
+func saveImage(fileName string, opts dto.Output, pic *picture) error {
+	var buf bytes.Buffer
+	var err error
+	if pic.animated() {
+		err = encodeAnimation(opts, pic, &buf)
+	} else {
+		err = encode(opts, pic.frames[0], &buf)
 	}
 
-	output, err := os.OpenFile(processedDirName+"/"+fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
+		return fmt.Errorf("could not encode processed image: %w", err)
+	}
+
+	data := embedMetadata(opts.Format, buf.Bytes(), pic.metadata)
+	if err := os.WriteFile(processedDirName+"/"+fileName, data, 0644); err != nil {
 		return fmt.Errorf("could not open file to store processed image")
 	}
 
-	return encode(format, rgbaImg, output)
+	return nil
 }
 
-func (s *Service) createThumbnail(fileName, format string) error {
-	return s.resizeImage(fileName, format, 200, 200)
+// addWatermark draws the text, or the overlay when one is referenced, at the
+// anchor position of the watermark options or repeated over the whole image
+// in the tiled mode. Unset options fall back to a semi-transparent mark at the
+// bottom centre.
+func (s *Service) addWatermark(img image.Image, watermarkText string, opts dto.Watermark) (image.Image, error) {
+	opts = watermarkDefaults(opts)
+
+	rgbaImg := image.NewRGBA(img.Bounds())
+	draw.Draw(rgbaImg, rgbaImg.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	mark, err := s.watermarkImage(rgbaImg.Bounds(), watermarkText, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not draw watermark: %w", err)
+	}
+
+	if mark == nil {
+		return rgbaImg, nil
+	}
+
+	if opts.Mode == WatermarkTiled {
+		err = drawTiledWatermark(rgbaImg, mark, opts)
+	} else {
+		err = drawWatermark(rgbaImg, mark, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not draw watermark: %w", err)
+	}
+
+	return rgbaImg, nil
 }
 
-func (s *Service) resizeImage(fileName, format string, width, height int) error {
-	input, err := os.Open(originDirName + "/" + fileName)
+func (s *Service) watermarkImage(bounds image.Rectangle, watermarkText string, opts dto.Watermark) (image.Image, error) {
+	if opts.OverlayID != "" {
+		id, err := uuid.Parse(opts.OverlayID)
+		if err != nil {
+			return nil, err
+		}
+
+		overlay, err := s.loadOverlay(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return watermarkOverlay(bounds, overlay, opts)
+	}
+
+	ft, err := s.loadFont(opts.Font)
 	if err != nil {
-		return fmt.Errorf("no file with name: %s", fileName)
+		return nil, err
 	}
 
-	output, err := os.OpenFile(processedDirName+"/"+fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	return watermarkLabel(bounds, ft, watermarkText, opts)
+}
+
+var thumbnailOptions = dto.Resize{
+	Width:   thumbnailSize,
+	Height:  thumbnailSize,
+	Mode:    ResizeFill,
+	Gravity: GravitySmart,
+}
+
+func (s *Service) createThumbnail(img image.Image) (image.Image, error) {
+	return s.resizeImage(img, thumbnailOptions)
+}
+
+func (s *Service) resizeImage(img image.Image, opts dto.Resize) (image.Image, error) {
+	return resizeWithMode(img, opts)
+}
+
+func (s *Service) cropImage(img image.Image, opts dto.Crop) (image.Image, error) {
+	rect, err := cropRect(img.Bounds(), opts)
 	if err != nil {
-		return fmt.Errorf("could not open file to store processed image")
+		return nil, err
+	}
+
+	if opts.Gravity == GravitySmart {
+		rect = smartRect(img, rect.Dx(), rect.Dy())
 	}
 
-	return resize(format, input, output, width, height)
+	return cropToRect(img, rect), nil
 }
@@ -0,0 +1,743 @@
+package service
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Komilov31/image-processor/internal/model"
+)
+
+const (
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+)
+
+// EXIF tags of the primary image directory, the Exif directory and the GPS
+// directory.
+const (
+	tagMake              = 0x010f
+	tagModel             = 0x0110
+	tagSoftware          = 0x0131
+	tagDateTime          = 0x0132
+	tagArtist            = 0x013b
+	tagXMP               = 0x02bc
+	tagCopyright         = 0x8298
+	tagIPTC              = 0x83bb
+	tagICCProfile        = 0x8773
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagExposureTime      = 0x829a
+	tagFNumber           = 0x829d
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagOffsetTimeOrig    = 0x9011
+	tagFocalLength       = 0x920a
+	tagLensMake          = 0xa433
+	tagLensModel         = 0xa434
+	tagGPSLatitudeRef    = 0x0001
+	tagGPSLatitude       = 0x0002
+	tagGPSLongitudeRef   = 0x0003
+	tagGPSLongitude      = 0x0004
+	tagGPSAltitudeRef    = 0x0005
+	tagGPSAltitude       = 0x0006
+	photoshopIPTCBlockID = 0x0404
+)
+
+var (
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader       = []byte("ICC_PROFILE\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+	pngSignature    = []byte("\x89PNG\r\n\x1a\n")
+)
+
+const xmpKeyword = "XML:com.adobe.xmp"
+
+// iptcDatasets names the datasets of the IPTC application record that are
+// extracted, keyed by their dataset number.
+var iptcDatasets = map[byte]string{
+	5:   "object_name",
+	25:  "keywords",
+	40:  "special_instructions",
+	55:  "date_created",
+	60:  "time_created",
+	80:  "by_line",
+	85:  "by_line_title",
+	90:  "city",
+	92:  "sub_location",
+	95:  "province_state",
+	101: "country",
+	105: "headline",
+	110: "credit",
+	115: "source",
+	116: "copyright_notice",
+	120: "caption",
+}
+
+const rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// xmpNamespaces are the XMP schemas that are extracted, with the prefixes
+// their properties are reported with.
+var xmpNamespaces = map[string]string{
+	"http://purl.org/dc/elements/1.1/":            "dc",
+	"http://ns.adobe.com/xap/1.0/":                "xmp",
+	"http://ns.adobe.com/xap/1.0/rights/":         "xmpRights",
+	"http://ns.adobe.com/photoshop/1.0/":          "photoshop",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/": "Iptc4xmpCore",
+	"http://ns.adobe.com/exif/1.0/":               "exif",
+	"http://ns.adobe.com/exif/1.0/aux/":           "aux",
+	"http://ns.adobe.com/tiff/1.0/":               "tiff",
+	"http://ns.adobe.com/lightroom/1.0/":          "lr",
+}
+
+// metadataBlocks are the raw EXIF (a TIFF structure), IPTC (IIM records),
+// XMP (an RDF document) and ICC colour profile blocks embedded in an image.
+type metadataBlocks struct {
+	exif []byte
+	iptc []byte
+	xmp  []byte
+	icc  []byte
+}
+
+func (b metadataBlocks) empty() bool {
+	return b.exif == nil && b.iptc == nil && b.xmp == nil && b.icc == nil
+}
+
+// extractMetadata returns the EXIF, IPTC and XMP metadata of a JPEG, PNG,
+// WebP or TIFF image, or nil when it has none. Malformed blocks are skipped.
+func extractMetadata(data []byte, format string) *model.Metadata {
+	blocks := findMetadataBlocks(data, format)
+
+	metadata := &model.Metadata{
+		EXIF: parseEXIF(blocks.exif),
+		IPTC: parseIPTC(blocks.iptc),
+		XMP:  parseXMP(blocks.xmp),
+	}
+
+	if metadata.EXIF == nil && metadata.IPTC == nil && metadata.XMP == nil {
+		return nil
+	}
+	return metadata
+}
+
+func findMetadataBlocks(data []byte, format string) metadataBlocks {
+	switch format {
+	case "jpeg":
+		return jpegMetadataBlocks(data)
+	case "png":
+		return pngMetadataBlocks(data)
+	case "webp":
+		return webpMetadataBlocks(data)
+	case "tiff":
+		return tiffMetadataBlocks(data)
+	}
+	return metadataBlocks{}
+}
+
+func jpegMetadataBlocks(data []byte) metadataBlocks {
+	var blocks metadataBlocks
+	var profile [][]byte
+	for _, segment := range jpegSegments(data) {
+		switch {
+		case segment.marker == markerAPP2 && bytes.HasPrefix(segment.data, iccHeader):
+			profile = append(profile, segment.data[len(iccHeader):])
+		case segment.marker == markerAPP1 && bytes.HasPrefix(segment.data, exifHeader):
+			blocks.exif = segment.data[len(exifHeader):]
+		case segment.marker == markerAPP1 && bytes.HasPrefix(segment.data, xmpHeader):
+			blocks.xmp = segment.data[len(xmpHeader):]
+		case segment.marker == markerAPP13 && bytes.HasPrefix(segment.data, photoshopHeader):
+			blocks.iptc = photoshopIPTC(segment.data[len(photoshopHeader):])
+		}
+	}
+	blocks.icc = joinICCChunks(profile)
+	return blocks
+}
+
+// joinICCChunks assembles a colour profile split over APP2 segments. Each
+// chunk starts with its one-based sequence number and the number of chunks.
+func joinICCChunks(chunks [][]byte) []byte {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	ordered := make([][]byte, len(chunks))
+	for _, chunk := range chunks {
+		if len(chunk) < 2 || int(chunk[1]) != len(chunks) || chunk[0] < 1 || int(chunk[0]) > len(chunks) {
+			return nil
+		}
+		ordered[chunk[0]-1] = chunk[2:]
+	}
+
+	var profile []byte
+	for _, chunk := range ordered {
+		if chunk == nil {
+			return nil
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile
+}
+
+// photoshopIPTC returns the IPTC block from the Photoshop image resources.
+// Each resource is "8BIM", a two byte id, a padded Pascal string name, the
+// size of the data and the data padded to an even length.
+func photoshopIPTC(data []byte) []byte {
+	pos := 0
+	for pos+7 <= len(data) && string(data[pos:pos+4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(data[pos+4:])
+
+		nameLength := int(data[pos+6])
+		pos += 6 + nameLength + 1
+		if nameLength%2 == 0 {
+			pos++
+		}
+
+		if pos+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(data) {
+			return nil
+		}
+
+		if id == photoshopIPTCBlockID {
+			return data[pos : pos+size]
+		}
+
+		pos += size + size%2
+	}
+	return nil
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// pngChunks returns the chunks of a PNG stream up to the end of the image.
+func pngChunks(data []byte) []pngChunk {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil
+	}
+
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+
+		chunk := pngChunk{typ: string(data[pos+4 : pos+8]), data: data[pos+8 : pos+8+length]}
+		chunks = append(chunks, chunk)
+		pos += 12 + length
+
+		if chunk.typ == "IEND" {
+			break
+		}
+	}
+	return chunks
+}
+
+func pngMetadataBlocks(data []byte) metadataBlocks {
+	var blocks metadataBlocks
+	for _, chunk := range pngChunks(data) {
+		switch chunk.typ {
+		case "eXIf":
+			blocks.exif = chunk.data
+		case "iCCP":
+			blocks.icc = pngColorProfile(chunk.data)
+		case "iTXt":
+			if keyword, text, ok := pngInternationalText(chunk.data); ok && keyword == xmpKeyword {
+				blocks.xmp = text
+			}
+		}
+	}
+	return blocks
+}
+
+// pngColorProfile decodes an iCCP chunk: the profile name, the compression
+// method and the zlib compressed profile.
+func pngColorProfile(data []byte) []byte {
+	_, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 1 || rest[0] != 0 {
+		return nil
+	}
+
+	profile, err := inflate(rest[1:])
+	if err != nil {
+		return nil
+	}
+	return profile
+}
+
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// pngInternationalText decodes an iTXt chunk: the keyword, the compression
+// flag and method, the language tag, the translated keyword and the text, the
+// strings are NUL separated.
+func pngInternationalText(data []byte) (string, []byte, bool) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 2 {
+		return "", nil, false
+	}
+
+	compressed := rest[0] == 1
+	rest = rest[2:]
+
+	for range 2 {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return "", nil, false
+		}
+	}
+
+	if !compressed {
+		return string(keyword), rest, true
+	}
+
+	text, err := inflate(rest)
+	if err != nil {
+		return "", nil, false
+	}
+	return string(keyword), text, true
+}
+
+type riffChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// webpChunks returns the chunks of a WebP RIFF container.
+func webpChunks(data []byte) []riffChunk {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+
+	var chunks []riffChunk
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if size < 0 || pos+8+size > len(data) {
+			break
+		}
+
+		chunks = append(chunks, riffChunk{fourCC: string(data[pos : pos+4]), data: data[pos+8 : pos+8+size]})
+		pos += 8 + size + size%2
+	}
+	return chunks
+}
+
+func webpMetadataBlocks(data []byte) metadataBlocks {
+	var blocks metadataBlocks
+	for _, chunk := range webpChunks(data) {
+		switch chunk.fourCC {
+		case "EXIF":
+			// Some writers keep the JPEG APP1 prefix.
+			blocks.exif = bytes.TrimPrefix(chunk.data, exifHeader)
+		case "ICCP":
+			blocks.icc = chunk.data
+		case "XMP ":
+			blocks.xmp = chunk.data
+		}
+	}
+	return blocks
+}
+
+// tiffMetadataBlocks returns the metadata of a TIFF file, which is itself the
+// EXIF structure and keeps IPTC, XMP and the colour profile in tags of the
+// first directory.
+func tiffMetadataBlocks(data []byte) metadataBlocks {
+	blocks := metadataBlocks{exif: data}
+
+	reader, err := newTIFFReader(data)
+	if err != nil {
+		return blocks
+	}
+
+	entries, _, err := reader.ifd(reader.firstIFD())
+	if err != nil {
+		return blocks
+	}
+
+	for _, entry := range entries {
+		switch entry.tag {
+		case tagIPTC:
+			blocks.iptc = entry.value
+		case tagXMP:
+			blocks.xmp = entry.value
+		case tagICCProfile:
+			blocks.icc = entry.value
+		}
+	}
+	return blocks
+}
+
+// parseEXIF reads the camera, lens, exposure, capture time, orientation and
+// GPS tags of an EXIF structure.
+func parseEXIF(payload []byte) *model.EXIF {
+	if payload == nil {
+		return nil
+	}
+
+	reader, err := newTIFFReader(payload)
+	if err != nil {
+		return nil
+	}
+
+	primary, _, err := reader.ifd(reader.firstIFD())
+	if err != nil {
+		return nil
+	}
+
+	exif := &model.EXIF{}
+	var exifDir, gpsDir []tiffEntry
+	var dateTime string
+
+	for _, entry := range primary {
+		switch entry.tag {
+		case tagMake:
+			exif.Make = reader.ascii(entry)
+		case tagModel:
+			exif.Model = reader.ascii(entry)
+		case tagSoftware:
+			exif.Software = reader.ascii(entry)
+		case tagArtist:
+			exif.Artist = reader.ascii(entry)
+		case tagCopyright:
+			exif.Copyright = reader.ascii(entry)
+		case tagDateTime:
+			dateTime = reader.ascii(entry)
+		case tagOrientation:
+			if value, ok := reader.uint(entry, 0); ok && value >= 1 && value <= 8 {
+				exif.Orientation = int(value)
+			}
+		case tagExifIFD:
+			exifDir = reader.subIFD(entry)
+		case tagGPSIFD:
+			gpsDir = reader.subIFD(entry)
+		}
+	}
+
+	var original, offset string
+	for _, entry := range exifDir {
+		switch entry.tag {
+		case tagExposureTime:
+			exif.ExposureTime = reader.exposureTime(entry)
+		case tagFNumber:
+			if value, ok := reader.rational(entry, 0); ok {
+				exif.FNumber = roundTo(value, 2)
+			}
+		case tagISO:
+			if value, ok := reader.uint(entry, 0); ok {
+				exif.ISO = int(value)
+			}
+		case tagDateTimeOriginal:
+			original = reader.ascii(entry)
+		case tagOffsetTimeOrig:
+			offset = reader.ascii(entry)
+		case tagFocalLength:
+			if value, ok := reader.rational(entry, 0); ok {
+				exif.FocalLength = roundTo(value, 2)
+			}
+		case tagLensMake:
+			exif.LensMake = reader.ascii(entry)
+		case tagLensModel:
+			exif.LensModel = reader.ascii(entry)
+		}
+	}
+
+	if original != "" {
+		exif.CaptureTime = exifTime(original, offset)
+	} else if dateTime != "" {
+		exif.CaptureTime = exifTime(dateTime, "")
+	}
+
+	exif.GPS = reader.gps(gpsDir)
+
+	if *exif == (model.EXIF{}) {
+		return nil
+	}
+	return exif
+}
+
+// subIFD reads the directory an offset entry points to.
+func (t *tiffReader) subIFD(entry tiffEntry) []tiffEntry {
+	offset, ok := t.uint(entry, 0)
+	if !ok {
+		return nil
+	}
+
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return nil
+	}
+	return entries
+}
+
+// exposureTime formats the exposure as a fraction of a second, or in seconds
+// for long exposures.
+func (t *tiffReader) exposureTime(entry tiffEntry) string {
+	num, den, ok := t.fraction(entry, 0)
+	if !ok || num <= 0 || den <= 0 {
+		return ""
+	}
+
+	if num < den && den%num == 0 {
+		return fmt.Sprintf("1/%d", den/num)
+	}
+	return fmt.Sprintf("%g", roundTo(float64(num)/float64(den), 4))
+}
+
+func (t *tiffReader) gps(entries []tiffEntry) *model.GPS {
+	var latitude, longitude, altitude *float64
+	latitudeRef, longitudeRef, altitudeRef := "N", "E", 0
+
+	for _, entry := range entries {
+		switch entry.tag {
+		case tagGPSLatitudeRef:
+			latitudeRef = t.ascii(entry)
+		case tagGPSLatitude:
+			latitude = t.degrees(entry)
+		case tagGPSLongitudeRef:
+			longitudeRef = t.ascii(entry)
+		case tagGPSLongitude:
+			longitude = t.degrees(entry)
+		case tagGPSAltitudeRef:
+			if value, ok := t.uint(entry, 0); ok {
+				altitudeRef = int(value)
+			}
+		case tagGPSAltitude:
+			if value, ok := t.rational(entry, 0); ok {
+				altitude = &value
+			}
+		}
+	}
+
+	if latitude == nil || longitude == nil {
+		return nil
+	}
+
+	gps := &model.GPS{Latitude: *latitude, Longitude: *longitude}
+	if latitudeRef == "S" {
+		gps.Latitude = -gps.Latitude
+	}
+	if longitudeRef == "W" {
+		gps.Longitude = -gps.Longitude
+	}
+	if altitude != nil {
+		value := roundTo(*altitude, 2)
+		if altitudeRef == 1 {
+			value = -value
+		}
+		gps.Altitude = &value
+	}
+	return gps
+}
+
+// degrees converts the degrees, minutes and seconds of a GPS coordinate to
+// decimal degrees.
+func (t *tiffReader) degrees(entry tiffEntry) *float64 {
+	var parts [3]float64
+	for i := range parts {
+		value, ok := t.rational(entry, i)
+		if !ok {
+			return nil
+		}
+		parts[i] = value
+	}
+
+	value := roundTo(parts[0]+parts[1]/60+parts[2]/3600, 7)
+	return &value
+}
+
+// exifTime converts an EXIF "YYYY:MM:DD HH:MM:SS" timestamp to the ISO 8601
+// form, with the UTC offset when it is known.
+func exifTime(value, offset string) string {
+	if len(value) < 19 || value[4] != ':' || value[7] != ':' {
+		return value
+	}
+
+	iso := value[:4] + "-" + value[5:7] + "-" + value[8:10] + "T" + value[11:19]
+	if len(offset) == 6 && (offset[0] == '+' || offset[0] == '-') {
+		iso += offset
+	}
+	return iso
+}
+
+func roundTo(value float64, digits int) float64 {
+	scale := math.Pow(10, float64(digits))
+	return math.Round(value*scale) / scale
+}
+
+// parseIPTC reads the application record datasets of IPTC IIM data. Each
+// dataset is the 0x1c tag marker, the record and dataset numbers and a two
+// byte length; extended lengths are not used by the application record.
+func parseIPTC(data []byte) map[string][]string {
+	values := make(map[string][]string)
+
+	pos := 0
+	for pos+5 <= len(data) && data[pos] == 0x1c {
+		record, dataset := data[pos+1], data[pos+2]
+		length := int(binary.BigEndian.Uint16(data[pos+3:]))
+		if length&0x8000 != 0 || pos+5+length > len(data) {
+			break
+		}
+
+		value := data[pos+5 : pos+5+length]
+		pos += 5 + length
+
+		name, ok := iptcDatasets[dataset]
+		if record != 2 || !ok {
+			continue
+		}
+
+		if text := strings.TrimSpace(decodeIPTCString(value)); text != "" {
+			values[name] = append(values[name], text)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// decodeIPTCString decodes a dataset value. Modern writers use UTF-8, older
+// ones Latin-1, which is the fallback for values that are not valid UTF-8.
+func decodeIPTCString(value []byte) string {
+	if utf8.Valid(value) {
+		return string(value)
+	}
+
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// parseXMP reads the properties of the known schemas from an XMP packet.
+// Properties are given as attributes of rdf:Description or as its child
+// elements, either with a simple value or with an rdf:Alt, rdf:Seq or rdf:Bag
+// array, whose items are all returned. Structured values are skipped.
+func parseXMP(data []byte) map[string][]string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	values := make(map[string][]string)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Space != rdfNamespace || start.Name.Local != "Description" {
+			continue
+		}
+
+		for _, attr := range start.Attr {
+			if name, ok := xmpPropertyName(attr.Name); ok && strings.TrimSpace(attr.Value) != "" {
+				values[name] = append(values[name], strings.TrimSpace(attr.Value))
+			}
+		}
+
+		if err := readXMPProperties(decoder, values); err != nil {
+			break
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// readXMPProperties reads the child elements of an rdf:Description up to its
+// end element.
+func readXMPProperties(decoder *xml.Decoder, values map[string][]string) error {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			items, err := readXMPValue(decoder)
+			if err != nil {
+				return err
+			}
+
+			if name, ok := xmpPropertyName(t.Name); ok && len(items) > 0 {
+				values[name] = append(values[name], items...)
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// readXMPValue reads a property element up to its end element and returns
+// its text, or the text of its rdf:li items.
+func readXMPValue(decoder *xml.Decoder) ([]string, error) {
+	var items []string
+	var text strings.Builder
+	var path []xml.Name
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name)
+		case xml.CharData:
+			switch {
+			case len(path) == 0:
+				text.Write(t)
+			case path[len(path)-1].Space == rdfNamespace && path[len(path)-1].Local == "li":
+				if item := strings.TrimSpace(string(t)); item != "" {
+					items = append(items, item)
+				}
+			}
+		case xml.EndElement:
+			if len(path) == 0 {
+				if value := strings.TrimSpace(text.String()); value != "" && len(items) == 0 {
+					items = append(items, value)
+				}
+				return items, nil
+			}
+			path = path[:len(path)-1]
+		}
+	}
+}
+
+func xmpPropertyName(name xml.Name) (string, bool) {
+	prefix, ok := xmpNamespaces[name.Space]
+	if !ok {
+		return "", false
+	}
+	return prefix + ":" + name.Local, true
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	"github.com/google/uuid"
+	res "github.com/nfnt/resize"
+)
+
+const overlaysBucket = "overlays"
+
+func overlayFileName(id uuid.UUID) string {
+	return id.String() + ".png"
+}
+
+// CreateOverlay stores an image that watermarks can reference by the
+// returned id. Overlays are kept as PNG, so any supported format with an
+// alpha channel keeps its transparency.
+func (s *Service) CreateOverlay(data []byte) (*uuid.UUID, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidOverlay
+	}
+
+	id := uuid.New()
+	fileName := overlayFileName(id)
+	filePath := originDirName + "/" + fileName
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not save overlay: %w", err)
+	}
+	defer os.Remove(filePath)
+
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not encode overlay: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("could not save overlay: %w", err)
+	}
+
+	if err := s.fileStorage.SaveImage(fileName, filePath, overlaysBucket); err != nil {
+		return nil, err
+	}
+
+	s.overlays.put(id, img)
+
+	return &id, nil
+}
+
+// loadOverlay returns a decoded overlay, downloading it from the file storage
+// the first time it is used.
+func (s *Service) loadOverlay(id uuid.UUID) (image.Image, error) {
+	if img, ok := s.overlays.get(id); ok {
+		return img, nil
+	}
+
+	// Workers may load the same overlay at once, each downloads its own copy.
+	fileName := overlayFileName(id)
+	filePath := originDirName + "/" + uuid.NewString() + "-" + fileName
+
+	if err := s.fileStorage.GetImage(fileName, filePath, overlaysBucket); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchOverlay, id)
+	}
+	defer os.Remove(filePath)
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read overlay: %w", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode overlay: %w", err)
+	}
+
+	s.overlays.put(id, img)
+
+	return img, nil
+}
+
+// watermarkOverlay scales the overlay to the requested fraction of the image
+// width, or keeps its own size. Overlays that do not fit into the margins
+// once rotated are shrunk, so they are never clipped. It returns nil when the
+// overlay cannot be made small enough.
+func watermarkOverlay(bounds image.Rectangle, overlay image.Image, opts dto.Watermark) (image.Image, error) {
+	area := watermarkArea(bounds, opts.Margin)
+	size := overlay.Bounds().Size()
+
+	factor := 1.0
+	if opts.Scale != 0 {
+		factor = opts.Scale * float64(bounds.Dx()) / float64(size.X)
+	}
+
+	// The bounding box of the rotated overlay grows linearly with its size,
+	// so measuring it once is enough to fit it into the margins.
+	rotated := rotatedSize(size, opts.Rotation)
+	factor = min(factor, float64(area.Dx())/float64(rotated.X), float64(area.Dy())/float64(rotated.Y))
+
+	width := int(math.Floor(float64(size.X) * factor))
+	height := int(math.Floor(float64(size.Y) * factor))
+	if width < 1 || height < 1 {
+		return nil, nil
+	}
+
+	if width == size.X && height == size.Y {
+		return overlay, nil
+	}
+
+	return res.Resize(uint(width), uint(height), overlay, res.Lanczos3), nil
+}
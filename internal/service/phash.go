@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/Komilov31/image-processor/internal/model"
+	res "github.com/nfnt/resize"
+)
+
+const (
+	hashSize   = 8
+	dctSize    = 32
+	hashBits   = hashSize * hashSize
+	hashFilter = res.Bilinear
+)
+
+// hashData returns the perceptual hashes of an encoded image. JPEGs are
+// oriented first, so a re-upload that was rotated by its EXIF tag matches the
+// original. Animated GIFs are hashed by their first frame.
+func hashData(data []byte, format string) (model.Hashes, error) {
+	img, err := decode(format, bytes.NewReader(data))
+	if err != nil {
+		return model.Hashes{}, fmt.Errorf("could not read image: %w", err)
+	}
+
+	if format == "jpeg" {
+		img = orient(img, exifOrientation(data))
+	}
+
+	return imageHashes(img), nil
+}
+
+// pictureHashes returns the perceptual hashes of a decoded original, its
+// first frame turned upright by the EXIF orientation like in hashData.
+func pictureHashes(pic *picture, orientation int) model.Hashes {
+	return imageHashes(orient(pic.frames[0], orientation))
+}
+
+// imageHashes computes the hashes on the luminance of the image, each one
+// from a downscaled copy of its own size.
+func imageHashes(img image.Image) model.Hashes {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+
+	return model.Hashes{
+		AHash: averageHash(gray),
+		DHash: differenceHash(gray),
+		PHash: perceptualHash(gray),
+	}
+}
+
+// averageHash sets a bit for every pixel of the 8x8 copy that is brighter
+// than the mean.
+func averageHash(gray *image.Gray) uint64 {
+	small := res.Resize(hashSize, hashSize, gray, hashFilter).(*image.Gray)
+
+	var sum int
+	for _, v := range small.Pix {
+		sum += int(v)
+	}
+
+	var hash uint64
+	for i, v := range small.Pix {
+		if int(v)*len(small.Pix) > sum {
+			hash |= 1 << (hashBits - 1 - i)
+		}
+	}
+	return hash
+}
+
+// differenceHash sets a bit for every pixel of the 9x8 copy that is darker
+// than its right neighbour, which captures the gradients of the image.
+func differenceHash(gray *image.Gray) uint64 {
+	small := res.Resize(hashSize+1, hashSize, gray, hashFilter).(*image.Gray)
+
+	var hash uint64
+	for y := range hashSize {
+		for x := range hashSize {
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				hash |= 1 << (hashBits - 1 - (y*hashSize + x))
+			}
+		}
+	}
+	return hash
+}
+
+// perceptualHash takes the DCT of the 32x32 copy and sets a bit for every
+// one of the 8x8 lowest frequencies that is above their median. The DC term
+// only holds the average brightness and is left out of the median.
+func perceptualHash(gray *image.Gray) uint64 {
+	small := res.Resize(dctSize, dctSize, gray, hashFilter).(*image.Gray)
+
+	var pixels [dctSize][dctSize]float64
+	for y := range dctSize {
+		for x := range dctSize {
+			pixels[y][x] = float64(small.GrayAt(x, y).Y)
+		}
+	}
+
+	coefficients := lowFrequencies(pixels)
+
+	sorted := make([]float64, 0, hashBits-1)
+	sorted = append(sorted, coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, v := range coefficients {
+		if v > median {
+			hash |= 1 << (hashBits - 1 - i)
+		}
+	}
+	return hash
+}
+
+// lowFrequencies returns the 8x8 lowest frequencies of the two-dimensional
+// DCT-II of the pixels, row by row.
+func lowFrequencies(pixels [dctSize][dctSize]float64) []float64 {
+	var basis [hashSize][dctSize]float64
+	for u := range hashSize {
+		for x := range dctSize {
+			basis[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * dctSize))
+		}
+	}
+
+	// The rows are transformed first, then the columns of the result.
+	var rows [dctSize][hashSize]float64
+	for y := range dctSize {
+		for u := range hashSize {
+			for x := range dctSize {
+				rows[y][u] += pixels[y][x] * basis[u][x]
+			}
+		}
+	}
+
+	coefficients := make([]float64, 0, hashBits)
+	for v := range hashSize {
+		for u := range hashSize {
+			var sum float64
+			for y := range dctSize {
+				sum += rows[y][u] * basis[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+	return coefficients
+}
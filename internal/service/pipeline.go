@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	webpenc "github.com/Komilov31/image-processor/internal/webp"
+)
+
+const (
+	maxOperations = 20
+)
+
+// operations returns the processing pipeline of the message. Messages without
+// an operations list are treated as a pipeline of their single task.
+func operations(message dto.Message) []dto.Operation {
+	if len(message.Operations) > 0 {
+		return message.Operations
+	}
+	return []dto.Operation{message.Operation}
+}
+
+func autoOrientEnabled(message dto.Message) bool {
+	return message.AutoOrient == nil || *message.AutoOrient
+}
+
+func hasTask(operations []dto.Operation, task string) bool {
+	for _, operation := range operations {
+		if operation.Task == task {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMessage checks every pipeline of the job before it is queued.
+func validateMessage(data []byte, format string, message dto.Message) error {
+	if err := validateOutput(message.Output); err != nil {
+		return err
+	}
+
+	if len(message.Variants) == 0 {
+		output := resolveOutput(message.Output, dto.Output{}, format)
+		return validatePipeline(data, format, output.Format, autoOrientEnabled(message), operations(message))
+	}
+
+	if err := validateVariants(message.Variants); err != nil {
+		return err
+	}
+
+	for _, variant := range message.Variants {
+		if err := validateOutput(variant.Output); err != nil {
+			return err
+		}
+
+		output := resolveOutput(message.Output, variant.Output, format)
+		if err := validatePipeline(data, format, output.Format, autoOrientEnabled(message), variant.Operations); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validatePipeline checks every operation of the pipeline. The dimensions of
+// the image are tracked through the operations, so crop rectangles are checked
+// against the image they will actually be applied to and no operation can grow
+// the image beyond maxDimension, or beyond the original when it is larger.
+// Redacted regions are checked against the original, turned upright when it
+// is auto-oriented. The final size must fit the output format, WebP cannot
+// describe sides beyond 16384 pixels.
+func validatePipeline(data []byte, format, outputFormat string, autoOrient bool, ops []dto.Operation) error {
+	if len(ops) > maxOperations {
+		return ErrInvalidOperations
+	}
+
+	for _, operation := range ops {
+		if err := validateOperation(operation); err != nil {
+			return err
+		}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ErrInvalidImage
+	}
+	limit := max(maxDimension, config.Width, config.Height)
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(data)
+	}
+
+	size := image.Pt(config.Width, config.Height)
+	if autoOrient && !hasTask(ops, AutoOrient) {
+		size = orientedSize(size, orientation)
+	}
+
+	// The operations are checked in the order they run in, redactions before
+	// everything but auto-orient.
+	for _, operation := range redactionsFirst(ops) {
+		switch operation.Task {
+		case Redact:
+			if err := checkRedactRegions(size, operation.Redact); err != nil {
+				return err
+			}
+		case Crop:
+			if _, err := cropRect(image.Rectangle{Max: size}, operation.Crop); err != nil {
+				return err
+			}
+		}
+		size = operationSize(size, operation, orientation)
+		if size.X > limit || size.Y > limit {
+			return ErrInvalidOperations
+		}
+	}
+
+	if outputFormat == "webp" && (size.X > webpenc.MaxDimension || size.Y > webpenc.MaxDimension) {
+		return ErrInvalidImage
+	}
+
+	return nil
+}
+
+func validateOperation(operation dto.Operation) error {
+	if !isCorrectTask(operation.Task) {
+		return ErrInvalidTask
+	}
+
+	switch operation.Task {
+	case Resize:
+		if !isCorrectResize(operation.Resize) {
+			return ErrInvalidResize
+		}
+	case Watermark:
+		if !isCorrectWatermark(operation.Watermark) {
+			return ErrInvalidWatermark
+		}
+	case Rotate:
+		if !isCorrectRotate(operation.Rotate) {
+			return ErrInvalidRotate
+		}
+	case Flip:
+		if !isCorrectFlip(operation.Flip) {
+			return ErrInvalidFlip
+		}
+	case Adjust:
+		if !isCorrectAdjust(operation.Adjust) {
+			return ErrInvalidAdjust
+		}
+	case Blur:
+		if !isCorrectBlur(operation.Blur) {
+			return ErrInvalidBlur
+		}
+	case Sharpen:
+		if !isCorrectSharpen(operation.Sharpen) {
+			return ErrInvalidSharpen
+		}
+	case Convolve:
+		if !isCorrectConvolve(operation.Convolve) {
+			return ErrInvalidConvolve
+		}
+	case Redact:
+		if !isCorrectRedact(operation.Redact) {
+			return ErrInvalidRedact
+		}
+	case Border:
+		if !isCorrectBorder(operation.Border) {
+			return ErrInvalidBorder
+		}
+	case Padding:
+		if !isCorrectPadding(operation.Padding) {
+			return ErrInvalidPadding
+		}
+	case RoundCorners:
+		if !isCorrectRoundCorners(operation.RoundCorners) {
+			return ErrInvalidRoundCorners
+		}
+	case Canvas:
+		if !isCorrectCanvas(operation.Canvas) {
+			return ErrInvalidCanvas
+		}
+	case Caption:
+		if !isCorrectCaption(operation.Caption) {
+			return ErrInvalidCaption
+		}
+	}
+
+	return nil
+}
+
+// operationSize returns the dimensions of an image of the given size after the operation.
+func operationSize(size image.Point, operation dto.Operation, orientation int) image.Point {
+	switch operation.Task {
+	case Resize:
+		return resizedSize(size, operation.Resize)
+	case Thumbnail:
+		return image.Pt(thumbnailSize, thumbnailSize)
+	case Crop:
+		return image.Pt(operation.Crop.Width, operation.Crop.Height)
+	case Rotate:
+		return rotatedSize(size, operation.Rotate.Angle)
+	case AutoOrient:
+		return orientedSize(size, orientation)
+	case Border:
+		return paddedSize(size, borderInsets(operation.Border))
+	case Padding:
+		return paddedSize(size, paddingInsets(operation.Padding))
+	case Canvas:
+		return image.Pt(operation.Canvas.Width, operation.Canvas.Height)
+	}
+	return size
+}
+
+func orientedSize(size image.Point, orientation int) image.Point {
+	if orientation >= 5 && orientation <= 8 {
+		return image.Pt(size.Y, size.X)
+	}
+	return size
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"slices"
+)
+
+const (
+	QuantizerPlan9     = "plan9"
+	QuantizerWebSafe   = "websafe"
+	QuantizerMedianCut = "median-cut"
+)
+
+func isCorrectQuantizer(quantizer string) bool {
+	quantizers := map[string]struct{}{
+		QuantizerPlan9:     struct{}{},
+		QuantizerWebSafe:   struct{}{},
+		QuantizerMedianCut: struct{}{},
+	}
+
+	_, ok := quantizers[quantizer]
+
+	return ok
+}
+
+// fixedQuantizer returns the first colours of a predefined palette, the same
+// way image/gif treats palette.Plan9 when no quantizer is given.
+type fixedQuantizer struct {
+	palette color.Palette
+}
+
+func (q fixedQuantizer) Quantize(p color.Palette, _ image.Image) color.Palette {
+	return append(p, q.palette[:min(cap(p)-len(p), len(q.palette))]...)
+}
+
+// medianCutQuantizer builds an adaptive palette by repeatedly splitting the
+// box of image colours with the most pixels along its widest channel.
+type medianCutQuantizer struct{}
+
+// colorBucket is a colour reduced to 5 bits per channel and the number of
+// pixels that fall into it.
+type colorBucket struct {
+	rgb   [3]uint8
+	count int
+}
+
+type colorBox []colorBucket
+
+func (q medianCutQuantizer) Quantize(p color.Palette, img image.Image) color.Palette {
+	buckets := colorHistogram(img)
+	boxes := []colorBox{buckets}
+
+	for len(boxes) < cap(p)-len(p) {
+		index := -1
+		for i, box := range boxes {
+			if len(box) > 1 && (index < 0 || box.pixels() > boxes[index].pixels()) {
+				index = i
+			}
+		}
+		if index < 0 {
+			break
+		}
+
+		left, right := boxes[index].split()
+		boxes[index] = left
+		boxes = append(boxes, right)
+	}
+
+	for _, box := range boxes {
+		p = append(p, box.average())
+	}
+
+	return p
+}
+
+func colorHistogram(img image.Image) colorBox {
+	counts := make(map[[3]uint8]int)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			counts[[3]uint8{uint8(r >> 11), uint8(g >> 11), uint8(b >> 11)}]++
+		}
+	}
+
+	box := make(colorBox, 0, len(counts))
+	for rgb, count := range counts {
+		box = append(box, colorBucket{rgb: rgb, count: count})
+	}
+
+	// Map iteration order is random, sorting keeps the palette deterministic.
+	slices.SortFunc(box, func(a, b colorBucket) int {
+		return int(a.rgb[0])<<10 | int(a.rgb[1])<<5 | int(a.rgb[2]) - (int(b.rgb[0])<<10 | int(b.rgb[1])<<5 | int(b.rgb[2]))
+	})
+
+	return box
+}
+
+func (box colorBox) pixels() int {
+	total := 0
+	for _, bucket := range box {
+		total += bucket.count
+	}
+	return total
+}
+
+// split sorts the box along its widest channel and cuts it at the median pixel.
+func (box colorBox) split() (colorBox, colorBox) {
+	channel, widest := 0, -1
+	for c := 0; c < 3; c++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, bucket := range box {
+			lo, hi = min(lo, bucket.rgb[c]), max(hi, bucket.rgb[c])
+		}
+		if int(hi)-int(lo) > widest {
+			channel, widest = c, int(hi)-int(lo)
+		}
+	}
+
+	slices.SortStableFunc(box, func(a, b colorBucket) int {
+		return int(a.rgb[channel]) - int(b.rgb[channel])
+	})
+
+	half, seen := box.pixels()/2, 0
+	for i, bucket := range box[:len(box)-1] {
+		seen += bucket.count
+		if seen >= half {
+			return box[:i+1], box[i+1:]
+		}
+	}
+
+	return box[:len(box)-1], box[len(box)-1:]
+}
+
+func (box colorBox) average() color.Color {
+	var r, g, b, total int
+	for _, bucket := range box {
+		r += int(bucket.rgb[0]) * bucket.count
+		g += int(bucket.rgb[1]) * bucket.count
+		b += int(bucket.rgb[2]) * bucket.count
+		total += bucket.count
+	}
+
+	if total == 0 {
+		return color.Black
+	}
+
+	// Buckets hold 5-bit channels, scale the average back to 8 bits.
+	scale := func(v int) uint8 { return uint8((v*255/total + 15) / 31) }
+	return color.RGBA{scale(r), scale(g), scale(b), 0xff}
+}
+
+func gifQuantizer(name string) draw.Quantizer {
+	switch name {
+	case QuantizerWebSafe:
+		return fixedQuantizer{palette: palette.WebSafe}
+	case QuantizerMedianCut:
+		return medianCutQuantizer{}
+	}
+	return fixedQuantizer{palette: palette.Plan9}
+}
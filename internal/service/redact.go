@@ -0,0 +1,182 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+)
+
+const (
+	RedactPixelate = "pixelate"
+	RedactBlur     = "blur"
+	RedactFill     = "fill"
+)
+
+const (
+	defaultRedactBlockSize = 16
+	defaultRedactSigma     = 10
+	defaultRedactColor     = "#000000"
+	maxRedactRegions       = 100
+	minRedactBlockSize     = 4
+	maxRedactBlockSize     = 1000
+	minRedactSigma         = 2
+)
+
+func isCorrectRedact(opts dto.Redact) bool {
+	if opts.Method != "" && opts.Method != RedactPixelate && opts.Method != RedactBlur && opts.Method != RedactFill {
+		return false
+	}
+
+	if len(opts.Regions) == 0 || len(opts.Regions) > maxRedactRegions {
+		return false
+	}
+
+	for _, region := range opts.Regions {
+		if region.X < 0 || region.Y < 0 || region.Width <= 0 || region.Height <= 0 {
+			return false
+		}
+	}
+
+	// Zero selects the default; anything smaller than the minimum would leave
+	// the region recognisable.
+	if opts.BlockSize != 0 && (opts.BlockSize < minRedactBlockSize || opts.BlockSize > maxRedactBlockSize) {
+		return false
+	}
+
+	if math.IsNaN(opts.Sigma) || (opts.Sigma != 0 && (opts.Sigma < minRedactSigma || opts.Sigma > maxBlurSigma)) {
+		return false
+	}
+
+	if opts.Color != "" {
+		if _, err := parseColor(opts.Color); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func regionRect(bounds image.Rectangle, region dto.Region) image.Rectangle {
+	return image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height).Add(bounds.Min)
+}
+
+// checkRedactRegions makes sure every region lies inside an image of the
+// given size.
+func checkRedactRegions(size image.Point, opts dto.Redact) error {
+	bounds := image.Rectangle{Max: size}
+	for _, region := range opts.Regions {
+		if !regionRect(bounds, region).In(bounds) {
+			return ErrInvalidRedact
+		}
+	}
+	return nil
+}
+
+// redactionsFirst moves the redactions of the pipeline in front of the other
+// operations, so the redacted pixels are never resized, blurred or otherwise
+// carried into the result before they are hidden. Explicit auto-orient steps
+// go in front of the redactions, whose coordinates always refer to the
+// upright image. The order of the remaining operations is kept.
+func redactionsFirst(ops []dto.Operation) []dto.Operation {
+	if !hasTask(ops, Redact) {
+		return ops
+	}
+
+	ordered := make([]dto.Operation, 0, len(ops))
+	for _, task := range []string{AutoOrient, Redact} {
+		for _, operation := range ops {
+			if operation.Task == task {
+				ordered = append(ordered, operation)
+			}
+		}
+	}
+	for _, operation := range ops {
+		if operation.Task != AutoOrient && operation.Task != Redact {
+			ordered = append(ordered, operation)
+		}
+	}
+	return ordered
+}
+
+// redactImage hides every region of a copy of the image, the source is shared
+// between the outputs of the job and is left untouched.
+func redactImage(src image.Image, opts dto.Redact) (image.Image, error) {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+
+	var fill color.NRGBA
+	if opts.Method == RedactFill {
+		col := opts.Color
+		if col == "" {
+			col = defaultRedactColor
+		}
+
+		var err error
+		fill, err = parseColor(col)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, region := range opts.Regions {
+		rect := regionRect(dst.Bounds(), region).Intersect(dst.Bounds())
+		if rect.Empty() {
+			continue
+		}
+
+		switch opts.Method {
+		case RedactBlur:
+			sigma := opts.Sigma
+			if sigma == 0 {
+				sigma = defaultRedactSigma
+			}
+			blurred := gaussianBlur(dst.SubImage(rect), sigma)
+			draw.Draw(dst, rect, blurred, image.Point{}, draw.Src)
+		case RedactFill:
+			draw.Draw(dst, rect, image.NewUniform(fill), image.Point{}, draw.Src)
+		default:
+			blockSize := opts.BlockSize
+			if blockSize == 0 {
+				blockSize = defaultRedactBlockSize
+			}
+			pixelate(dst, rect, blockSize)
+		}
+	}
+
+	return dst, nil
+}
+
+// pixelate replaces every block of the rectangle with its average colour.
+// Blocks start at the top left corner of the rectangle, the last ones are
+// cut by its edges.
+func pixelate(img *image.RGBA, rect image.Rectangle, blockSize int) {
+	for y := rect.Min.Y; y < rect.Max.Y; y += blockSize {
+		for x := rect.Min.X; x < rect.Max.X; x += blockSize {
+			block := image.Rect(x, y, x+blockSize, y+blockSize).Intersect(rect)
+
+			var sum [4]int
+			for by := block.Min.Y; by < block.Max.Y; by++ {
+				i := img.PixOffset(block.Min.X, by)
+				for bx := block.Min.X; bx < block.Max.X; bx++ {
+					for c := range 4 {
+						sum[c] += int(img.Pix[i+c])
+					}
+					i += 4
+				}
+			}
+
+			n := block.Dx() * block.Dy()
+			avg := color.RGBA{
+				R: uint8((sum[0] + n/2) / n),
+				G: uint8((sum[1] + n/2) / n),
+				B: uint8((sum[2] + n/2) / n),
+				A: uint8((sum[3] + n/2) / n),
+			}
+			draw.Draw(img, block, image.NewUniform(avg), image.Point{}, draw.Src)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	res "github.com/nfnt/resize"
+)
+
+const (
+	ResizeFit     = "fit"
+	ResizeFill    = "fill"
+	ResizeCover   = "cover"
+	ResizePad     = "pad"
+	ResizeStretch = "stretch"
+)
+
+const (
+	FilterNearest  = "nearest"
+	FilterBilinear = "bilinear"
+	FilterBicubic  = "bicubic"
+	FilterMitchell = "mitchell"
+	FilterLanczos2 = "lanczos2"
+	FilterLanczos3 = "lanczos3"
+)
+
+// resizeFilters maps the filter names to the interpolations of the resize package.
+var resizeFilters = map[string]res.InterpolationFunction{
+	FilterNearest:  res.NearestNeighbor,
+	FilterBilinear: res.Bilinear,
+	FilterBicubic:  res.Bicubic,
+	FilterMitchell: res.MitchellNetravali,
+	FilterLanczos2: res.Lanczos2,
+	FilterLanczos3: res.Lanczos3,
+}
+
+const (
+	maxDimension      = 10000
+	thumbnailSize     = 200
+	defaultBackground = "#ffffff"
+)
+
+func isCorrectResize(opts dto.Resize) bool {
+	if opts.Width < 0 || opts.Height < 0 || (opts.Width == 0 && opts.Height == 0) {
+		return false
+	}
+
+	if opts.Width > maxDimension || opts.Height > maxDimension {
+		return false
+	}
+
+	switch opts.Mode {
+	case "", ResizeFit, ResizeFill, ResizeCover, ResizePad, ResizeStretch:
+	default:
+		return false
+	}
+
+	if opts.Background != "" {
+		if _, err := parseColor(opts.Background); err != nil {
+			return false
+		}
+	}
+
+	if opts.Gravity != "" && !isCorrectCropGravity(opts.Gravity) {
+		return false
+	}
+
+	if opts.Filter != "" {
+		if _, ok := resizeFilters[opts.Filter]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func resizeFilter(name string) res.InterpolationFunction {
+	if filter, ok := resizeFilters[name]; ok {
+		return filter
+	}
+	return res.Lanczos3
+}
+
+// resizeWithMode scales src into the width x height box described by opts.
+// When one of the dimensions is zero the image is scaled by the other one
+// and the aspect ratio is kept regardless of the mode.
+func resizeWithMode(src image.Image, opts dto.Resize) (image.Image, error) {
+	width, height := opts.Width, opts.Height
+	filter := resizeFilter(opts.Filter)
+	if width == 0 || height == 0 {
+		size := resizedSize(src.Bounds().Size(), opts)
+		return res.Resize(uint(size.X), uint(size.Y), src, filter), nil
+	}
+
+	bounds := src.Bounds()
+	switch opts.Mode {
+	case ResizeStretch:
+		return res.Resize(uint(width), uint(height), src, filter), nil
+	case "", ResizeFit:
+		w, h := fitSize(bounds.Dx(), bounds.Dy(), width, height)
+		return res.Resize(uint(w), uint(h), src, filter), nil
+	case ResizeFill, ResizeCover:
+		w, h := coverSize(bounds.Dx(), bounds.Dy(), width, height)
+		resized := res.Resize(uint(w), uint(h), src, filter)
+		return cropToRect(resized, anchorRect(resized, width, height, opts.Gravity)), nil
+	case ResizePad:
+		background := opts.Background
+		if background == "" {
+			background = defaultBackground
+		}
+
+		bg, err := parseColor(background)
+		if err != nil {
+			return nil, err
+		}
+
+		w, h := fitSize(bounds.Dx(), bounds.Dy(), width, height)
+		resized := res.Resize(uint(w), uint(h), src, filter)
+
+		canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+		offset := image.Pt((width-w)/2, (height-h)/2)
+		draw.Draw(canvas, image.Rectangle{offset, offset.Add(image.Pt(w, h))}, resized, resized.Bounds().Min, draw.Over)
+		return canvas, nil
+	}
+
+	return nil, ErrInvalidResize
+}
+
+// resizedSize returns the dimensions of an image of the given size after resizeWithMode.
+// Scaling by a single dimension keeps the other one within maxDimension, so a
+// thin image cannot be scaled into a huge one.
+func resizedSize(size image.Point, opts dto.Resize) image.Point {
+	switch {
+	case opts.Width == 0:
+		w, h := fitSize(size.X, size.Y, maxDimension, opts.Height)
+		return image.Pt(w, h)
+	case opts.Height == 0:
+		w, h := fitSize(size.X, size.Y, opts.Width, maxDimension)
+		return image.Pt(w, h)
+	case opts.Mode == "" || opts.Mode == ResizeFit:
+		w, h := fitSize(size.X, size.Y, opts.Width, opts.Height)
+		return image.Pt(w, h)
+	}
+	return image.Pt(opts.Width, opts.Height)
+}
+
+// fitSize returns the largest size with the srcW:srcH ratio that fits inside the box.
+func fitSize(srcW, srcH, boxW, boxH int) (int, int) {
+	if boxW*srcH <= boxH*srcW {
+		return boxW, max(1, (srcH*boxW+srcW/2)/srcW)
+	}
+	return max(1, (srcW*boxH+srcH/2)/srcH), boxH
+}
+
+// coverSize returns the smallest size with the srcW:srcH ratio that covers the box.
+// The covering dimension is limited to maxDimension, extremely thin images
+// are squeezed along it instead of being scaled into a huge intermediate.
+func coverSize(srcW, srcH, boxW, boxH int) (int, int) {
+	if boxW*srcH >= boxH*srcW {
+		return boxW, min(maxDimension, max(boxH, (srcH*boxW+srcW/2)/srcW))
+	}
+	return min(maxDimension, max(boxW, (srcW*boxH+srcH/2)/srcH)), boxH
+}
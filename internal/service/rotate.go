@@ -0,0 +1,241 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+)
+
+const (
+	FlipHorizontal = "horizontal"
+	FlipVertical   = "vertical"
+	FlipBoth       = "both"
+)
+
+func isCorrectRotate(opts dto.Rotate) bool {
+	if math.IsNaN(opts.Angle) || math.Abs(opts.Angle) > 360 {
+		return false
+	}
+
+	if opts.Background != "" {
+		if _, err := parseColor(opts.Background); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isCorrectFlip(direction string) bool {
+	switch direction {
+	case FlipHorizontal, FlipVertical, FlipBoth:
+		return true
+	}
+	return false
+}
+
+// rotateImage rotates src clockwise by opts.Angle degrees. Right angles are
+// rotated losslessly, any other angle expands the canvas to fit the rotated
+// image and fills the uncovered corners with the background colour.
+func rotateImage(src image.Image, opts dto.Rotate) (image.Image, error) {
+	angle := math.Mod(opts.Angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+
+	switch angle {
+	case 0:
+		return src, nil
+	case 90:
+		return rotate90(src), nil
+	case 180:
+		return rotate180(src), nil
+	case 270:
+		return rotate270(src), nil
+	}
+
+	background := opts.Background
+	if background == "" {
+		background = defaultBackground
+	}
+
+	bg, err := parseColor(background)
+	if err != nil {
+		return nil, err
+	}
+
+	return rotateAngle(src, angle, bg), nil
+}
+
+func flipImage(src image.Image, direction string) (image.Image, error) {
+	switch direction {
+	case FlipHorizontal:
+		return flipHorizontal(src), nil
+	case FlipVertical:
+		return flipVertical(src), nil
+	case FlipBoth:
+		return rotate180(src), nil
+	}
+	return nil, ErrInvalidFlip
+}
+
+// orient transforms src according to the EXIF orientation so that it is displayed upright.
+func orient(src image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return flipHorizontal(src)
+	case 3:
+		return rotate180(src)
+	case 4:
+		return flipVertical(src)
+	case 5:
+		return flipHorizontal(rotate90(src))
+	case 6:
+		return rotate90(src)
+	case 7:
+		return flipHorizontal(rotate270(src))
+	case 8:
+		return rotate270(src)
+	}
+	return src
+}
+
+// toRGBA returns src as an RGBA image with its origin at (0, 0).
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	return dst
+}
+
+// transform builds a width x height image where each pixel is copied from the
+// source pixel returned by mapping.
+func transform(src image.Image, width, height int, mapping func(x, y int) (int, int)) *image.RGBA {
+	in := toRGBA(src)
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		for x := range width {
+			sx, sy := mapping(x, y)
+			copy(out.Pix[out.PixOffset(x, y):out.PixOffset(x, y)+4], in.Pix[in.PixOffset(sx, sy):])
+		}
+	}
+
+	return out
+}
+
+func rotate90(src image.Image) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	return transform(src, h, w, func(x, y int) (int, int) {
+		return y, h - 1 - x
+	})
+}
+
+func rotate180(src image.Image) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	return transform(src, w, h, func(x, y int) (int, int) {
+		return w - 1 - x, h - 1 - y
+	})
+}
+
+func rotate270(src image.Image) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	return transform(src, h, w, func(x, y int) (int, int) {
+		return w - 1 - y, x
+	})
+}
+
+func flipHorizontal(src image.Image) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	return transform(src, w, h, func(x, y int) (int, int) {
+		return w - 1 - x, y
+	})
+}
+
+func flipVertical(src image.Image) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	return transform(src, w, h, func(x, y int) (int, int) {
+		return x, h - 1 - y
+	})
+}
+
+// rotatedSize returns the size of the canvas holding an image of the given size rotated by angle degrees.
+func rotatedSize(size image.Point, angle float64) image.Point {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+
+	switch angle {
+	case 0, 180:
+		return size
+	case 90, 270:
+		return image.Pt(size.Y, size.X)
+	}
+
+	theta := angle * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(theta)), math.Abs(math.Cos(theta))
+	w, h := float64(size.X), float64(size.Y)
+
+	return image.Pt(
+		int(math.Ceil(w*cos+h*sin-1e-9)),
+		int(math.Ceil(w*sin+h*cos-1e-9)),
+	)
+}
+
+// rotateAngle rotates src clockwise by angle degrees around its centre using
+// bilinear sampling. Samples falling outside of src take the background colour.
+func rotateAngle(src image.Image, angle float64, background color.Color) *image.RGBA {
+	in := toRGBA(src)
+	w, h := float64(in.Rect.Dx()), float64(in.Rect.Dy())
+
+	theta := angle * math.Pi / 180
+	sin, cos := math.Sin(theta), math.Cos(theta)
+
+	size := rotatedSize(in.Rect.Size(), angle)
+	outW, outH := size.X, size.Y
+	out := image.NewRGBA(image.Rect(0, 0, outW, outH))
+
+	br, bg, bb, ba := background.RGBA()
+	bgPix := [4]float64{float64(br >> 8), float64(bg >> 8), float64(bb >> 8), float64(ba >> 8)}
+
+	sample := func(x, y int) [4]float64 {
+		if x < 0 || y < 0 || x >= in.Rect.Dx() || y >= in.Rect.Dy() {
+			return bgPix
+		}
+		i := in.PixOffset(x, y)
+		return [4]float64{float64(in.Pix[i]), float64(in.Pix[i+1]), float64(in.Pix[i+2]), float64(in.Pix[i+3])}
+	}
+
+	for y := range outH {
+		for x := range outW {
+			dx := float64(x) + 0.5 - float64(outW)/2
+			dy := float64(y) + 0.5 - float64(outH)/2
+
+			sx := dx*cos + dy*sin + w/2 - 0.5
+			sy := -dx*sin + dy*cos + h/2 - 0.5
+
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			fx, fy := sx-float64(x0), sy-float64(y0)
+
+			p00, p10 := sample(x0, y0), sample(x0+1, y0)
+			p01, p11 := sample(x0, y0+1), sample(x0+1, y0+1)
+
+			i := out.PixOffset(x, y)
+			for c := range 4 {
+				top := p00[c]*(1-fx) + p10[c]*fx
+				bottom := p01[c]*(1-fx) + p11[c]*fx
+				out.Pix[i+c] = uint8(math.Round(top*(1-fy) + bottom*fy))
+			}
+		}
+	}
+
+	return out
+}
@@ -2,18 +2,52 @@ package service
 
 import (
 	"errors"
+	"image"
 	"log"
 	"os"
 
 	"github.com/Komilov31/image-processor/internal/dto"
 	"github.com/Komilov31/image-processor/internal/model"
 	"github.com/google/uuid"
+	"golang.org/x/image/font/opentype"
 )
 
 var (
-	ErrInvalidImageFormat = errors.New("invalid image format, must be in (jpg, png, gif)")
-	ErrInvalidTask        = errors.New("invalid task, must be in(resize, watermark, miniature generating)")
-	ErrNotProcessdYet     = errors.New("image is not ready yet")
+	ErrInvalidImageFormat   = errors.New("invalid image format, must be in (jpg, png, gif, webp, bmp, tiff)")
+	ErrInvalidImage         = errors.New("invalid image, could not read image dimensions or they exceed 16384 pixels for webp output")
+	ErrInvalidTask          = errors.New("invalid task, must be in(resize, watermark, miniature generating, crop, rotate, flip, auto-orient, adjust, blur, sharpen, convolve, redact, border, padding, round-corners, canvas, caption)")
+	ErrInvalidOperations    = errors.New("invalid operations, pipeline must contain at most 20 operations and must not grow the image beyond 10000 pixels")
+	ErrInvalidResize        = errors.New("invalid resize options, width and height must be in [0, 10000] and not both zero, mode must be in (fit, fill, cover, pad, stretch), filter must be in (nearest, bilinear, bicubic, mitchell, lanczos2, lanczos3)")
+	ErrInvalidCrop          = errors.New("invalid crop options, rectangle must lie within the image, gravity must be in (center, north, south, east, west, north-east, north-west, south-east, south-west)")
+	ErrInvalidRotate        = errors.New("invalid rotate options, angle must be in [-360, 360] degrees")
+	ErrInvalidWatermark     = errors.New("invalid watermark options, mode must be in (single, tiled), spacing must be in [0, 1000], position must be in (center, north, south, east, west, north-east, north-west, south-east, south-west), margin must be in [0, 1000], color must be a valid color, opacity, font_size and scale must be in [0, 1], rotation must be in [-360, 360] degrees, overlay_id must be a valid uuid, font must be a builtin font name or a valid uuid")
+	ErrInvalidOverlay       = errors.New("invalid overlay, must be an image in (jpg, png, gif, webp, bmp, tiff)")
+	ErrNoSuchOverlay        = errors.New("there is no such overlay")
+	ErrInvalidFont          = errors.New("invalid font, must be a TrueType or OpenType font")
+	ErrNoSuchFont           = errors.New("there is no such font")
+	ErrInvalidFlip          = errors.New("invalid flip direction, must be in (horizontal, vertical, both)")
+	ErrInvalidAdjust        = errors.New("invalid adjust options, at least one must be set, brightness, contrast and saturation must be in [-1, 1], gamma must be in [0, 10], hue must be in [-180, 180] degrees, grayscale, sepia and invert must be in [0, 1]")
+	ErrInvalidBlur          = errors.New("invalid blur options, type must be in (gaussian, box), sigma of gaussian blur must be in (0, 50], radius of box blur must be in [1, 100]")
+	ErrInvalidSharpen       = errors.New("invalid sharpen options, sigma must be in [0, 50], amount must be in [0, 5], threshold must be in [0, 255]")
+	ErrInvalidConvolve      = errors.New("invalid convolve options, kernel must contain 9 or 25 values in [-1000, 1000], bias must be in [-255, 255]")
+	ErrInvalidRedact        = errors.New("invalid redact options, method must be in (pixelate, blur, fill), from 1 to 100 regions inside the image are required, block_size must be in [4, 1000] and sigma in [2, 50]")
+	ErrInvalidBorder        = errors.New("invalid border options, width must be in [1, 1000]")
+	ErrInvalidPadding       = errors.New("invalid padding options, sides must be in [0, 1000] and at least one of them set")
+	ErrInvalidRoundCorners  = errors.New("invalid round corners options, radius must be in [1, 10000]")
+	ErrInvalidCaption       = errors.New("invalid caption options, text of at most 1000 characters is required, font must be a builtin font name or a valid uuid, font_size and width must be in [0, 1], align must be in (left, center, right), line_spacing must be in [0.5, 5], position must be in (center, north, south, east, west, north-east, north-west, south-east, south-west), margin and padding must be in [0, 1000], color and background must be valid colors")
+	ErrInvalidCanvas        = errors.New("invalid canvas options, width and height must be in [1, 10000]")
+	ErrInvalidOutput        = errors.New("invalid output options, output_format must be in (jpeg, png, gif, webp, bmp, tiff), background must be a valid color, jpeg_quality must be in [1, 100], png_compression must be in (default, none, fast, best), gif_colors must be in [2, 256], gif_quantizer must be in (plan9, websafe, median-cut), color_profile must be in (srgb, preserve)")
+	ErrInvalidVariants      = errors.New("invalid variants, at most 10 variants with unique names matching [a-z0-9_-]{1,32} are allowed")
+	ErrInvalidSimilarSearch = errors.New("invalid similarity search, max_distance must be in [0, 64]")
+	ErrNoSuchVariant        = errors.New("there is no such variant of the image")
+	ErrNotProcessdYet       = errors.New("image is not ready yet")
+	ErrProcessingFailed     = errors.New("image processing failed")
+)
+
+const (
+	statusInProgress = "in progress"
+	statusFinished   = "finished"
+	statusFailed     = "failed"
 )
 
 var (
@@ -26,6 +60,12 @@ type Storage interface {
 	GetImageInfo(uuid.UUID) (*model.Image, error)
 	DeleteImage(uuid.UUID) error
 	UpdateImageStatus(uuid.UUID, string) error
+	UpdateVariantStatus(uuid.UUID, string, string) error
+	UpdateImageMetadata(uuid.UUID, *model.Metadata) error
+	UpdateProcessedFile(uuid.UUID, model.File) error
+	UpdateVariantFile(uuid.UUID, string, model.File) error
+	UpdateImageHashes(uuid.UUID, model.Hashes) error
+	FindSimilarImages(model.Hashes, int, uuid.UUID) ([]model.SimilarImage, error)
 }
 
 type FileStorage interface {
@@ -43,9 +83,18 @@ type Service struct {
 	storage     Storage
 	fileStorage FileStorage
 	queue       Queue
+	defaults    dto.Output
+	overlays    cache[uuid.UUID, image.Image]
+	fonts       cache[string, *opentype.Font]
 }
 
-func New(storage Storage, fileStorage FileStorage, queue Queue) *Service {
+// New creates the service. The defaults are the encoder options applied to
+// jobs that do not set them.
+func New(storage Storage, fileStorage FileStorage, queue Queue, defaults dto.Output) *Service {
+	if err := validateOutput(defaults); err != nil {
+		log.Fatal("invalid default encoder options: ", err)
+	}
+
 	name, err := os.MkdirTemp(".", "images")
 	if err != nil {
 		log.Fatal("could not create temporary directory to store images: ", err)
@@ -63,5 +112,6 @@ func New(storage Storage, fileStorage FileStorage, queue Queue) *Service {
 		storage:     storage,
 		fileStorage: fileStorage,
 		queue:       queue,
+		defaults:    defaults,
 	}
 }
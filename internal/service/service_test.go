@@ -1,23 +1,58 @@
 package service
 
 import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"image"
 	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"math/bits"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/Komilov31/image-processor/internal/dto"
 	"github.com/Komilov31/image-processor/internal/model"
+	webpenc "github.com/Komilov31/image-processor/internal/webp"
 	"github.com/google/uuid"
+	res "github.com/nfnt/resize"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
 )
 
+var updateGolden = flag.Bool("update", false, "update the golden images in testdata")
+
 type mockStorage struct {
-	createImageFunc       func(model.Image) error
-	getImageInfoFunc      func(uuid.UUID) (*model.Image, error)
-	deleteImageFunc       func(uuid.UUID) error
-	updateImageStatusFunc func(uuid.UUID, string) error
+	createImageFunc         func(model.Image) error
+	getImageInfoFunc        func(uuid.UUID) (*model.Image, error)
+	deleteImageFunc         func(uuid.UUID) error
+	updateImageStatusFunc   func(uuid.UUID, string) error
+	updateVariantStatusFunc func(uuid.UUID, string, string) error
+	updateImageMetadataFunc func(uuid.UUID, *model.Metadata) error
+	updateProcessedFileFunc func(uuid.UUID, model.File) error
+	updateVariantFileFunc   func(uuid.UUID, string, model.File) error
+	updateImageHashesFunc   func(uuid.UUID, model.Hashes) error
+	findSimilarImagesFunc   func(model.Hashes, int, uuid.UUID) ([]model.SimilarImage, error)
 }
 
 func (m *mockStorage) CreateImage(img model.Image) error {
@@ -48,6 +83,48 @@ func (m *mockStorage) UpdateImageStatus(id uuid.UUID, status string) error {
 	return nil
 }
 
+func (m *mockStorage) UpdateVariantStatus(id uuid.UUID, name, status string) error {
+	if m.updateVariantStatusFunc != nil {
+		return m.updateVariantStatusFunc(id, name, status)
+	}
+	return nil
+}
+
+func (m *mockStorage) UpdateImageMetadata(id uuid.UUID, metadata *model.Metadata) error {
+	if m.updateImageMetadataFunc != nil {
+		return m.updateImageMetadataFunc(id, metadata)
+	}
+	return nil
+}
+
+func (m *mockStorage) UpdateProcessedFile(id uuid.UUID, file model.File) error {
+	if m.updateProcessedFileFunc != nil {
+		return m.updateProcessedFileFunc(id, file)
+	}
+	return nil
+}
+
+func (m *mockStorage) UpdateVariantFile(id uuid.UUID, name string, file model.File) error {
+	if m.updateVariantFileFunc != nil {
+		return m.updateVariantFileFunc(id, name, file)
+	}
+	return nil
+}
+
+func (m *mockStorage) UpdateImageHashes(id uuid.UUID, hashes model.Hashes) error {
+	if m.updateImageHashesFunc != nil {
+		return m.updateImageHashesFunc(id, hashes)
+	}
+	return nil
+}
+
+func (m *mockStorage) FindSimilarImages(hashes model.Hashes, maxDistance int, exclude uuid.UUID) ([]model.SimilarImage, error) {
+	if m.findSimilarImagesFunc != nil {
+		return m.findSimilarImagesFunc(hashes, maxDistance, exclude)
+	}
+	return nil, nil
+}
+
 type mockFileStorage struct {
 	saveImageFunc    func(string, string, string) error
 	getImageFunc     func(string, string, string) error
@@ -137,13 +214,12 @@ func createTestImageData() dto.Message {
 		ID:          uuid.New(),
 		FileName:    "test.jpg",
 		ContentType: "image/jpeg",
-		Task:        "resize",
-		Resize: struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		}{
-			Width:  100,
-			Height: 100,
+		Operation: dto.Operation{
+			Task: "resize",
+			Resize: dto.Resize{
+				Width:  100,
+				Height: 100,
+			},
 		},
 	}
 }
@@ -165,12 +241,13 @@ func TestNew(t *testing.T) {
 		mockFileStorage := &mockFileStorage{}
 		mockQueue := &mockQueue{}
 
-		service := New(mockStorage, mockFileStorage, mockQueue)
+		service := New(mockStorage, mockFileStorage, mockQueue, dto.Output{JPEGQuality: 90})
 
 		assert.NotNil(t, service)
 		assert.Equal(t, mockStorage, service.storage)
 		assert.Equal(t, mockFileStorage, service.fileStorage)
 		assert.Equal(t, mockQueue, service.queue)
+		assert.Equal(t, dto.Output{JPEGQuality: 90}, service.defaults)
 	})
 }
 
@@ -182,7 +259,7 @@ func TestService_CreateImage(t *testing.T) {
 		defer cleanupTestDirs()
 
 		imageData := createTestImageData()
-		testData := []byte("fake image data")
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
 		mockQueue.produceMessageFunc = func(msg dto.Message) error {
 			return nil
 		}
@@ -190,21 +267,140 @@ func TestService_CreateImage(t *testing.T) {
 			return nil
 		}
 
-		id, err := service.CreateImage(testData, imageData)
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
 
 		assert.NoError(t, err)
 		assert.NotNil(t, id)
 	})
 
+	t.Run("applies default encoder options", func(t *testing.T) {
+		service, _, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		service.defaults = dto.Output{JPEGQuality: 85, GIFColors: 64, GIFQuantizer: QuantizerMedianCut}
+
+		imageData := createTestImageData()
+		imageData.Output = dto.Output{JPEGQuality: 40}
+
+		var produced dto.Message
+		mockQueue.produceMessageFunc = func(msg dto.Message) error {
+			produced = msg
+			return nil
+		}
+
+		_, err := service.CreateImage(encodeTestJPEG(t, createSolidImage(100, 100, color.White)), "test.jpg", imageData)
+
+		assert.NoError(t, err)
+		assert.Equal(t, dto.Output{JPEGQuality: 40, GIFColors: 64, GIFQuantizer: QuantizerMedianCut}, produced.Output)
+	})
+
+	t.Run("records the original file", func(t *testing.T) {
+		service, mockStorage, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		data := encodeTestPNG(t, createSolidImage(40, 30, color.White))
+		imageData := createTestImageData()
+		imageData.ContentType = "image/png"
+
+		var created model.Image
+		mockStorage.createImageFunc = func(img model.Image) error {
+			created = img
+			return nil
+		}
+		mockQueue.produceMessageFunc = func(msg dto.Message) error {
+			return nil
+		}
+
+		_, err := service.CreateImage(data, "holiday photo.png", imageData)
+
+		assert.NoError(t, err)
+		digest := sha256.Sum256(data)
+		assert.Equal(t, &model.File{
+			Name:     "holiday photo.png",
+			MIMEType: "image/png",
+			Width:    40,
+			Height:   30,
+			Size:     int64(len(data)),
+			SHA256:   hex.EncodeToString(digest[:]),
+		}, created.Original)
+	})
+
+	t.Run("records colour space", func(t *testing.T) {
+		service, mockStorage, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		data := encodeTestPNG(t, createSolidImage(4, 4, color.White))
+		data = embedMetadata("png", data, metadataBlocks{icc: testICCProfile(p3TestPrimaries, srgbTestCurve(), "Display P3")})
+
+		imageData := createTestImageData()
+		imageData.ContentType = "image/png"
+
+		var created model.Image
+		mockStorage.createImageFunc = func(img model.Image) error {
+			created = img
+			return nil
+		}
+		mockQueue.produceMessageFunc = func(msg dto.Message) error {
+			return nil
+		}
+
+		_, err := service.CreateImage(data, "test.jpg", imageData)
+
+		assert.NoError(t, err)
+		assert.Equal(t, ColorSpaceP3, created.ColorSpace)
+	})
+
+	t.Run("unknown overlay", func(t *testing.T) {
+		service, _, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		mockFileStorage.getImageFunc = func(string, string, string) error {
+			return errors.New("object does not exist")
+		}
+		mockQueue.produceMessageFunc = func(dto.Message) error {
+			t.Fatal("job with unknown overlay must not be queued")
+			return nil
+		}
+
+		imageData := createTestImageData()
+		imageData.Operation = dto.Operation{Task: Watermark, Watermark: dto.Watermark{OverlayID: uuid.NewString()}}
+
+		id, err := service.CreateImage(encodeTestJPEG(t, createSolidImage(100, 100, color.White)), "test.jpg", imageData)
+
+		assert.ErrorIs(t, err, ErrNoSuchOverlay)
+		assert.Nil(t, id)
+	})
+
+	t.Run("unknown caption font", func(t *testing.T) {
+		service, _, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		mockFileStorage.getImageFunc = func(string, string, string) error {
+			return errors.New("object does not exist")
+		}
+		mockQueue.produceMessageFunc = func(dto.Message) error {
+			t.Fatal("job with unknown font must not be queued")
+			return nil
+		}
+
+		imageData := createTestImageData()
+		imageData.Operation = dto.Operation{Task: Caption, Caption: dto.Caption{Text: "Hello", Font: uuid.NewString()}}
+
+		id, err := service.CreateImage(encodeTestJPEG(t, createSolidImage(100, 100, color.White)), "test.jpg", imageData)
+
+		assert.ErrorIs(t, err, ErrNoSuchFont)
+		assert.Nil(t, id)
+	})
+
 	t.Run("invalid format", func(t *testing.T) {
 		service, _, _, _ := createTestService()
 		defer cleanupTestDirs()
 
 		imageData := createTestImageData()
 		imageData.ContentType = "invalid/type"
-		testData := []byte("fake image data")
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
 
-		id, err := service.CreateImage(testData, imageData)
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
 
 		assert.Error(t, err)
 		assert.Equal(t, ErrInvalidImageFormat, err)
@@ -217,33 +413,171 @@ func TestService_CreateImage(t *testing.T) {
 
 		imageData := createTestImageData()
 		imageData.Task = "invalid_task"
-		testData := []byte("fake image data")
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
 
-		id, err := service.CreateImage(testData, imageData)
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
 
 		assert.Error(t, err)
 		assert.Equal(t, ErrInvalidTask, err)
 		assert.Nil(t, id)
 	})
 
+	t.Run("invalid resize options", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		imageData := createTestImageData()
+		imageData.Resize.Mode = "squash"
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
+
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
+
+		assert.Error(t, err)
+		assert.Equal(t, ErrInvalidResize, err)
+		assert.Nil(t, id)
+	})
+
+	t.Run("crop within image", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		imageData := createTestImageData()
+		imageData.ContentType = "image/png"
+		imageData.Task = Crop
+		imageData.Crop = dto.Crop{X: 10, Y: 10, Width: 50, Height: 50}
+		testData := encodeTestPNG(t, createSolidImage(100, 100, color.White))
+
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
+
+		assert.NoError(t, err)
+		assert.NotNil(t, id)
+	})
+
+	t.Run("crop outside image", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		imageData := createTestImageData()
+		imageData.ContentType = "image/png"
+		imageData.Task = Crop
+		imageData.Crop = dto.Crop{X: 60, Y: 0, Width: 50, Height: 50}
+		testData := encodeTestPNG(t, createSolidImage(100, 100, color.White))
+
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
+
+		assert.Error(t, err)
+		assert.Equal(t, ErrInvalidCrop, err)
+		assert.Nil(t, id)
+	})
+
+	t.Run("unreadable image", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		imageData := createTestImageData()
+		testData := []byte("fake image data")
+
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
+
+		assert.Error(t, err)
+		assert.Equal(t, ErrInvalidImage, err)
+		assert.Nil(t, id)
+	})
+
+	t.Run("creation with variants", func(t *testing.T) {
+		service, mockStorage, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		imageData := createTestImageData()
+		imageData.Variants = []dto.Variant{
+			{Name: "thumbnail", Operations: []dto.Operation{{Task: Thumbnail}}},
+			{Name: "medium", Output: dto.Output{Format: "webp"}, Operations: []dto.Operation{{Task: Resize, Resize: dto.Resize{Width: 800}}}},
+		}
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
+
+		var created model.Image
+		mockStorage.createImageFunc = func(img model.Image) error {
+			created = img
+			return nil
+		}
+
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
+
+		assert.NoError(t, err)
+		assert.NotNil(t, id)
+		assert.Equal(t, "jpeg", created.OutputFormat)
+		assert.Equal(t, []model.Variant{
+			{Name: "thumbnail", Format: "jpeg", Status: "in progress"},
+			{Name: "medium", Format: "webp", Status: "in progress"},
+		}, created.Variants)
+	})
+
+	t.Run("invalid variant pipeline", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		imageData := createTestImageData()
+		imageData.Variants = []dto.Variant{
+			{Name: "medium", Operations: []dto.Operation{{Task: Resize}}},
+		}
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
+
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
+
+		assert.Equal(t, ErrInvalidResize, err)
+		assert.Nil(t, id)
+	})
+
 	t.Run("queue error", func(t *testing.T) {
 		service, mockStorage, _, mockQueue := createTestService()
 		defer cleanupTestDirs()
 
 		imageData := createTestImageData()
-		testData := []byte("fake image data")
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
 
+		var created uuid.UUID
 		mockQueue.produceMessageFunc = func(msg dto.Message) error {
 			return errors.New("queue error")
 		}
 		mockStorage.createImageFunc = func(img model.Image) error {
+			created = img.ID
+			return nil
+		}
+
+		var status string
+		mockStorage.updateImageStatusFunc = func(id uuid.UUID, s string) error {
+			assert.Equal(t, created, id)
+			status = s
 			return nil
 		}
 
-		id, err := service.CreateImage(testData, imageData)
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
 
 		assert.Error(t, err)
 		assert.Nil(t, id)
+		assert.Equal(t, statusFailed, status)
+	})
+
+	t.Run("stores the image before queueing it", func(t *testing.T) {
+		service, mockStorage, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		imageData := createTestImageData()
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
+
+		var created bool
+		mockStorage.createImageFunc = func(img model.Image) error {
+			created = true
+			return nil
+		}
+		mockQueue.produceMessageFunc = func(msg dto.Message) error {
+			assert.True(t, created, "the worker must find the image in the db")
+			return nil
+		}
+
+		_, err := service.CreateImage(testData, "test.jpg", imageData)
+
+		assert.NoError(t, err)
 	})
 
 	t.Run("storage error", func(t *testing.T) {
@@ -251,22 +585,33 @@ func TestService_CreateImage(t *testing.T) {
 		defer cleanupTestDirs()
 
 		imageData := createTestImageData()
-		testData := []byte("fake image data")
+		testData := encodeTestJPEG(t, createSolidImage(100, 100, color.White))
 
 		mockQueue.produceMessageFunc = func(msg dto.Message) error {
+			t.Error("image must not be queued when it could not be stored")
 			return nil
 		}
 		mockStorage.createImageFunc = func(img model.Image) error {
 			return errors.New("storage error")
 		}
 
-		id, err := service.CreateImage(testData, imageData)
+		id, err := service.CreateImage(testData, "test.jpg", imageData)
 
 		assert.Error(t, err)
 		assert.Nil(t, id)
 	})
 }
 
+func TestDescribeFile(t *testing.T) {
+	file := describeFile([]byte("fake image data"), "jpeg")
+
+	assert.Equal(t, model.File{
+		MIMEType: "image/jpeg",
+		Size:     15,
+		SHA256:   "5b3397652358a6663a0225ee76466d4e4fd6c58d484d1aa25170bb617d6bb086",
+	}, file)
+}
+
 func TestService_GetImageStatus(t *testing.T) {
 	t.Run("successful get status", func(t *testing.T) {
 		service, mockStorage, _, _ := createTestService()
@@ -327,7 +672,7 @@ func TestService_GetImageById(t *testing.T) {
 			return nil
 		}
 
-		result, err := service.GetImageById(testID)
+		result, err := service.GetImageById(testID, "")
 
 		assert.NoError(t, err)
 		assert.NotEmpty(t, result)
@@ -348,7 +693,7 @@ func TestService_GetImageById(t *testing.T) {
 			return expectedImage, nil
 		}
 
-		result, err := service.GetImageById(testID)
+		result, err := service.GetImageById(testID, "")
 
 		assert.Error(t, err)
 		assert.Equal(t, ErrNotProcessdYet, err)
@@ -365,160 +710,4208 @@ func TestService_GetImageById(t *testing.T) {
 			return nil, errors.New("storage error")
 		}
 
-		result, err := service.GetImageById(testID)
+		result, err := service.GetImageById(testID, "")
 
 		assert.Error(t, err)
 		assert.Empty(t, result)
 	})
 }
 
-func TestService_DeleteImage(t *testing.T) {
-	t.Run("successful deletion", func(t *testing.T) {
+func TestService_GetImageById_Variants(t *testing.T) {
+	testID := uuid.New()
+	imageInfo := &model.Image{
+		ID:     testID,
+		Format: "png",
+		Status: "in progress",
+		Variants: []model.Variant{
+			{Name: "thumb", Status: "finished"},
+			{Name: "medium", Status: "in progress"},
+			{Name: "large", Status: "failed"},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		variant          string
+		expectedFileName string
+		expectedErr      error
+	}{
+		{"requested variant", "thumb", testID.String() + "_thumb.png", nil},
+		{"first variant by default", "", testID.String() + "_thumb.png", nil},
+		{"variant in progress", "medium", "", ErrNotProcessdYet},
+		{"failed variant", "large", "", ErrProcessingFailed},
+		{"unknown variant", "huge", "", ErrNoSuchVariant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStorage, mockFileStorage, _ := createTestService()
+			defer cleanupTestDirs()
+
+			var requested string
+			mockStorage.getImageInfoFunc = func(id uuid.UUID) (*model.Image, error) {
+				return imageInfo, nil
+			}
+			mockFileStorage.getImageFunc = func(fileName, filePath, storageType string) error {
+				requested = fileName
+				return nil
+			}
+
+			result, err := service.GetImageById(testID, tt.variant)
+
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedFileName, requested)
+			if tt.expectedErr == nil {
+				assert.Equal(t, processedDirName+"/"+tt.expectedFileName, result)
+			}
+		})
+	}
+
+	t.Run("converted outputs", func(t *testing.T) {
 		service, mockStorage, mockFileStorage, _ := createTestService()
 		defer cleanupTestDirs()
 
-		testID := uuid.New()
-
-		mockStorage.deleteImageFunc = func(id uuid.UUID) error {
-			return nil
+		var requested []string
+		mockStorage.getImageInfoFunc = func(id uuid.UUID) (*model.Image, error) {
+			return &model.Image{
+				ID:           id,
+				Format:       "png",
+				OutputFormat: "jpeg",
+				Status:       "finished",
+				Variants:     []model.Variant{{Name: "thumb", Format: "webp", Status: "finished"}},
+			}, nil
 		}
-		mockFileStorage.deleteImagesFunc = func(storageType string, fileNames ...string) error {
+		mockFileStorage.getImageFunc = func(fileName, filePath, storageType string) error {
+			requested = append(requested, fileName)
 			return nil
 		}
 
-		err := service.DeleteImage(testID)
+		_, err := service.GetImageById(testID, "thumb")
+		assert.NoError(t, err)
 
+		mockStorage.getImageInfoFunc = func(id uuid.UUID) (*model.Image, error) {
+			return &model.Image{ID: id, Format: "png", OutputFormat: "jpeg", Status: "finished"}, nil
+		}
+		_, err = service.GetImageById(testID, "")
 		assert.NoError(t, err)
+
+		assert.Equal(t, []string{testID.String() + "_thumb.webp", testID.String() + ".jpeg"}, requested)
 	})
 
-	t.Run("storage deletion error", func(t *testing.T) {
+	t.Run("variant of image without variants", func(t *testing.T) {
 		service, mockStorage, _ := createTestServiceWithoutFileStorage()
 		defer cleanupTestDirs()
 
-		testID := uuid.New()
-
-		mockStorage.deleteImageFunc = func(id uuid.UUID) error {
-			return errors.New("storage error")
+		mockStorage.getImageInfoFunc = func(id uuid.UUID) (*model.Image, error) {
+			return &model.Image{ID: id, Format: "png", Status: "finished"}, nil
 		}
 
-		err := service.DeleteImage(testID)
+		result, err := service.GetImageById(testID, "thumb")
 
-		assert.Error(t, err)
+		assert.Equal(t, ErrNoSuchVariant, err)
+		assert.Empty(t, result)
 	})
 }
 
-func TestIsCorrectFormat(t *testing.T) {
-	tests := []struct {
-		format   string
-		expected bool
-	}{
-		{"jpeg", true},
-		{"jpg", true},
-		{"png", true},
-		{"gif", true},
-		{"bmp", false},
-		{"tiff", false},
-		{"", false},
-	}
+func TestService_handleMessage(t *testing.T) {
+	t.Run("stores every variant", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
 
-	for _, tt := range tests {
-		t.Run(tt.format, func(t *testing.T) {
-			result := isCorrectFormat(tt.format)
-			assert.Equal(t, tt.expected, result)
-		})
-	}
-}
+		testID := uuid.New()
+		src := createSolidImage(100, 50, color.White)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".png", encodeTestPNG(t, src), 0666))
 
-func TestParseFormat(t *testing.T) {
-	tests := []struct {
-		contentType string
-		expected    string
-		expectError bool
-	}{
-		{"image/jpeg", "jpeg", false},
+		message := &dto.Message{
+			ID:          testID,
+			FileName:    testID.String() + ".png",
+			ContentType: "image/png",
+			Variants: []dto.Variant{
+				{Name: "small", Operations: []dto.Operation{{Task: Resize, Resize: dto.Resize{Width: 10}}}},
+				{Name: "broken", Operations: []dto.Operation{{Task: Crop, Crop: dto.Crop{Width: 500, Height: 500}}}},
+				{Name: "original"},
+			},
+		}
+
+		saved := make(map[string]image.Point)
+		statuses := make(map[string]string)
+		files := make(map[string]model.File)
+		var imageStatus string
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return message, nil
+		}
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			assert.Equal(t, "processed", storageType)
+			config, _, err := image.DecodeConfig(mustOpen(t, filePath))
+			assert.NoError(t, err)
+			saved[fileName] = image.Pt(config.Width, config.Height)
+			return nil
+		}
+		mockStorage.updateVariantStatusFunc = func(id uuid.UUID, name, status string) error {
+			statuses[name] = status
+			return nil
+		}
+		mockStorage.updateVariantFileFunc = func(id uuid.UUID, name string, file model.File) error {
+			files[name] = model.File{MIMEType: file.MIMEType, Width: file.Width, Height: file.Height}
+			return nil
+		}
+		mockStorage.updateImageStatusFunc = func(id uuid.UUID, status string) error {
+			imageStatus = status
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.ErrorContains(t, err, "broken")
+		assert.Equal(t, map[string]model.File{
+			"small":    {MIMEType: "image/png", Width: 10, Height: 5},
+			"original": {MIMEType: "image/png", Width: 100, Height: 50},
+		}, files)
+		assert.Equal(t, map[string]image.Point{
+			testID.String() + "_small.png":    image.Pt(10, 5),
+			testID.String() + "_original.png": image.Pt(100, 50),
+		}, saved)
+		assert.Equal(t, map[string]string{
+			"small":    "finished",
+			"broken":   "failed",
+			"original": "finished",
+		}, statuses)
+		assert.Equal(t, "finished", imageStatus)
+		assert.NoFileExists(t, originDirName+"/"+testID.String()+".png")
+	})
+
+	t.Run("single output", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		src := createSolidImage(100, 50, color.White)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".png", encodeTestPNG(t, src), 0666))
+
+		message := &dto.Message{
+			ID:          testID,
+			FileName:    testID.String() + ".png",
+			ContentType: "image/png",
+			Operation:   dto.Operation{Task: Flip, Flip: FlipVertical},
+		}
+
+		var savedFileName string
+		var savedData []byte
+		var processed model.File
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return message, nil
+		}
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			savedFileName = fileName
+			data, err := os.ReadFile(filePath)
+			assert.NoError(t, err)
+			savedData = data
+			return nil
+		}
+		mockStorage.updateVariantStatusFunc = func(id uuid.UUID, name, status string) error {
+			t.Errorf("unexpected variant status update for %q", name)
+			return nil
+		}
+		mockStorage.updateVariantFileFunc = func(id uuid.UUID, name string, file model.File) error {
+			t.Errorf("unexpected variant file update for %q", name)
+			return nil
+		}
+		mockStorage.updateProcessedFileFunc = func(id uuid.UUID, file model.File) error {
+			assert.Equal(t, testID, id)
+			processed = file
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.NoError(t, err)
+		assert.Equal(t, message.FileName, savedFileName)
+
+		digest := sha256.Sum256(savedData)
+		assert.Equal(t, model.File{
+			MIMEType: "image/png",
+			Width:    100,
+			Height:   50,
+			Size:     int64(len(savedData)),
+			SHA256:   hex.EncodeToString(digest[:]),
+		}, processed)
+	})
+
+	t.Run("failed original marks the image failed", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		oPath := originDirName + "/" + testID.String() + ".png"
+		assert.NoError(t, os.WriteFile(oPath, []byte("fake image data"), 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".png",
+				ContentType: "image/png",
+				Operation:   dto.Operation{Task: Flip, Flip: FlipVertical},
+			}, nil
+		}
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			t.Error("unexpected upload of a failed image")
+			return nil
+		}
+		var statuses []string
+		mockStorage.updateImageStatusFunc = func(id uuid.UUID, status string) error {
+			assert.Equal(t, testID, id)
+			statuses = append(statuses, status)
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.ErrorContains(t, err, "could not process image")
+		assert.Equal(t, []string{statusFailed}, statuses)
+		assert.NoFileExists(t, oPath)
+	})
+
+	t.Run("upload error marks the image failed", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		oPath := originDirName + "/" + testID.String() + ".png"
+		assert.NoError(t, os.WriteFile(oPath, encodeTestPNG(t, createSolidImage(20, 10, color.White)), 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".png",
+				ContentType: "image/png",
+				Operation:   dto.Operation{Task: Flip, Flip: FlipVertical},
+			}, nil
+		}
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			return errors.New("object storage error")
+		}
+		var statuses []string
+		mockStorage.updateImageStatusFunc = func(id uuid.UUID, status string) error {
+			statuses = append(statuses, status)
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.ErrorContains(t, err, "object storage error")
+		assert.Equal(t, []string{statusFailed}, statuses)
+		assert.NoFileExists(t, oPath)
+	})
+
+	t.Run("stores metadata of the original", func(t *testing.T) {
+		service, mockStorage, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		data := withEXIFOrientation(encodeTestJPEG(t, createSolidImage(20, 10, color.White)), 6)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".jpeg", data, 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".jpeg",
+				ContentType: "image/jpeg",
+				Operation:   dto.Operation{Task: AutoOrient},
+			}, nil
+		}
+
+		var stored *model.Metadata
+		mockStorage.updateImageMetadataFunc = func(id uuid.UUID, metadata *model.Metadata) error {
+			assert.Equal(t, testID, id)
+			stored = metadata
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.NoError(t, err)
+		assert.Equal(t, &model.Metadata{EXIF: &model.EXIF{Orientation: 6}}, stored)
+	})
+
+	t.Run("stores hashes of the original", func(t *testing.T) {
+		service, mockStorage, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		src := smartScene(80, 60, image.Rect(10, 10, 40, 40))
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".png", encodeTestPNG(t, src), 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".png",
+				ContentType: "image/png",
+				Operation:   dto.Operation{Task: Flip, Flip: FlipHorizontal},
+			}, nil
+		}
+
+		var stored model.Hashes
+		mockStorage.updateImageHashesFunc = func(id uuid.UUID, hashes model.Hashes) error {
+			assert.Equal(t, testID, id)
+			stored = hashes
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.NoError(t, err)
+		assert.Equal(t, imageHashes(src), stored)
+	})
+
+	t.Run("hashes match the upload search", func(t *testing.T) {
+		service, mockStorage, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		data := withEXIFOrientation(encodeTestJPEG(t, smartScene(80, 60, image.Rect(10, 10, 40, 40))), 6)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".jpeg", data, 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".jpeg",
+				ContentType: "image/jpeg",
+				Operation:   dto.Operation{Task: Flip, Flip: FlipHorizontal},
+			}, nil
+		}
+
+		var stored model.Hashes
+		mockStorage.updateImageHashesFunc = func(id uuid.UUID, hashes model.Hashes) error {
+			stored = hashes
+			return nil
+		}
+
+		assert.NoError(t, service.handleMessage())
+
+		expected, err := hashData(data, "jpeg")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, stored)
+	})
+
+	t.Run("hash storage error", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		src := createSolidImage(20, 10, color.White)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".png", encodeTestPNG(t, src), 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".png",
+				ContentType: "image/png",
+				Operation:   dto.Operation{Task: Flip, Flip: FlipVertical},
+			}, nil
+		}
+		mockStorage.updateImageHashesFunc = func(id uuid.UUID, hashes model.Hashes) error {
+			return errors.New("storage error")
+		}
+		var saved bool
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			saved = true
+			return nil
+		}
+		var status string
+		mockStorage.updateImageStatusFunc = func(id uuid.UUID, s string) error {
+			status = s
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.NoError(t, err)
+		assert.True(t, saved)
+		assert.Equal(t, statusFinished, status)
+	})
+
+	t.Run("metadata storage error", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		data := withEXIFOrientation(encodeTestJPEG(t, createSolidImage(20, 10, color.White)), 6)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".jpeg", data, 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".jpeg",
+				ContentType: "image/jpeg",
+				Operation:   dto.Operation{Task: AutoOrient},
+			}, nil
+		}
+		mockStorage.updateImageMetadataFunc = func(id uuid.UUID, metadata *model.Metadata) error {
+			return errors.New("storage error")
+		}
+		var saved bool
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			saved = true
+			return nil
+		}
+		var status string
+		mockStorage.updateImageStatusFunc = func(id uuid.UUID, s string) error {
+			status = s
+			return nil
+		}
+
+		err := service.handleMessage()
+
+		assert.NoError(t, err)
+		assert.True(t, saved)
+		assert.Equal(t, statusFinished, status)
+	})
+
+	t.Run("images without metadata", func(t *testing.T) {
+		service, mockStorage, _, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		src := createSolidImage(20, 10, color.White)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".png", encodeTestPNG(t, src), 0666))
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".png",
+				ContentType: "image/png",
+				Operation:   dto.Operation{Task: Flip, Flip: FlipVertical},
+			}, nil
+		}
+		mockStorage.updateImageMetadataFunc = func(id uuid.UUID, metadata *model.Metadata) error {
+			t.Error("unexpected metadata update")
+			return nil
+		}
+
+		assert.NoError(t, service.handleMessage())
+	})
+
+	t.Run("defaults without strip switch strip the metadata", func(t *testing.T) {
+		service, _, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		// A deployment whose encoder config has no strip_metadata key.
+		service.defaults = dto.Output{JPEGQuality: 85}
+
+		data := embedMetadata("jpeg", encodeTestJPEG(t, createSolidImage(20, 10, color.White)), privateTestBlocks())
+		imageData := dto.Message{ContentType: "image/jpeg", Operation: dto.Operation{Task: Flip, Flip: FlipVertical}}
+
+		var produced dto.Message
+		mockQueue.produceMessageFunc = func(msg dto.Message) error {
+			produced = msg
+			return nil
+		}
+
+		_, err := service.CreateImage(data, "photo.jpg", imageData)
+		assert.NoError(t, err)
+		assert.Nil(t, produced.Output.StripMetadata)
+
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &produced, nil
+		}
+
+		var stored *model.Metadata
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			data, err := os.ReadFile(filePath)
+			assert.NoError(t, err)
+			stored = extractMetadata(data, "jpeg")
+			return nil
+		}
+
+		assert.NoError(t, service.handleMessage())
+
+		if assert.NotNil(t, stored) && assert.NotNil(t, stored.EXIF) {
+			assert.Nil(t, stored.EXIF.GPS)
+		}
+	})
+
+	t.Run("processed images keep no location", func(t *testing.T) {
+		service, _, mockFileStorage, mockQueue := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		blocks := privateTestBlocks()
+		data := embedMetadata("jpeg", encodeTestJPEG(t, createSolidImage(20, 10, color.White)), blocks)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".jpeg", data, 0666))
+
+		keep := false
+		mockQueue.consumeMessageFunc = func() (*dto.Message, error) {
+			return &dto.Message{
+				ID:          testID,
+				FileName:    testID.String() + ".jpeg",
+				ContentType: "image/jpeg",
+				Variants: []dto.Variant{
+					{Name: "photo"},
+					{Name: "lossless", Output: dto.Output{Format: "png"}},
+					{Name: "web", Output: dto.Output{Format: "webp"}},
+					{Name: "full", Output: dto.Output{StripMetadata: &keep}},
+				},
+			}, nil
+		}
+
+		stored := make(map[string]*model.Metadata)
+		mockFileStorage.saveImageFunc = func(fileName, filePath, storageType string) error {
+			assert.Equal(t, "processed", storageType)
+			data, err := os.ReadFile(filePath)
+			assert.NoError(t, err)
+			stored[fileName] = extractMetadata(data, strings.TrimPrefix(filepath.Ext(fileName), "."))
+			return nil
+		}
+
+		assert.NoError(t, service.handleMessage())
+
+		for _, name := range []string{"photo.jpeg", "lossless.png", "web.webp"} {
+			metadata := stored[testID.String()+"_"+name]
+			if assert.NotNil(t, metadata, name) {
+				assert.Equal(t, &model.EXIF{Copyright: "© Jane Roe"}, metadata.EXIF, name)
+				assert.Equal(t, map[string][]string{"dc:rights": {"© Jane Roe & Co"}}, metadata.XMP, name)
+			}
+		}
+
+		full := stored[testID.String()+"_full.jpeg"]
+		if assert.NotNil(t, full) && assert.NotNil(t, full.EXIF) {
+			assert.NotNil(t, full.EXIF.GPS)
+			assert.Equal(t, 1, full.EXIF.Orientation)
+		}
+	})
+}
+
+func TestService_GetImageMetadata(t *testing.T) {
+	testID := uuid.New()
+	metadata := &model.Metadata{EXIF: &model.EXIF{Make: "Canon"}}
+
+	tests := []struct {
+		name     string
+		image    *model.Image
+		err      error
+		expected *model.Metadata
+		wantErr  error
+	}{
+		{"stored metadata", &model.Image{ID: testID, Status: statusFinished, Metadata: metadata}, nil, metadata, nil},
+		{"stored while in progress", &model.Image{ID: testID, Status: statusInProgress, Metadata: metadata}, nil, metadata, nil},
+		{"not extracted yet", &model.Image{ID: testID, Status: statusInProgress}, nil, nil, ErrNotProcessdYet},
+		{"no metadata", &model.Image{ID: testID, Status: statusFinished}, nil, &model.Metadata{}, nil},
+		{"storage error", nil, errors.New("storage error"), nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStorage, _, _ := createTestService()
+			defer cleanupTestDirs()
+
+			mockStorage.getImageInfoFunc = func(id uuid.UUID) (*model.Image, error) {
+				return tt.image, tt.err
+			}
+
+			result, err := service.GetImageMetadata(testID)
+
+			switch {
+			case tt.err != nil:
+				assert.Equal(t, tt.err, err)
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestService_GetSimilarImages(t *testing.T) {
+	testID := uuid.New()
+	hashes := &model.Hashes{AHash: 1, DHash: 2, PHash: 3}
+	similar := []model.SimilarImage{{ID: uuid.New(), Distance: 2}}
+
+	tests := []struct {
+		name        string
+		image       *model.Image
+		maxDistance int
+		expected    []model.SimilarImage
+		wantErr     error
+	}{
+		{"hashed image", &model.Image{ID: testID, Status: statusFinished, Hashes: hashes}, 5, similar, nil},
+		{"not hashed yet", &model.Image{ID: testID, Status: statusInProgress}, 5, nil, ErrNotProcessdYet},
+		{"image without hashes", &model.Image{ID: testID, Status: statusFinished}, 5, []model.SimilarImage{}, nil},
+		{"negative distance", &model.Image{ID: testID, Status: statusFinished, Hashes: hashes}, -1, nil, ErrInvalidSimilarSearch},
+		{"distance too large", &model.Image{ID: testID, Status: statusFinished, Hashes: hashes}, 65, nil, ErrInvalidSimilarSearch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStorage, _, _ := createTestService()
+			defer cleanupTestDirs()
+
+			mockStorage.getImageInfoFunc = func(id uuid.UUID) (*model.Image, error) {
+				return tt.image, nil
+			}
+			mockStorage.findSimilarImagesFunc = func(h model.Hashes, maxDistance int, exclude uuid.UUID) ([]model.SimilarImage, error) {
+				assert.Equal(t, *hashes, h)
+				assert.Equal(t, tt.maxDistance, maxDistance)
+				assert.Equal(t, testID, exclude)
+				return similar, nil
+			}
+
+			result, err := service.GetSimilarImages(testID, tt.maxDistance)
+
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestService_SearchSimilarImages(t *testing.T) {
+	src := smartScene(80, 60, image.Rect(10, 10, 40, 40))
+
+	t.Run("hashes the uploaded image", func(t *testing.T) {
+		service, mockStorage, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		var searched model.Hashes
+		mockStorage.findSimilarImagesFunc = func(hashes model.Hashes, maxDistance int, exclude uuid.UUID) ([]model.SimilarImage, error) {
+			searched = hashes
+			assert.Equal(t, DefaultMaxDistance, maxDistance)
+			assert.Equal(t, uuid.Nil, exclude)
+			return []model.SimilarImage{}, nil
+		}
+
+		result, err := service.SearchSimilarImages(encodeTestPNG(t, src), DefaultMaxDistance)
+
+		assert.NoError(t, err)
+		assert.Empty(t, result)
+		assert.Equal(t, imageHashes(src), searched)
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		_, err := service.SearchSimilarImages([]byte("fake image data"), DefaultMaxDistance)
+		assert.ErrorIs(t, err, ErrInvalidImage)
+	})
+
+	t.Run("invalid distance", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		_, err := service.SearchSimilarImages(encodeTestPNG(t, src), 100)
+		assert.ErrorIs(t, err, ErrInvalidSimilarSearch)
+	})
+}
+
+func TestImageHashes(t *testing.T) {
+	distance := func(a, b model.Hashes) int {
+		return bits.OnesCount64(a.PHash ^ b.PHash)
+	}
+
+	src := smartScene(400, 300, image.Rect(40, 60, 200, 220))
+	hashes := imageHashes(src)
+
+	t.Run("same image", func(t *testing.T) {
+		assert.Equal(t, hashes, imageHashes(toRGBA(src)))
+	})
+
+	t.Run("downscaled and recompressed copy", func(t *testing.T) {
+		data := encodeTestJPEG(t, res.Resize(200, 150, src, res.Bilinear))
+		copied, err := hashData(data, "jpeg")
+
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, distance(hashes, copied), DefaultMaxDistance)
+	})
+
+	t.Run("different images", func(t *testing.T) {
+		other := smartScene(400, 300, image.Rect(220, 40, 380, 160))
+		assert.Greater(t, distance(hashes, imageHashes(other)), DefaultMaxDistance)
+		assert.Greater(t, distance(hashes, imageHashes(createGradientImage(256, 256))), DefaultMaxDistance)
+	})
+
+	t.Run("average hash", func(t *testing.T) {
+		halves := createSolidImage(64, 64, color.Black)
+		draw.Draw(halves, image.Rect(32, 0, 64, 64), image.White, image.Point{}, draw.Src)
+
+		assert.Equal(t, uint64(0), imageHashes(createSolidImage(64, 64, color.White)).AHash)
+		assert.Equal(t, uint64(0x0f0f0f0f0f0f0f0f), imageHashes(halves).AHash)
+	})
+
+	t.Run("oriented jpeg", func(t *testing.T) {
+		data := withEXIFOrientation(encodeTestJPEG(t, src), 6)
+		oriented, err := hashData(data, "jpeg")
+
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, distance(imageHashes(orient(src, 6)), oriented), 2)
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		_, err := hashData([]byte("fake image data"), "png")
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []dto.Variant
+		expected error
+	}{
+		{"valid names", []dto.Variant{{Name: "thumbnail"}, {Name: "medium_2x"}, {Name: "large-1"}}, nil},
+		{"empty name", []dto.Variant{{Name: ""}}, ErrInvalidVariants},
+		{"invalid characters", []dto.Variant{{Name: "../large"}}, ErrInvalidVariants},
+		{"duplicate names", []dto.Variant{{Name: "medium"}, {Name: "medium"}}, ErrInvalidVariants},
+		{"too many variants", make([]dto.Variant, maxVariants+1), ErrInvalidVariants},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVariants(tt.variants)
+			assert.Equal(t, tt.expected, err)
+		})
+	}
+}
+
+func TestService_DeleteImage(t *testing.T) {
+	t.Run("successful deletion", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+
+		mockStorage.deleteImageFunc = func(id uuid.UUID) error {
+			return nil
+		}
+		mockFileStorage.deleteImagesFunc = func(storageType string, fileNames ...string) error {
+			return nil
+		}
+
+		err := service.DeleteImage(testID)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("deletes variants", func(t *testing.T) {
+		service, mockStorage, mockFileStorage, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		deleted := make(map[string][]string)
+
+		mockStorage.getImageInfoFunc = func(id uuid.UUID) (*model.Image, error) {
+			return &model.Image{ID: id, Format: "png", Variants: []model.Variant{{Name: "thumb"}}}, nil
+		}
+		mockFileStorage.deleteImagesFunc = func(storageType string, fileNames ...string) error {
+			deleted[storageType] = fileNames
+			return nil
+		}
+
+		err := service.DeleteImage(testID)
+
+		assert.NoError(t, err)
+		assert.Contains(t, deleted["processed"], testID.String()+"_thumb.png")
+		assert.Contains(t, deleted["processed"], testID.String()+".png")
+		assert.NotContains(t, deleted["images"], testID.String()+"_thumb.png")
+	})
+
+	t.Run("storage deletion error", func(t *testing.T) {
+		service, mockStorage, _ := createTestServiceWithoutFileStorage()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+
+		mockStorage.deleteImageFunc = func(id uuid.UUID) error {
+			return errors.New("storage error")
+		}
+
+		err := service.DeleteImage(testID)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestIsCorrectFormat(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected bool
+	}{
+		{"jpeg", true},
+		{"jpg", true},
+		{"png", true},
+		{"gif", true},
+		{"webp", true},
+		{"bmp", true},
+		{"tiff", true},
+		{"svg", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			result := isCorrectFormat(tt.format)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    string
+		expectError bool
+	}{
+		{"image/jpeg", "jpeg", false},
 		{"image/jpg", "jpeg", false},
 		{"image/png", "png", false},
 		{"image/gif", "gif", false},
+		{"image/webp", "webp", false},
+		{"image/bmp", "bmp", false},
+		{"image/x-ms-bmp", "bmp", false},
+		{"image/tiff", "tiff", false},
+		{"image/tif", "tiff", false},
+		{"image/svg+xml", "", true},
 		{"invalid/type", "", true},
 		{"", "", true},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.contentType, func(t *testing.T) {
-			result, err := parseFormat(tt.contentType)
+		t.Run(tt.contentType, func(t *testing.T) {
+			result, err := parseFormat(tt.contentType)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestIsCorrectTask(t *testing.T) {
+	tests := []struct {
+		task     string
+		expected bool
+	}{
+		{"resize", true},
+		{"watermark", true},
+		{"miniature generating", true},
+		{"crop", true},
+		{"rotate", true},
+		{"flip", true},
+		{"auto-orient", true},
+		{"adjust", true},
+		{"blur", true},
+		{"sharpen", true},
+		{"convolve", true},
+		{"redact", true},
+		{"border", true},
+		{"padding", true},
+		{"round-corners", true},
+		{"canvas", true},
+		{"caption", true},
+		{"invalid_task", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.task, func(t *testing.T) {
+			result := isCorrectTask(tt.task)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestAddWatermark(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("default font", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testImage := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 100; x++ {
+				testImage.Set(x, y, color.RGBA{255, 255, 255, 255})
+			}
+		}
+
+		result, err := service.addWatermark(testImage, "Test Watermark", dto.Watermark{})
+
+		assert.NoError(t, err)
+		assert.NotEqual(t, testImage.Pix, result.(*image.RGBA).Pix)
+	})
+
+	t.Run("unknown font", func(t *testing.T) {
+		service, _, mockFileStorage, _ := createTestService()
+		defer cleanupTestDirs()
+
+		mockFileStorage.getImageFunc = func(string, string, string) error {
+			return errors.New("object does not exist")
+		}
+
+		result, err := service.addWatermark(createSolidImage(100, 100, color.White), "Test Watermark", dto.Watermark{Font: uuid.NewString()})
+
+		assert.ErrorIs(t, err, ErrNoSuchFont)
+		assert.Nil(t, result)
+	})
+}
+
+func TestLoadFont(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("builtin fonts", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		for _, name := range []string{"", defaultFont, "go-regular", "go-mono"} {
+			ft, err := service.loadFont(name)
+			assert.NoError(t, err, name)
+			assert.NotNil(t, ft, name)
+		}
+
+		first, _ := service.loadFont("go-bold")
+		second, _ := service.loadFont("go-bold")
+		assert.Same(t, first, second)
+	})
+
+	t.Run("uploaded font is downloaded once", func(t *testing.T) {
+		service, _, mockFileStorage, _ := createTestService()
+		defer cleanupTestDirs()
+
+		id := uuid.New()
+		var requested []string
+		mockFileStorage.getImageFunc = func(fileName, filePath, bucket string) error {
+			assert.Equal(t, fontsBucket, bucket)
+			requested = append(requested, fileName)
+			if fileName != id.String()+".otf" {
+				return errors.New("object does not exist")
+			}
+			return os.WriteFile(filePath, goregular.TTF, 0644)
+		}
+
+		for range 3 {
+			ft, err := service.loadFont(id.String())
+			assert.NoError(t, err)
+			assert.NotNil(t, ft)
+		}
+		assert.Equal(t, []string{id.String() + ".ttf", id.String() + ".otf"}, requested)
+	})
+
+	t.Run("unknown font", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		_, err := service.loadFont("comic-sans")
+		assert.ErrorIs(t, err, ErrNoSuchFont)
+	})
+}
+
+func TestCreateFont(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("stores font", func(t *testing.T) {
+		service, _, mockFileStorage, _ := createTestService()
+		defer cleanupTestDirs()
+
+		var savedName, savedBucket string
+		mockFileStorage.saveImageFunc = func(fileName, filePath, bucket string) error {
+			savedName, savedBucket = fileName, bucket
+			data, err := os.ReadFile(filePath)
+			assert.NoError(t, err)
+			assert.Equal(t, goregular.TTF, data)
+			return nil
+		}
+
+		id, err := service.CreateFont(goregular.TTF)
+
+		assert.NoError(t, err)
+		assert.Equal(t, id.String()+".ttf", savedName)
+		assert.Equal(t, fontsBucket, savedBucket)
+
+		ft, err := service.loadFont(id.String())
+		assert.NoError(t, err)
+		assert.NotNil(t, ft)
+	})
+
+	t.Run("invalid font", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		id, err := service.CreateFont([]byte("not a font"))
+
+		assert.ErrorIs(t, err, ErrInvalidFont)
+		assert.Nil(t, id)
+	})
+}
+
+func TestWatermarkLabel(t *testing.T) {
+	ft, err := opentype.Parse(goregular.TTF)
+	assert.NoError(t, err)
+
+	bounds := image.Rect(0, 0, 400, 200)
+
+	t.Run("font size is relative to width", func(t *testing.T) {
+		small, err := watermarkLabel(bounds, ft, "Watermark", watermarkDefaults(dto.Watermark{FontSize: 0.05}))
+		assert.NoError(t, err)
+		large, err := watermarkLabel(bounds, ft, "Watermark", watermarkDefaults(dto.Watermark{FontSize: 0.1}))
+		assert.NoError(t, err)
+
+		assert.Greater(t, large.Bounds().Dx(), small.Bounds().Dx())
+		assert.InDelta(t, 2, float64(large.Bounds().Dy())/float64(small.Bounds().Dy()), 0.3)
+	})
+
+	t.Run("long text is shrunk to fit", func(t *testing.T) {
+		opts := watermarkDefaults(dto.Watermark{FontSize: 1})
+		label, err := watermarkLabel(bounds, ft, "A very long watermark that is wider than the image", opts)
+		assert.NoError(t, err)
+
+		area := watermarkArea(bounds, opts.Margin)
+		assert.LessOrEqual(t, label.Bounds().Dx(), area.Dx())
+		assert.LessOrEqual(t, label.Bounds().Dy(), area.Dy())
+	})
+
+	t.Run("rotated text is shrunk to fit", func(t *testing.T) {
+		opts := watermarkDefaults(dto.Watermark{FontSize: 0.2, Rotation: 90})
+		straight, err := watermarkLabel(bounds, ft, "Watermark", watermarkDefaults(dto.Watermark{FontSize: 0.2}))
+		assert.NoError(t, err)
+		vertical, err := watermarkLabel(bounds, ft, "Watermark", opts)
+		assert.NoError(t, err)
+
+		assert.Less(t, vertical.Bounds().Dx(), straight.Bounds().Dx())
+		assert.LessOrEqual(t, vertical.Bounds().Dx(), watermarkArea(bounds, opts.Margin).Dy())
+	})
+
+	t.Run("text is not clipped", func(t *testing.T) {
+		text := "Wjgpqy"
+		label, err := labelImage(ft, text, 40, color.Black)
+		assert.NoError(t, err)
+
+		face, err := opentype.NewFace(ft, &opentype.FaceOptions{Size: 40, DPI: 72, Hinting: font.HintingFull})
+		assert.NoError(t, err)
+		reference := image.NewRGBA(image.Rect(0, 0, 400, 200))
+		d := &font.Drawer{Dst: reference, Src: image.Black, Face: face, Dot: fixed.P(100, 100)}
+		d.DrawString(text)
+
+		ink := func(img *image.RGBA) int {
+			total := 0
+			for i := 3; i < len(img.Pix); i += 4 {
+				total += int(img.Pix[i])
+			}
+			return total
+		}
+		assert.Positive(t, ink(label))
+		assert.Equal(t, ink(reference), ink(label))
+	})
+
+	t.Run("invalid color", func(t *testing.T) {
+		_, err := watermarkLabel(bounds, ft, "Watermark", dto.Watermark{Color: "red", FontSize: 0.1})
+		assert.Error(t, err)
+	})
+}
+
+func TestDrawWatermark(t *testing.T) {
+	label := createSolidImage(20, 10, color.Black)
+
+	tests := []struct {
+		name     string
+		position string
+		expected image.Point
+	}{
+		{name: "north west", position: GravityNorthWest, expected: image.Pt(5, 5)},
+		{name: "north", position: GravityNorth, expected: image.Pt(40, 5)},
+		{name: "center", position: GravityCenter, expected: image.Pt(40, 45)},
+		{name: "east", position: GravityEast, expected: image.Pt(75, 45)},
+		{name: "south", position: GravitySouth, expected: image.Pt(40, 85)},
+		{name: "south east", position: GravitySouthEast, expected: image.Pt(75, 85)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+
+			err := drawWatermark(dst, label, dto.Watermark{Position: tt.position, Margin: 5, Opacity: 0.5})
+			assert.NoError(t, err)
+
+			assert.Equal(t, color.RGBA{127, 127, 127, 255}, dst.RGBAAt(tt.expected.X, tt.expected.Y))
+			assert.Equal(t, color.RGBA{127, 127, 127, 255}, dst.RGBAAt(tt.expected.X+19, tt.expected.Y+9))
+			assert.Equal(t, color.RGBA{255, 255, 255, 255}, dst.RGBAAt(tt.expected.X-1, tt.expected.Y))
+			assert.Equal(t, color.RGBA{255, 255, 255, 255}, dst.RGBAAt(tt.expected.X+20, tt.expected.Y+10))
+		})
+	}
+}
+
+func TestDrawTiledWatermark(t *testing.T) {
+	mark := createSolidImage(10, 10, color.Black)
+
+	for _, rotation := range []float64{360, -45, 30} {
+		t.Run(fmt.Sprintf("rotation %v", rotation), func(t *testing.T) {
+			dst := createSolidImage(200, 160, color.White)
+
+			err := drawTiledWatermark(dst, mark, dto.Watermark{Rotation: rotation, Spacing: 10, Opacity: 1})
+			assert.NoError(t, err)
+
+			// Every part of the image, including the corners, is covered.
+			for by := 0; by < 160; by += 40 {
+				for bx := 0; bx < 200; bx += 40 {
+					marked := false
+					for y := by; y < by+40 && !marked; y++ {
+						for x := bx; x < bx+40 && !marked; x++ {
+							marked = dst.RGBAAt(x, y).R < 128
+						}
+					}
+					assert.True(t, marked, "no mark in block (%d, %d)", bx, by)
+				}
+			}
+		})
+	}
+
+	t.Run("spacing", func(t *testing.T) {
+		dst := createSolidImage(100, 100, color.White)
+
+		err := drawTiledWatermark(dst, mark, dto.Watermark{Rotation: 360, Spacing: 30, Opacity: 1})
+		assert.NoError(t, err)
+
+		// Marks are centred on the image and 40 pixels apart in a row.
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, dst.RGBAAt(45, 45))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, dst.RGBAAt(85, 45))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, dst.RGBAAt(65, 45))
+		// The next row is shifted by half a cell.
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, dst.RGBAAt(65, 85))
+	})
+}
+
+func TestCreateOverlay(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("stores overlay as png", func(t *testing.T) {
+		service, _, mockFileStorage, _ := createTestService()
+		defer cleanupTestDirs()
+
+		var savedName, savedBucket string
+		var saved image.Image
+		mockFileStorage.saveImageFunc = func(fileName, filePath, bucket string) error {
+			savedName, savedBucket = fileName, bucket
+			file, err := os.Open(filePath)
+			assert.NoError(t, err)
+			defer file.Close()
+			saved, err = png.Decode(file)
+			assert.NoError(t, err)
+			return nil
+		}
+
+		id, err := service.CreateOverlay(encodeTestJPEG(t, createSolidImage(30, 20, color.White)))
+
+		assert.NoError(t, err)
+		assert.Equal(t, id.String()+".png", savedName)
+		assert.Equal(t, overlaysBucket, savedBucket)
+		assert.Equal(t, image.Pt(30, 20), saved.Bounds().Size())
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		id, err := service.CreateOverlay([]byte("not an image"))
+
+		assert.ErrorIs(t, err, ErrInvalidOverlay)
+		assert.Nil(t, id)
+	})
+}
+
+func TestLoadOverlay(t *testing.T) {
+	defer cleanupTestDirs()
+
+	service, _, mockFileStorage, _ := createTestService()
+	overlay := encodeTestPNG(t, createSolidImage(8, 4, color.Black))
+
+	downloads := 0
+	mockFileStorage.getImageFunc = func(fileName, filePath, bucket string) error {
+		assert.Equal(t, overlaysBucket, bucket)
+		downloads++
+		return os.WriteFile(filePath, overlay, 0644)
+	}
+
+	id := uuid.New()
+	for range 3 {
+		img, err := service.loadOverlay(id)
+		assert.NoError(t, err)
+		assert.Equal(t, image.Pt(8, 4), img.Bounds().Size())
+	}
+	assert.Equal(t, 1, downloads)
+
+	mockFileStorage.getImageFunc = func(string, string, string) error {
+		return errors.New("object does not exist")
+	}
+	_, err := service.loadOverlay(uuid.New())
+	assert.ErrorIs(t, err, ErrNoSuchOverlay)
+}
+
+func TestWatermarkOverlay(t *testing.T) {
+	overlay := createSolidImage(100, 50, color.Black)
+	bounds := image.Rect(0, 0, 400, 300)
+
+	tests := []struct {
+		name     string
+		opts     dto.Watermark
+		expected image.Point
+	}{
+		{name: "own size", opts: dto.Watermark{}, expected: image.Pt(100, 50)},
+		{name: "scaled to image width", opts: dto.Watermark{Scale: 0.5}, expected: image.Pt(200, 100)},
+		{name: "shrunk to fit margins", opts: dto.Watermark{Scale: 1, Margin: 50}, expected: image.Pt(300, 150)},
+		{name: "rotated overlay fits margins", opts: dto.Watermark{Scale: 1, Rotation: 90, Margin: 10}, expected: image.Pt(280, 140)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := watermarkOverlay(bounds, overlay, tt.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Bounds().Size())
+		})
+	}
+}
+
+func TestAddWatermark_Overlay(t *testing.T) {
+	defer cleanupTestDirs()
+
+	service, _, _, _ := createTestService()
+	id := uuid.New()
+	service.overlays.put(id, createSolidImage(20, 10, color.Black))
+
+	t.Run("single", func(t *testing.T) {
+		img := createSolidImage(100, 100, color.White)
+		opts := dto.Watermark{OverlayID: id.String(), Position: GravityNorthWest, Margin: 5, Opacity: 1}
+
+		result, err := service.addWatermark(img, "", opts)
+		assert.NoError(t, err)
+
+		rgba := result.(*image.RGBA)
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(5, 5))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(24, 14))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(25, 15))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(4, 4))
+	})
+
+	t.Run("tiled", func(t *testing.T) {
+		img := createSolidImage(100, 100, color.White)
+		opts := dto.Watermark{OverlayID: id.String(), Mode: WatermarkTiled, Opacity: 1}
+
+		result, err := service.addWatermark(img, "", opts)
+		assert.NoError(t, err)
+
+		marked := 0
+		rgba := result.(*image.RGBA)
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 100; x++ {
+				if rgba.RGBAAt(x, y).R < 128 {
+					marked++
+				}
+			}
+		}
+		assert.Greater(t, marked, 1000)
+	})
+}
+
+func TestCreateThumbnail(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("successful thumbnail", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testImage := createSolidImage(400, 300, color.White)
+
+		result, err := service.createThumbnail(testImage)
+
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, thumbnailSize, thumbnailSize), result.Bounds())
+	})
+}
+
+func TestResizeImage(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("successful resize", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testImage := createSolidImage(100, 100, color.White)
+
+		result, err := service.resizeImage(testImage, dto.Resize{Width: 50, Height: 50})
+
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 50, 50), result.Bounds())
+	})
+}
+
+func createSolidImage(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func encodeTestPNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("could not encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeWithMode(t *testing.T) {
+	src := createSolidImage(400, 200, color.RGBA{255, 0, 0, 255})
+
+	tests := []struct {
+		name           string
+		opts           dto.Resize
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"fit by default", dto.Resize{Width: 100, Height: 100}, 100, 50},
+		{"fit", dto.Resize{Width: 100, Height: 100, Mode: ResizeFit}, 100, 50},
+		{"fit tall box", dto.Resize{Width: 300, Height: 50, Mode: ResizeFit}, 100, 50},
+		{"fill", dto.Resize{Width: 100, Height: 100, Mode: ResizeFill}, 100, 100},
+		{"cover", dto.Resize{Width: 50, Height: 80, Mode: ResizeCover}, 50, 80},
+		{"pad", dto.Resize{Width: 100, Height: 100, Mode: ResizePad}, 100, 100},
+		{"stretch", dto.Resize{Width: 100, Height: 100, Mode: ResizeStretch}, 100, 100},
+		{"width only", dto.Resize{Width: 100, Mode: ResizePad}, 100, 50},
+		{"height only", dto.Resize{Height: 100, Mode: ResizeFill}, 200, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := resizeWithMode(src, tt.opts)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedWidth, result.Bounds().Dx())
+			assert.Equal(t, tt.expectedHeight, result.Bounds().Dy())
+		})
+	}
+
+	t.Run("thin images stay within the limit", func(t *testing.T) {
+		assert.Equal(t, image.Pt(1, maxDimension), resizedSize(image.Pt(1, 10000), dto.Resize{Width: 10000}))
+		assert.Equal(t, image.Pt(maxDimension, 1), resizedSize(image.Pt(10000, 1), dto.Resize{Height: 10000}))
+
+		w, h := coverSize(1, 10000, 10000, 10000)
+		assert.Equal(t, []int{maxDimension, maxDimension}, []int{w, h})
+
+		result, err := resizeWithMode(createSolidImage(1, 400, color.White), dto.Resize{Width: 40, Height: 30, Mode: ResizeCover})
+		assert.NoError(t, err)
+		assert.Equal(t, image.Pt(40, 30), result.Bounds().Size())
+	})
+
+	t.Run("pad fills background", func(t *testing.T) {
+		opts := dto.Resize{Width: 100, Height: 100, Mode: ResizePad, Background: "#00ff00"}
+
+		result, err := resizeWithMode(src, opts)
+		assert.NoError(t, err)
+
+		r, g, b, _ := result.At(50, 5).RGBA()
+		assert.Equal(t, []uint32{0, 0xffff, 0}, []uint32{r, g, b})
+
+		r, g, b, _ = result.At(50, 50).RGBA()
+		assert.Equal(t, []uint32{0xffff, 0, 0}, []uint32{r, g, b})
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		_, err := resizeWithMode(src, dto.Resize{Width: 100, Height: 100, Mode: "squash"})
+
+		assert.ErrorIs(t, err, ErrInvalidResize)
+	})
+}
+
+func TestResizeWithMode_Filter(t *testing.T) {
+	// A 2x2 checkerboard scaled up 4 times.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.SetRGBA(0, 0, color.RGBA{0, 0, 0, 255})
+	src.SetRGBA(1, 1, color.RGBA{0, 0, 0, 255})
+	src.SetRGBA(1, 0, color.RGBA{255, 255, 255, 255})
+	src.SetRGBA(0, 1, color.RGBA{255, 255, 255, 255})
+
+	colors := func(img image.Image) map[color.RGBA]struct{} {
+		seen := make(map[color.RGBA]struct{})
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				seen[color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)] = struct{}{}
+			}
+		}
+		return seen
+	}
+
+	nearest, err := resizeWithMode(src, dto.Resize{Width: 8, Height: 8, Filter: FilterNearest})
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 8), nearest.Bounds())
+	assert.Len(t, colors(nearest), 2, "nearest neighbour must keep the hard edges")
+
+	for _, filter := range []string{"", FilterBilinear, FilterBicubic, FilterMitchell, FilterLanczos2, FilterLanczos3} {
+		smooth, err := resizeWithMode(src, dto.Resize{Width: 8, Height: 8, Filter: filter})
+		assert.NoError(t, err)
+		assert.Greater(t, len(colors(smooth)), 2, "filter %q must interpolate", filter)
+	}
+}
+
+func TestIsCorrectResize(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     dto.Resize
+		expected bool
+	}{
+		{"both dimensions", dto.Resize{Width: 100, Height: 100}, true},
+		{"width only", dto.Resize{Width: 100}, true},
+		{"height only", dto.Resize{Height: 100}, true},
+		{"pad with background", dto.Resize{Width: 100, Height: 100, Mode: ResizePad, Background: "#000"}, true},
+		{"no dimensions", dto.Resize{}, false},
+		{"negative width", dto.Resize{Width: -1, Height: 100}, false},
+		{"too large", dto.Resize{Width: maxDimension + 1, Height: 100}, false},
+		{"unknown mode", dto.Resize{Width: 100, Height: 100, Mode: "squash"}, false},
+		{"invalid background", dto.Resize{Width: 100, Height: 100, Mode: ResizePad, Background: "red"}, false},
+		{"nearest filter", dto.Resize{Width: 100, Filter: FilterNearest}, true},
+		{"mitchell filter", dto.Resize{Width: 100, Height: 100, Mode: ResizeFill, Filter: FilterMitchell}, true},
+		{"unknown filter", dto.Resize{Width: 100, Filter: "lanczos5"}, false},
+		{"smart gravity", dto.Resize{Width: 100, Height: 100, Mode: ResizeCover, Gravity: GravitySmart}, true},
+		{"unknown gravity", dto.Resize{Width: 100, Height: 100, Mode: ResizeCover, Gravity: "top"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := isCorrectResize(tt.opts)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    color.NRGBA
+		expectError bool
+	}{
+		{"#fff", color.NRGBA{255, 255, 255, 255}, false},
+		{"#ff0000", color.NRGBA{255, 0, 0, 255}, false},
+		{"00ff0080", color.NRGBA{0, 255, 0, 128}, false},
+		{"transparent", color.NRGBA{}, false},
+		{"#12345", color.NRGBA{}, true},
+		{"#gggggg", color.NRGBA{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			result, err := parseColor(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCropRect(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 80)
+
+	tests := []struct {
+		name        string
+		opts        dto.Crop
+		expected    image.Rectangle
+		expectError bool
+	}{
+		{"explicit rectangle", dto.Crop{X: 10, Y: 20, Width: 30, Height: 40}, image.Rect(10, 20, 40, 60), false},
+		{"whole image", dto.Crop{Width: 100, Height: 80}, bounds, false},
+		{"center", dto.Crop{Width: 50, Height: 40, Gravity: GravityCenter}, image.Rect(25, 20, 75, 60), false},
+		{"north", dto.Crop{Width: 50, Height: 40, Gravity: GravityNorth}, image.Rect(25, 0, 75, 40), false},
+		{"south-east", dto.Crop{Width: 50, Height: 40, Gravity: GravitySouthEast}, image.Rect(50, 40, 100, 80), false},
+		{"west", dto.Crop{Width: 50, Height: 40, Gravity: GravityWest}, image.Rect(0, 20, 50, 60), false},
+		{"gravity ignores offset", dto.Crop{X: 90, Y: 90, Width: 50, Height: 40, Gravity: GravityNorthWest}, image.Rect(0, 0, 50, 40), false},
+		{"out of bounds", dto.Crop{X: 60, Y: 0, Width: 50, Height: 40}, image.Rectangle{}, true},
+		{"negative offset", dto.Crop{X: -1, Y: 0, Width: 50, Height: 40}, image.Rectangle{}, true},
+		{"empty size", dto.Crop{X: 10, Y: 10}, image.Rectangle{}, true},
+		{"larger than image", dto.Crop{Width: 150, Height: 40, Gravity: GravityCenter}, image.Rectangle{}, true},
+		{"unknown gravity", dto.Crop{Width: 50, Height: 40, Gravity: "up"}, image.Rectangle{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := cropRect(bounds, tt.opts)
+			if tt.expectError {
+				assert.ErrorIs(t, err, ErrInvalidCrop)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCropToRect(t *testing.T) {
+	src := createSolidImage(100, 100, color.RGBA{0, 0, 255, 255})
+	src.Set(60, 70, color.RGBA{255, 0, 0, 255})
+
+	result := cropToRect(src, image.Rect(50, 50, 80, 90))
+
+	assert.Equal(t, image.Rect(0, 0, 30, 40), result.Bounds())
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, result.At(10, 20))
+	assert.Equal(t, color.RGBA{0, 0, 255, 255}, result.At(0, 0))
+}
+
+func TestCropImage(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("successful crop", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testImage := createSolidImage(100, 100, color.White)
+
+		result, err := service.cropImage(testImage, dto.Crop{X: 10, Y: 10, Width: 20, Height: 30})
+
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 20, 30), result.Bounds())
+	})
+
+	t.Run("rectangle outside image", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testImage := createSolidImage(100, 100, color.White)
+
+		result, err := service.cropImage(testImage, dto.Crop{X: 90, Y: 10, Width: 20, Height: 30})
+
+		assert.ErrorIs(t, err, ErrInvalidCrop)
+		assert.Nil(t, result)
+	})
+}
+
+// createGradientImage returns an image where every pixel has a unique colour
+// derived from its coordinates, which makes geometric transforms easy to check.
+func createGradientImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	return img
+}
+
+// withEXIFOrientation inserts an Exif APP1 segment carrying only the
+// Orientation tag right after the SOI marker of a JPEG stream.
+func withEXIFOrientation(data []byte, orientation uint16) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1}
+	tiff = binary.BigEndian.AppendUint16(tiff, tagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xff, markerAPP1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	result := append([]byte{}, data[:2]...)
+	result = append(result, segment...)
+	return append(result, data[2:]...)
+}
+
+func encodeTestJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("could not encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	data := encodeTestJPEG(t, createSolidImage(8, 8, color.White))
+
+	t.Run("no exif", func(t *testing.T) {
+		assert.Equal(t, 1, exifOrientation(data))
+	})
+
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			assert.Equal(t, int(orientation), exifOrientation(withEXIFOrientation(data, orientation)))
+		})
+	}
+
+	t.Run("invalid value", func(t *testing.T) {
+		assert.Equal(t, 1, exifOrientation(withEXIFOrientation(data, 9)))
+	})
+
+	t.Run("not a jpeg", func(t *testing.T) {
+		assert.Equal(t, 1, exifOrientation([]byte("fake image data")))
+	})
+}
+
+type testTIFFTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiTestTag(tag uint16, value string) testTIFFTag {
+	return testTIFFTag{tag, 2, uint32(len(value) + 1), append([]byte(value), 0)}
+}
+
+func shortTestTag(tag uint16, value uint16) testTIFFTag {
+	return testTIFFTag{tag, 3, 1, binary.BigEndian.AppendUint16(nil, value)}
+}
+
+func byteTestTag(tag uint16, value byte) testTIFFTag {
+	return testTIFFTag{tag, 1, 1, []byte{value}}
+}
+
+func rationalTestTag(tag uint16, fractions ...uint32) testTIFFTag {
+	var value []byte
+	for _, n := range fractions {
+		value = binary.BigEndian.AppendUint32(value, n)
+	}
+	return testTIFFTag{tag, 5, uint32(len(fractions) / 2), value}
+}
+
+// encodeTestTIFF lays out a big endian TIFF structure with the primary
+// directory followed by the Exif and GPS directories, which are linked from
+// the primary one when they are not empty. Values longer than four bytes are
+// stored after the directories.
+func encodeTestTIFF(primary, exif, gps []testTIFFTag) []byte {
+	dirSize := func(tags []testTIFFTag) uint32 { return uint32(2 + 12*len(tags) + 4) }
+
+	primary = append([]testTIFFTag{}, primary...)
+	if len(exif) > 0 {
+		primary = append(primary, testTIFFTag{tagExifIFD, 4, 1, make([]byte, 4)})
+	}
+	if len(gps) > 0 {
+		primary = append(primary, testTIFFTag{tagGPSIFD, 4, 1, make([]byte, 4)})
+	}
+
+	exifOffset := 8 + dirSize(primary)
+	gpsOffset := exifOffset
+	if len(exif) > 0 {
+		gpsOffset += dirSize(exif)
+	}
+	dataOffset := gpsOffset
+	if len(gps) > 0 {
+		dataOffset += dirSize(gps)
+	}
+
+	for i, tag := range primary {
+		switch tag.tag {
+		case tagExifIFD:
+			primary[i].value = binary.BigEndian.AppendUint32(nil, exifOffset)
+		case tagGPSIFD:
+			primary[i].value = binary.BigEndian.AppendUint32(nil, gpsOffset)
+		}
+	}
+
+	out := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	var data []byte
+	for _, dir := range [][]testTIFFTag{primary, exif, gps} {
+		if len(dir) == 0 {
+			continue
+		}
+
+		out = binary.BigEndian.AppendUint16(out, uint16(len(dir)))
+		for _, tag := range dir {
+			out = binary.BigEndian.AppendUint16(out, tag.tag)
+			out = binary.BigEndian.AppendUint16(out, tag.typ)
+			out = binary.BigEndian.AppendUint32(out, tag.count)
+			if len(tag.value) <= 4 {
+				out = append(out, tag.value...)
+				out = append(out, make([]byte, 4-len(tag.value))...)
+				continue
+			}
+			out = binary.BigEndian.AppendUint32(out, dataOffset+uint32(len(data)))
+			data = append(data, tag.value...)
+			if len(data)%2 == 1 {
+				data = append(data, 0)
+			}
+		}
+		out = append(out, 0, 0, 0, 0)
+	}
+
+	return append(out, data...)
+}
+
+func testEXIF() []byte {
+	return encodeTestTIFF(
+		[]testTIFFTag{
+			asciiTestTag(tagMake, "Canon"),
+			asciiTestTag(tagModel, "Canon EOS R5"),
+			shortTestTag(tagOrientation, 6),
+			asciiTestTag(tagDateTime, "2024:05:02 10:00:00"),
+		},
+		[]testTIFFTag{
+			rationalTestTag(tagExposureTime, 1, 250),
+			rationalTestTag(tagFNumber, 28, 10),
+			shortTestTag(tagISO, 400),
+			asciiTestTag(tagDateTimeOriginal, "2024:05:01 18:30:15"),
+			asciiTestTag(tagOffsetTimeOrig, "+03:00"),
+			rationalTestTag(tagFocalLength, 50, 1),
+			asciiTestTag(tagLensModel, "RF24-105mm F4 L IS USM"),
+		},
+		[]testTIFFTag{
+			asciiTestTag(tagGPSLatitudeRef, "N"),
+			rationalTestTag(tagGPSLatitude, 55, 1, 45, 1, 2106, 100),
+			asciiTestTag(tagGPSLongitudeRef, "W"),
+			rationalTestTag(tagGPSLongitude, 37, 1, 37, 1, 1800, 100),
+			byteTestTag(tagGPSAltitudeRef, 0),
+			rationalTestTag(tagGPSAltitude, 1565, 10),
+		},
+	)
+}
+
+func testIPTC() []byte {
+	var data []byte
+	dataset := func(record, number byte, value string) {
+		data = append(data, 0x1c, record, number)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(value)))
+		data = append(data, value...)
+	}
+
+	dataset(1, 90, "\x1b%G")
+	dataset(2, 0, "\x00\x04")
+	dataset(2, 25, "sunset")
+	dataset(2, 25, "beach")
+	dataset(2, 80, "Jos\xe9")
+	dataset(2, 120, "Evening at the beach")
+	return data
+}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+    xmlns:custom="http://example.com/custom/"
+    xmp:Rating="4"
+    custom:Internal="skipped">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>
+   <dc:subject><rdf:Bag><rdf:li>sunset</rdf:li><rdf:li>beach</rdf:li></rdf:Bag></dc:subject>
+   <xmp:CreatorTool>Lightroom</xmp:CreatorTool>
+   <Iptc4xmpCore:CreatorContactInfo rdf:parseType="Resource">
+    <Iptc4xmpCore:CiAdrCity>Moscow</Iptc4xmpCore:CiAdrCity>
+   </Iptc4xmpCore:CreatorContactInfo>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func jpegTestSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// photoshopTestResources wraps the IPTC data into a Photoshop image resource
+// block with an empty name, after an unrelated resource.
+func photoshopTestResources(iptc []byte) []byte {
+	data := append([]byte{}, photoshopHeader...)
+	data = append(data, "8BIM\x04\x25\x00\x00"...)
+	data = binary.BigEndian.AppendUint32(data, 3)
+	data = append(data, 1, 2, 3, 0)
+	data = append(data, "8BIM\x04\x04\x00\x00"...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(iptc)))
+	return append(data, iptc...)
+}
+
+func pngTestChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return append(chunk, 0, 0, 0, 0)
+}
+
+func assertTestMetadata(t *testing.T, metadata *model.Metadata, withIPTC bool) {
+	t.Helper()
+
+	if !assert.NotNil(t, metadata) || !assert.NotNil(t, metadata.EXIF) {
+		return
+	}
+
+	altitude := 156.5
+	assert.Equal(t, &model.EXIF{
+		Make:         "Canon",
+		Model:        "Canon EOS R5",
+		LensModel:    "RF24-105mm F4 L IS USM",
+		CaptureTime:  "2024-05-01T18:30:15+03:00",
+		Orientation:  6,
+		ExposureTime: "1/250",
+		FNumber:      2.8,
+		ISO:          400,
+		FocalLength:  50,
+		GPS:          &model.GPS{Latitude: 55.75585, Longitude: -37.6216667, Altitude: &altitude},
+	}, metadata.EXIF)
+
+	assert.Equal(t, map[string][]string{
+		"dc:title":        {"Sunset"},
+		"dc:subject":      {"sunset", "beach"},
+		"xmp:Rating":      {"4"},
+		"xmp:CreatorTool": {"Lightroom"},
+	}, metadata.XMP)
+
+	if withIPTC {
+		assert.Equal(t, map[string][]string{
+			"keywords": {"sunset", "beach"},
+			"by_line":  {"José"},
+			"caption":  {"Evening at the beach"},
+		}, metadata.IPTC)
+	}
+}
+
+func TestExtractMetadata(t *testing.T) {
+	src := createSolidImage(8, 8, color.White)
+
+	t.Run("jpeg", func(t *testing.T) {
+		data := encodeTestJPEG(t, src)
+
+		var segments []byte
+		segments = append(segments, jpegTestSegment(markerAPP1, append(append([]byte{}, exifHeader...), testEXIF()...))...)
+		segments = append(segments, jpegTestSegment(markerAPP1, append(append([]byte{}, xmpHeader...), testXMP...))...)
+		segments = append(segments, jpegTestSegment(markerAPP13, photoshopTestResources(testIPTC()))...)
+
+		withMetadata := append(append(append([]byte{}, data[:2]...), segments...), data[2:]...)
+		assertTestMetadata(t, extractMetadata(withMetadata, "jpeg"), true)
+	})
+
+	t.Run("png", func(t *testing.T) {
+		data := encodeTestPNG(t, src)
+
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		_, _ = w.Write([]byte(testXMP))
+		assert.NoError(t, w.Close())
+
+		itxt := append([]byte(xmpKeyword), 0, 1, 0, 0, 0)
+		itxt = append(itxt, compressed.Bytes()...)
+
+		// The chunks are inserted after the IHDR chunk.
+		withMetadata := append([]byte{}, data[:33]...)
+		withMetadata = append(withMetadata, pngTestChunk("eXIf", testEXIF())...)
+		withMetadata = append(withMetadata, pngTestChunk("iTXt", itxt)...)
+		withMetadata = append(withMetadata, data[33:]...)
+
+		assertTestMetadata(t, extractMetadata(withMetadata, "png"), false)
+	})
+
+	t.Run("webp", func(t *testing.T) {
+		var chunks []byte
+		for _, chunk := range []struct {
+			fourCC string
+			data   []byte
+		}{
+			{"VP8X", make([]byte, 10)},
+			{"EXIF", append(append([]byte{}, exifHeader...), testEXIF()...)},
+			{"XMP ", []byte(testXMP + " ")},
+		} {
+			chunks = append(chunks, chunk.fourCC...)
+			chunks = binary.LittleEndian.AppendUint32(chunks, uint32(len(chunk.data)))
+			chunks = append(chunks, chunk.data...)
+			if len(chunk.data)%2 == 1 {
+				chunks = append(chunks, 0)
+			}
+		}
+
+		data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(chunks)+4))...)
+		data = append(data, "WEBP"...)
+		data = append(data, chunks...)
+
+		assertTestMetadata(t, extractMetadata(data, "webp"), false)
+	})
+
+	t.Run("without metadata", func(t *testing.T) {
+		assert.Nil(t, extractMetadata(encodeTestJPEG(t, src), "jpeg"))
+		assert.Nil(t, extractMetadata(encodeTestPNG(t, src), "png"))
+	})
+
+	t.Run("orientation only", func(t *testing.T) {
+		data := withEXIFOrientation(encodeTestJPEG(t, src), 3)
+		assert.Equal(t, &model.Metadata{EXIF: &model.EXIF{Orientation: 3}}, extractMetadata(data, "jpeg"))
+	})
+
+	t.Run("malformed blocks", func(t *testing.T) {
+		data := encodeTestJPEG(t, src)
+		broken := jpegTestSegment(markerAPP1, append(append([]byte{}, exifHeader...), "MM\x00\x2a\xff\xff\xff\xff"...))
+		withMetadata := append(append(append([]byte{}, data[:2]...), broken...), data[2:]...)
+
+		assert.Nil(t, extractMetadata(withMetadata, "jpeg"))
+		assert.Nil(t, extractMetadata([]byte("fake image data"), "jpeg"))
+	})
+}
+
+func TestExposureTime(t *testing.T) {
+	tests := []struct {
+		num, den uint32
+		expected string
+	}{
+		{1, 125, "1/125"},
+		{10, 1250, "1/125"},
+		{2, 1, "2"},
+		{3, 10, "0.3"},
+		{0, 1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.num, tt.den), func(t *testing.T) {
+			data := encodeTestTIFF(nil, []testTIFFTag{rationalTestTag(tagExposureTime, tt.num, tt.den)}, nil)
+			exif := parseEXIF(data)
+
+			if tt.expected == "" {
+				assert.Nil(t, exif)
+				return
+			}
+			assert.Equal(t, tt.expected, exif.ExposureTime)
+		})
+	}
+}
+
+const testRightsXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    exif:GPSLatitude="55,45.351N">
+   <dc:rights><rdf:Alt><rdf:li xml:lang="x-default">© Jane Roe &amp; Co</rdf:li></rdf:Alt></dc:rights>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+// privateTestBlocks are the metadata blocks of a photo with a location, a
+// copyright notice in every block and a colour profile large enough to span
+// two JPEG segments.
+func privateTestBlocks() metadataBlocks {
+	exif := encodeTestTIFF(
+		[]testTIFFTag{
+			asciiTestTag(tagMake, "Canon"),
+			shortTestTag(tagOrientation, 6),
+			asciiTestTag(tagCopyright, "© Jane Roe"),
+		},
+		[]testTIFFTag{asciiTestTag(tagDateTimeOriginal, "2024:05:01 18:30:15")},
+		[]testTIFFTag{
+			asciiTestTag(tagGPSLatitudeRef, "N"),
+			rationalTestTag(tagGPSLatitude, 55, 1, 45, 1, 2106, 100),
+			asciiTestTag(tagGPSLongitudeRef, "E"),
+			rationalTestTag(tagGPSLongitude, 37, 1, 37, 1, 1800, 100),
+		},
+	)
+
+	iptc := testIPTC()
+	iptc = append(iptc, 0x1c, 2, 116, 0, 10)
+	iptc = append(iptc, "(c) Jane R"...)
+
+	return metadataBlocks{
+		exif: exif,
+		iptc: iptc,
+		xmp:  []byte(testRightsXMP),
+		icc:  bytes.Repeat([]byte("icc profile "), 8000),
+	}
+}
+
+func TestOutputMetadata(t *testing.T) {
+	src := privateTestBlocks()
+	off, on := false, true
+
+	t.Run("stripped by default", func(t *testing.T) {
+		result := outputMetadata(src, dto.Output{}, true)
+
+		assert.Equal(t, &model.EXIF{Copyright: "© Jane Roe"}, parseEXIF(result.exif))
+		assert.Equal(t, map[string][]string{"copyright_notice": {"(c) Jane R"}}, parseIPTC(result.iptc))
+		assert.Equal(t, map[string][]string{"dc:rights": {"© Jane Roe & Co"}}, parseXMP(result.xmp))
+		assert.Equal(t, src.icc, result.icc)
+	})
+
+	t.Run("stripped completely", func(t *testing.T) {
+		result := outputMetadata(src, dto.Output{StripMetadata: &on, KeepICCProfile: &off, KeepCopyright: &off}, true)
+		assert.True(t, result.empty())
+	})
+
+	t.Run("originals without copyright", func(t *testing.T) {
+		exif := encodeTestTIFF([]testTIFFTag{asciiTestTag(tagMake, "Canon")}, nil, nil)
+		result := outputMetadata(metadataBlocks{exif: exif}, dto.Output{}, false)
+		assert.True(t, result.empty())
+	})
+
+	t.Run("kept", func(t *testing.T) {
+		result := outputMetadata(src, dto.Output{StripMetadata: &off}, false)
+
+		exif := parseEXIF(result.exif)
+		if assert.NotNil(t, exif) {
+			assert.Equal(t, "Canon", exif.Make)
+			assert.Equal(t, 6, exif.Orientation)
+			assert.Equal(t, "2024-05-01T18:30:15", exif.CaptureTime)
+			assert.NotNil(t, exif.GPS)
+		}
+		assert.Equal(t, src.iptc, result.iptc)
+		assert.Equal(t, src.xmp, result.xmp)
+		assert.Equal(t, src.icc, result.icc)
+	})
+
+	t.Run("applied orientation is reset", func(t *testing.T) {
+		result := outputMetadata(src, dto.Output{StripMetadata: &off}, true)
+		assert.Equal(t, 1, parseEXIF(result.exif).Orientation)
+	})
+}
+
+func TestRewriteEXIF(t *testing.T) {
+	data := encodeTestTIFF(
+		[]testTIFFTag{asciiTestTag(tagMake, "Canon"), shortTestTag(0x0100, 4000)},
+		[]testTIFFTag{
+			shortTestTag(tagISO, 400),
+			{tagMakerNote, 7, 8, []byte("nikon\x00\x02\x10")},
+			shortTestTag(tagPixelXDimension, 4000),
+		},
+		nil,
+	)
+
+	// A second directory with the thumbnail follows the primary one.
+	primaryEnd := 8 + 2 + 12*3
+	binary.BigEndian.PutUint32(data[primaryEnd:], uint32(len(data)))
+	data = binary.BigEndian.AppendUint16(data, 1)
+	data = append(data, 0x02, 0x01, 0, 4, 0, 0, 0, 1, 0, 0, 0, 8)
+	data = append(data, 0, 0, 0, 0)
+
+	rewritten := rewriteEXIF(data, false)
+
+	reader, err := newTIFFReader(rewritten)
+	assert.NoError(t, err)
+
+	primary, next, err := reader.ifd(reader.firstIFD())
+	assert.NoError(t, err)
+	assert.Zero(t, next)
+
+	tags := func(entries []tiffEntry) []uint16 {
+		var result []uint16
+		for _, entry := range entries {
+			result = append(result, entry.tag)
+		}
+		return result
+	}
+	assert.Equal(t, []uint16{tagMake, tagExifIFD}, tags(primary))
+	assert.Equal(t, []uint16{tagISO}, tags(reader.subIFD(primary[1])))
+	assert.Equal(t, &model.EXIF{Make: "Canon", ISO: 400}, parseEXIF(rewritten))
+
+	assert.Nil(t, rewriteEXIF([]byte("fake exif"), false))
+}
+
+func TestEmbedMetadata(t *testing.T) {
+	blocks := privateTestBlocks()
+	src := createGradientImage(30, 20)
+
+	encodeAs := func(t *testing.T, format string, img image.Image) []byte {
+		var buf bytes.Buffer
+		assert.NoError(t, encode(dto.Output{Format: format}, img, &buf))
+		return buf.Bytes()
+	}
+
+	for _, format := range []string{"jpeg", "png", "webp"} {
+		t.Run(format, func(t *testing.T) {
+			data := embedMetadata(format, encodeAs(t, format, src), blocks)
+
+			img, err := decode(format, bytes.NewReader(data))
+			if assert.NoError(t, err) {
+				assert.Equal(t, src.Bounds().Size(), img.Bounds().Size())
+			}
+
+			embedded := findMetadataBlocks(data, format)
+			assert.Equal(t, blocks.exif, embedded.exif)
+			assert.Equal(t, blocks.xmp, embedded.xmp)
+			assert.Equal(t, blocks.icc, embedded.icc)
+			if format == "jpeg" {
+				assert.Equal(t, blocks.iptc, embedded.iptc)
+			}
+		})
+	}
+
+	t.Run("webp with alpha", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+		img.SetNRGBA(1, 1, color.NRGBA{255, 0, 0, 128})
+
+		data := embedMetadata("webp", encodeAs(t, "webp", img), metadataBlocks{xmp: blocks.xmp})
+
+		decoded, err := webp.Decode(bytes.NewReader(data))
+		if assert.NoError(t, err) {
+			assert.Equal(t, color.NRGBA{255, 0, 0, 128}, color.NRGBAModel.Convert(decoded.At(1, 1)))
+		}
+		assert.Equal(t, byte(vp8xXMP), data[20])
+	})
+
+	t.Run("formats without metadata", func(t *testing.T) {
+		data := encodeAs(t, "gif", src)
+		assert.Equal(t, data, embedMetadata("gif", data, blocks))
+	})
+}
+
+// testICCProfile builds an RGB profile with the colorants, the same tone
+// curve for every channel and an ASCII description.
+func testICCProfile(primaries [3][3]float64, curve []byte, description string) []byte {
+	fixed := func(v float64) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+	}
+
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", binary.BigEndian.AppendUint32([]byte("desc\x00\x00\x00\x00"), uint32(len(description)+1))},
+	}
+	tags[0].data = append(append(tags[0].data, description...), 0)
+	for i, signature := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		data := []byte("XYZ \x00\x00\x00\x00")
+		for row := range 3 {
+			data = append(data, fixed(primaries[row][i])...)
+		}
+		tags = append(tags, struct {
+			signature string
+			data      []byte
+		}{signature, data})
+	}
+	for _, signature := range []string{"rTRC", "gTRC", "bTRC"} {
+		if curve != nil {
+			tags = append(tags, struct {
+				signature string
+				data      []byte
+			}{signature, curve})
+		}
+	}
+
+	header := make([]byte, 128)
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := len(header) + 4 + 12*len(tags)
+	for _, tag := range tags {
+		table = append(table, tag.signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+		data = append(data, tag.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+
+	profile := append(append(header, table...), data...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+// srgbTestCurve is the sRGB transfer function as a parametric curve.
+func srgbTestCurve() []byte {
+	curve := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		curve = binary.BigEndian.AppendUint32(curve, uint32(int32(math.Round(v*65536))))
+	}
+	return curve
+}
+
+// gammaTestCurve is a curve with a single gamma value.
+func gammaTestCurve(gamma float64) []byte {
+	curve := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01")
+	return binary.BigEndian.AppendUint16(curve, uint16(math.Round(gamma*256)))
+}
+
+var (
+	p3TestPrimaries = [3][3]float64{
+		{0.515102, 0.291965, 0.157153},
+		{0.241182, 0.692236, 0.066582},
+		{-0.001050, 0.041882, 0.784378},
+	}
+	adobeTestPrimaries = [3][3]float64{
+		{0.609741, 0.205276, 0.149185},
+		{0.311111, 0.625671, 0.063217},
+		{0.019470, 0.060867, 0.744568},
+	}
+)
+
+// p3TestColor converts a Display P3 colour to sRGB with the D65 matrix from
+// the P3 specification, independently of the profile connection space.
+func p3TestColor(c color.NRGBA) color.NRGBA {
+	decode := func(v uint8) float64 {
+		f := float64(v) / 255
+		if f <= 0.04045 {
+			return f / 12.92
+		}
+		return math.Pow((f+0.055)/1.055, 2.4)
+	}
+	encode := func(f float64) uint8 {
+		f = math.Max(0, math.Min(1, f))
+		if f <= 0.0031308 {
+			f *= 12.92
+		} else {
+			f = 1.055*math.Pow(f, 1/2.4) - 0.055
+		}
+		return uint8(math.Round(f * 255))
+	}
+
+	r, g, b := decode(c.R), decode(c.G), decode(c.B)
+	return color.NRGBA{
+		R: encode(1.2249*r - 0.2247*g),
+		G: encode(-0.0420*r + 1.0419*g),
+		B: encode(-0.0197*r - 0.0786*g + 1.0979*b),
+		A: c.A,
+	}
+}
+
+func assertTestColor(t *testing.T, expected, actual color.NRGBA) {
+	t.Helper()
+	for _, pair := range [][2]uint8{{expected.R, actual.R}, {expected.G, actual.G}, {expected.B, actual.B}, {expected.A, actual.A}} {
+		assert.InDelta(t, pair[0], pair[1], 1, "expected %v, got %v", expected, actual)
+	}
+}
+
+func TestParseICCProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		space       string
+		convertible bool
+	}{
+		{"srgb", testICCProfile(srgbPrimaries, srgbTestCurve(), "sRGB IEC61966-2.1"), ColorSpaceSRGB, false},
+		{"display p3", testICCProfile(p3TestPrimaries, srgbTestCurve(), "Display P3"), ColorSpaceP3, true},
+		{"adobe rgb", testICCProfile(adobeTestPrimaries, gammaTestCurve(2.2), "Custom name"), ColorSpaceAdobeRGB, true},
+		{"unknown primaries", testICCProfile([3][3]float64{{0.4, 0.4, 0.2}, {0.2, 0.7, 0.1}, {0, 0.1, 0.7}}, gammaTestCurve(1.8), "Scanner RGB"), "Scanner RGB", true},
+		{"lut based", testICCProfile(p3TestPrimaries, nil, "Camera LUT"), "Camera LUT", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := parseICCProfile(tt.data)
+			if assert.NotNil(t, profile) {
+				assert.Equal(t, tt.space, profile.colorSpace())
+				assert.Equal(t, tt.convertible, profile.convertible())
+			}
+		})
+	}
+
+	t.Run("not a profile", func(t *testing.T) {
+		var profile *iccProfile
+		assert.Nil(t, parseICCProfile([]byte("fake profile")))
+		assert.False(t, profile.convertible())
+	})
+}
+
+func TestImageColorSpace(t *testing.T) {
+	data := encodeTestPNG(t, createSolidImage(4, 4, color.White))
+	p3 := testICCProfile(p3TestPrimaries, srgbTestCurve(), "Display P3")
+
+	assert.Equal(t, ColorSpaceSRGB, imageColorSpace(data, "png"))
+	assert.Equal(t, ColorSpaceP3, imageColorSpace(embedMetadata("png", data, metadataBlocks{icc: p3}), "png"))
+	assert.Equal(t, ColorSpaceUnknown, imageColorSpace(embedMetadata("png", data, metadataBlocks{icc: []byte("fake profile")}), "png"))
+}
+
+func TestToSRGB(t *testing.T) {
+	profile := parseICCProfile(testICCProfile(p3TestPrimaries, srgbTestCurve(), "Display P3"))
+	colors := []color.NRGBA{
+		{0, 0, 0, 255},
+		{255, 255, 255, 255},
+		{200, 100, 50, 255},
+		{30, 160, 220, 255},
+		{120, 120, 120, 255},
+		{200, 100, 50, 128},
+		{255, 0, 0, 255},
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, len(colors), 1))
+	for x, c := range colors {
+		src.SetNRGBA(x, 0, c)
+	}
+
+	result := profile.toSRGB(src)
+	for x, c := range colors {
+		actual := color.NRGBAModel.Convert(result.At(x, 0)).(color.NRGBA)
+		assertTestColor(t, p3TestColor(c), actual)
+	}
+
+	t.Run("gamma curves", func(t *testing.T) {
+		adobe := parseICCProfile(testICCProfile(adobeTestPrimaries, gammaTestCurve(2.2), "Adobe RGB (1998)"))
+		result := adobe.toSRGB(createSolidImage(1, 1, color.RGBA{128, 128, 128, 255}))
+
+		// Adobe RGB and sRGB share the white point, greys only change by the
+		// difference of their tone curves.
+		gray := color.NRGBAModel.Convert(result.At(0, 0)).(color.NRGBA)
+		assertTestColor(t, color.NRGBA{129, 129, 129, 255}, gray)
+	})
+}
+
+func TestOrient(t *testing.T) {
+	src := createGradientImage(3, 2)
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		topLeft     image.Point
+	}{
+		{1, image.Pt(3, 2), image.Pt(0, 0)},
+		{2, image.Pt(3, 2), image.Pt(2, 0)},
+		{3, image.Pt(3, 2), image.Pt(2, 1)},
+		{4, image.Pt(3, 2), image.Pt(0, 1)},
+		{5, image.Pt(2, 3), image.Pt(0, 0)},
+		{6, image.Pt(2, 3), image.Pt(0, 1)},
+		{7, image.Pt(2, 3), image.Pt(2, 1)},
+		{8, image.Pt(2, 3), image.Pt(2, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("orientation %d", tt.orientation), func(t *testing.T) {
+			result := orient(src, tt.orientation)
+
+			assert.Equal(t, tt.size, result.Bounds().Size())
+			assert.Equal(t, src.At(tt.topLeft.X, tt.topLeft.Y), result.At(0, 0))
+		})
+	}
+}
+
+func TestRotateImage(t *testing.T) {
+	src := createGradientImage(4, 2)
+
+	t.Run("right angles are lossless", func(t *testing.T) {
+		result, err := rotateImage(src, dto.Rotate{Angle: 90})
+		assert.NoError(t, err)
+		assert.Equal(t, image.Pt(2, 4), result.Bounds().Size())
+		assert.Equal(t, src.At(0, 1), result.At(0, 0))
+		assert.Equal(t, src.At(0, 0), result.At(1, 0))
+
+		result, err = rotateImage(src, dto.Rotate{Angle: -90})
+		assert.NoError(t, err)
+		assert.Equal(t, src.At(3, 0), result.At(0, 0))
+
+		result, err = rotateImage(src, dto.Rotate{Angle: 540})
+		assert.NoError(t, err)
+		assert.Equal(t, src.At(3, 1), result.At(0, 0))
+
+		result, err = rotateImage(src, dto.Rotate{Angle: 360})
+		assert.NoError(t, err)
+		assert.Equal(t, src, result)
+	})
+
+	t.Run("arbitrary angle expands canvas", func(t *testing.T) {
+		square := createSolidImage(10, 10, color.RGBA{255, 0, 0, 255})
+
+		result, err := rotateImage(square, dto.Rotate{Angle: 45, Background: "#0000ff"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, image.Pt(15, 15), result.Bounds().Size())
+		assert.Equal(t, color.RGBA{0, 0, 255, 255}, result.At(0, 0))
+		assert.Equal(t, color.RGBA{255, 0, 0, 255}, result.At(7, 7))
+	})
+
+	t.Run("invalid background", func(t *testing.T) {
+		_, err := rotateImage(src, dto.Rotate{Angle: 30, Background: "blue"})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestFlipImage(t *testing.T) {
+	src := createGradientImage(4, 2)
+
+	tests := []struct {
+		direction string
+		topLeft   image.Point
+	}{
+		{FlipHorizontal, image.Pt(3, 0)},
+		{FlipVertical, image.Pt(0, 1)},
+		{FlipBoth, image.Pt(3, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.direction, func(t *testing.T) {
+			result, err := flipImage(src, tt.direction)
+
+			assert.NoError(t, err)
+			assert.Equal(t, src.Bounds(), result.Bounds())
+			assert.Equal(t, src.At(tt.topLeft.X, tt.topLeft.Y), result.At(0, 0))
+		})
+	}
+
+	t.Run("invalid direction", func(t *testing.T) {
+		_, err := flipImage(src, "diagonal")
+
+		assert.ErrorIs(t, err, ErrInvalidFlip)
+	})
+}
+
+func TestIsCorrectRotate(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     dto.Rotate
+		expected bool
+	}{
+		{"right angle", dto.Rotate{Angle: 90}, true},
+		{"negative angle", dto.Rotate{Angle: -45.5}, true},
+		{"with background", dto.Rotate{Angle: 30, Background: "#000"}, true},
+		{"too large", dto.Rotate{Angle: 720}, false},
+		{"not a number", dto.Rotate{Angle: math.NaN()}, false},
+		{"invalid background", dto.Rotate{Angle: 30, Background: "black"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := isCorrectRotate(tt.opts)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	defer cleanupTestDirs()
+
+	t.Run("auto orients jpeg", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		data := withEXIFOrientation(encodeTestJPEG(t, createSolidImage(40, 20, color.White)), 6)
+		assert.NoError(t, os.WriteFile(originDirName+"/test.jpeg", data, 0666))
+
+		message := dto.Message{
+			FileName:    "test.jpeg",
+			ContentType: "image/jpeg",
+			Operation: dto.Operation{
+				Task:   Resize,
+				Resize: dto.Resize{Width: 10},
+			},
+		}
+
+		err := service.ProcessImage(message)
+		assert.NoError(t, err)
+
+		config, _, err := image.DecodeConfig(mustOpen(t, processedDirName+"/test.jpeg"))
+		assert.NoError(t, err)
+		assert.Equal(t, 10, config.Width)
+		assert.Equal(t, 20, config.Height)
+	})
+
+	t.Run("auto orient disabled", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		data := withEXIFOrientation(encodeTestJPEG(t, createSolidImage(40, 20, color.White)), 6)
+		assert.NoError(t, os.WriteFile(originDirName+"/test.jpeg", data, 0666))
+
+		autoOrient := false
+		message := dto.Message{
+			FileName:    "test.jpeg",
+			ContentType: "image/jpeg",
+			AutoOrient:  &autoOrient,
+			Operation: dto.Operation{
+				Task: Flip,
+				Flip: FlipHorizontal,
+			},
+		}
+
+		err := service.ProcessImage(message)
+		assert.NoError(t, err)
+
+		config, _, err := image.DecodeConfig(mustOpen(t, processedDirName+"/test.jpeg"))
+		assert.NoError(t, err)
+		assert.Equal(t, 40, config.Width)
+		assert.Equal(t, 20, config.Height)
+	})
+
+	t.Run("redaction runs before resize", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		src := createSolidImage(100, 100, color.White)
+		assert.NoError(t, os.WriteFile(originDirName+"/test.png", encodeTestPNG(t, src), 0666))
+
+		message := dto.Message{
+			FileName:    "test.png",
+			ContentType: "image/png",
+			Operations: []dto.Operation{
+				{Task: Resize, Resize: dto.Resize{Width: 10, Height: 10}},
+				{Task: Redact, Redact: dto.Redact{Method: RedactFill, Regions: []dto.Region{{Width: 50, Height: 100}}}},
+			},
+		}
+
+		err := service.ProcessImage(message)
+		assert.NoError(t, err)
+
+		result, err := png.Decode(mustOpen(t, processedDirName+"/test.png"))
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 10, 10), result.Bounds())
+
+		r, _, _, _ := result.At(2, 5).RGBA()
+		assert.Equal(t, uint32(0), r)
+		r, _, _, _ = result.At(7, 5).RGBA()
+		assert.InDelta(t, 0xffff, r, 0x200)
+	})
+
+	t.Run("redaction of rotated jpeg after explicit auto orient", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		// Stored landscape, shown as a 100x200 portrait.
+		data := withEXIFOrientation(encodeTestJPEG(t, createSolidImage(200, 100, color.White)), 6)
+		assert.NoError(t, os.WriteFile(originDirName+"/test.jpeg", data, 0666))
+
+		message := dto.Message{
+			FileName:    "test.jpeg",
+			ContentType: "image/jpeg",
+			Operations: []dto.Operation{
+				{Task: Resize, Resize: dto.Resize{Width: 50}},
+				{Task: AutoOrient},
+				{Task: Redact, Redact: dto.Redact{Method: RedactFill, Regions: []dto.Region{{X: 0, Y: 150, Width: 100, Height: 50}}}},
+			},
+		}
+
+		assert.NoError(t, service.ProcessImage(message))
+
+		result, err := jpeg.Decode(mustOpen(t, processedDirName+"/test.jpeg"))
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 50, 100), result.Bounds())
+
+		r, _, _, _ := result.At(25, 90).RGBA()
+		assert.Less(t, r, uint32(0x2000), "bottom of the upright image must be redacted")
+		r, _, _, _ = result.At(25, 10).RGBA()
+		assert.Greater(t, r, uint32(0xe000))
+	})
+
+	t.Run("pipeline of operations", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		src := createSolidImage(200, 100, color.White)
+		assert.NoError(t, os.WriteFile(originDirName+"/test.png", encodeTestPNG(t, src), 0666))
+
+		message := dto.Message{
+			FileName:    "test.png",
+			ContentType: "image/png",
+			Operations: []dto.Operation{
+				{Task: Crop, Crop: dto.Crop{Width: 100, Height: 100, Gravity: GravityWest}},
+				{Task: Resize, Resize: dto.Resize{Width: 50, Height: 50}},
+				{Task: Rotate, Rotate: dto.Rotate{Angle: 90}},
+				{Task: Crop, Crop: dto.Crop{X: 0, Y: 0, Width: 50, Height: 20}},
+			},
+		}
+
+		err := service.ProcessImage(message)
+		assert.NoError(t, err)
+
+		config, _, err := image.DecodeConfig(mustOpen(t, processedDirName+"/test.png"))
+		assert.NoError(t, err)
+		assert.Equal(t, 50, config.Width)
+		assert.Equal(t, 20, config.Height)
+	})
+
+	t.Run("failing operation", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		src := createSolidImage(20, 20, color.White)
+		assert.NoError(t, os.WriteFile(originDirName+"/test.png", encodeTestPNG(t, src), 0666))
+
+		message := dto.Message{
+			FileName:    "test.png",
+			ContentType: "image/png",
+			Operations: []dto.Operation{
+				{Task: Flip, Flip: FlipVertical},
+				{Task: Crop, Crop: dto.Crop{Width: 50, Height: 50}},
+			},
+		}
+
+		err := service.ProcessImage(message)
+
+		assert.ErrorIs(t, err, ErrInvalidCrop)
+	})
+
+	t.Run("converts png to jpeg", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		src := createSolidImage(20, 10, color.Transparent)
+		assert.NoError(t, os.WriteFile(originDirName+"/test.png", encodeTestPNG(t, src), 0666))
+
+		message := dto.Message{
+			FileName:    "test.png",
+			ContentType: "image/png",
+			Output:      dto.Output{Format: "jpg", Background: "#ff0000"},
+			Operation:   dto.Operation{Task: Flip, Flip: FlipVertical},
+		}
+
+		err := service.ProcessImage(message)
+		assert.NoError(t, err)
+
+		img, format, err := image.Decode(mustOpen(t, processedDirName+"/test.jpeg"))
+		assert.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+
+		r, g, b, _ := img.At(10, 5).RGBA()
+		assert.Greater(t, r>>8, uint32(240))
+		assert.Less(t, g>>8, uint32(15))
+		assert.Less(t, b>>8, uint32(15))
+	})
+
+	t.Run("variants in different formats", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		testID := uuid.New()
+		src := createGradientImage(30, 20)
+		assert.NoError(t, os.WriteFile(originDirName+"/"+testID.String()+".png", encodeTestPNG(t, src), 0666))
+
+		message := dto.Message{
+			ID:          testID,
+			FileName:    testID.String() + ".png",
+			ContentType: "image/png",
+			Output:      dto.Output{Format: "gif"},
+			Variants: []dto.Variant{
+				{Name: "lossless", Output: dto.Output{Format: "webp"}},
+				{Name: "default"},
+			},
+		}
+
+		err := service.ProcessImage(message)
+		assert.NoError(t, err)
+
+		decoded, err := webp.Decode(mustOpen(t, processedDirName+"/"+testID.String()+"_lossless.webp"))
+		assert.NoError(t, err)
+		assert.Equal(t, color.NRGBAModel.Convert(src.At(7, 3)), decoded.At(7, 3))
+
+		_, format, err := image.DecodeConfig(mustOpen(t, processedDirName+"/"+testID.String()+"_default.gif"))
+		assert.NoError(t, err)
+		assert.Equal(t, "gif", format)
+	})
+
+	t.Run("webp, bmp and tiff input", func(t *testing.T) {
+		src := createGradientImage(30, 20)
+		encoders := map[string]func(io.Writer, image.Image) error{
+			"webp": webpenc.Encode,
+			"bmp":  bmp.Encode,
+			"tiff": func(w io.Writer, img image.Image) error { return tiff.Encode(w, img, nil) },
+		}
+
+		for format, encodeFunc := range encoders {
+			t.Run(format, func(t *testing.T) {
+				service, _, _, _ := createTestService()
+				defer cleanupTestDirs()
+
+				var buf bytes.Buffer
+				assert.NoError(t, encodeFunc(&buf, src))
+				assert.NoError(t, os.WriteFile(originDirName+"/test."+format, buf.Bytes(), 0666))
+
+				message := dto.Message{
+					FileName:    "test." + format,
+					ContentType: "image/" + format,
+					Operation:   dto.Operation{Task: Crop, Crop: dto.Crop{X: 5, Y: 5, Width: 10, Height: 10}},
+				}
+
+				assert.NoError(t, validateMessage(buf.Bytes(), format, message))
+				assert.NoError(t, service.ProcessImage(message))
+
+				result, decodedFormat, err := image.Decode(mustOpen(t, processedDirName+"/test."+format))
+				assert.NoError(t, err)
+				assert.Equal(t, format, decodedFormat)
+				assert.Equal(t, image.Pt(10, 10), result.Bounds().Size())
+				assert.Equal(t, color.NRGBAModel.Convert(src.At(7, 8)), color.NRGBAModel.Convert(result.At(2, 3)))
+			})
+		}
+	})
+
+	t.Run("wide gamut colour profiles", func(t *testing.T) {
+		p3 := testICCProfile(p3TestPrimaries, srgbTestCurve(), "Display P3")
+		orange := color.NRGBA{200, 100, 50, 255}
+		data := embedMetadata("png", encodeTestPNG(t, createSolidImage(8, 8, orange)), metadataBlocks{icc: p3})
+		disabled := false
+
+		tests := []struct {
+			name     string
+			output   dto.Output
+			expected color.NRGBA
+			icc      []byte
+		}{
+			{"converted to srgb", dto.Output{}, p3TestColor(orange), nil},
+			{"preserved", dto.Output{ColorProfile: ColorProfilePreserve, KeepICCProfile: &disabled}, orange, p3},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				service, _, _, _ := createTestService()
+				defer cleanupTestDirs()
+
+				assert.NoError(t, os.WriteFile(originDirName+"/test.png", data, 0666))
+
+				message := dto.Message{
+					FileName:    "test.png",
+					ContentType: "image/png",
+					Output:      tt.output,
+					Operation:   dto.Operation{Task: Flip, Flip: FlipHorizontal},
+				}
+				assert.NoError(t, service.ProcessImage(message))
+
+				processed, err := os.ReadFile(processedDirName + "/test.png")
+				assert.NoError(t, err)
+				assert.Equal(t, tt.icc, findMetadataBlocks(processed, "png").icc)
+
+				result, err := png.Decode(bytes.NewReader(processed))
+				if assert.NoError(t, err) {
+					assertTestColor(t, tt.expected, color.NRGBAModel.Convert(result.At(3, 3)).(color.NRGBA))
+				}
+			})
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		message := createTestImageData()
+
+		err := service.ProcessImage(message)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestOperations(t *testing.T) {
+	t.Run("legacy single task", func(t *testing.T) {
+		var message dto.Message
+		metadata := `{"content_type":"image/jpeg","task":"resize","resize":{"width":100,"height":50}}`
+		assert.NoError(t, json.Unmarshal([]byte(metadata), &message))
+
+		result := operations(message)
+
+		assert.Equal(t, []dto.Operation{{Task: Resize, Resize: dto.Resize{Width: 100, Height: 50}}}, result)
+	})
+
+	t.Run("operations list", func(t *testing.T) {
+		var message dto.Message
+		metadata := `{"content_type":"image/jpeg","task":"resize","operations":[{"task":"flip","flip":"vertical"},{"task":"watermark","watermark_string":"text"}]}`
+		assert.NoError(t, json.Unmarshal([]byte(metadata), &message))
+
+		result := operations(message)
+
+		assert.Equal(t, []dto.Operation{
+			{Task: Flip, Flip: FlipVertical},
+			{Task: Watermark, WatermarkText: "text"},
+		}, result)
+	})
+}
+
+func TestValidateMessage(t *testing.T) {
+	png := encodeTestPNG(t, createSolidImage(200, 100, color.White))
+	rotatedJPEG := withEXIFOrientation(encodeTestJPEG(t, createSolidImage(200, 100, color.White)), 6)
+	wide := encodeTestPNG(t, createSolidImage(webpenc.MaxDimension+1, 1, color.White))
+	noAutoOrient := false
+
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		message  dto.Message
+		expected error
+	}{
+		{
+			name:   "crop within resized image",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Resize, Resize: dto.Resize{Width: 100}},
+				{Task: Crop, Crop: dto.Crop{X: 50, Y: 0, Width: 50, Height: 50}},
+			}},
+		},
+		{
+			name:   "crop outside resized image",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Resize, Resize: dto.Resize{Width: 100}},
+				{Task: Crop, Crop: dto.Crop{X: 0, Y: 0, Width: 100, Height: 100}},
+			}},
+			expected: ErrInvalidCrop,
+		},
+		{
+			name:   "crop after rotation",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Rotate, Rotate: dto.Rotate{Angle: 270}},
+				{Task: Crop, Crop: dto.Crop{Width: 100, Height: 200}},
+			}},
+		},
+		{
+			name:    "crop of auto oriented jpeg",
+			data:    rotatedJPEG,
+			format:  "jpeg",
+			message: dto.Message{Operation: dto.Operation{Task: Crop, Crop: dto.Crop{Width: 100, Height: 200}}},
+		},
+		{
+			name:   "crop of jpeg without auto orientation",
+			data:   rotatedJPEG,
+			format: "jpeg",
+			message: dto.Message{
+				AutoOrient: &noAutoOrient,
+				Operation:  dto.Operation{Task: Crop, Crop: dto.Crop{Width: 100, Height: 200}},
+			},
+			expected: ErrInvalidCrop,
+		},
+		{
+			name:   "explicit auto orient step",
+			data:   rotatedJPEG,
+			format: "jpeg",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Crop, Crop: dto.Crop{Width: 200, Height: 100}},
+				{Task: AutoOrient},
+				{Task: Crop, Crop: dto.Crop{Width: 100, Height: 200}},
+			}},
+		},
+		{
+			name:   "invalid operation in list",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Flip, Flip: FlipVertical},
+				{Task: "vignette"},
+			}},
+			expected: ErrInvalidTask,
+		},
+		{
+			name:     "too many operations",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operations: make([]dto.Operation, maxOperations+1)},
+			expected: ErrInvalidOperations,
+		},
+		{
+			name:    "output format",
+			data:    png,
+			format:  "png",
+			message: dto.Message{Output: dto.Output{Format: "webp", Background: "#000"}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+		},
+		{
+			name:    "styled watermark",
+			data:    png,
+			format:  "png",
+			message: dto.Message{Operation: dto.Operation{Task: Watermark, WatermarkText: "text", Watermark: dto.Watermark{Position: GravityNorthEast, Margin: 5, Color: "#fff", Opacity: 0.8, FontSize: 0.1, Rotation: -45, Font: "go-bold"}}},
+		},
+		{
+			name:     "invalid overlay id",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Watermark, Watermark: dto.Watermark{OverlayID: "logo"}}},
+			expected: ErrInvalidWatermark,
+		},
+		{
+			name:    "tiled watermark",
+			data:    png,
+			format:  "png",
+			message: dto.Message{Operation: dto.Operation{Task: Watermark, WatermarkText: "text", Watermark: dto.Watermark{Mode: WatermarkTiled, Spacing: 40}}},
+		},
+		{
+			name:    "adjust",
+			data:    png,
+			format:  "png",
+			message: dto.Message{Operation: dto.Operation{Task: Adjust, Adjust: dto.Adjust{Grayscale: 1, Contrast: 0.2}}},
+		},
+		{
+			name:   "crop after padding uses the padded size",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Padding, Padding: dto.Padding{Left: 20, Bottom: 10}},
+				{Task: Crop, Crop: dto.Crop{X: 10, Y: 100, Width: 210, Height: 10}},
+			}},
+			expected: nil,
+		},
+		{
+			name:     "caption without text",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Caption, Caption: dto.Caption{Text: "  "}}},
+			expected: ErrInvalidCaption,
+		},
+		{
+			name:   "redaction after explicit auto orient step",
+			data:   rotatedJPEG,
+			format: "jpeg",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: AutoOrient},
+				{Task: Redact, Redact: dto.Redact{Regions: []dto.Region{{X: 0, Y: 150, Width: 100, Height: 50}}}},
+			}},
+		},
+		{
+			name:   "redaction of rotated jpeg without auto orientation",
+			data:   rotatedJPEG,
+			format: "jpeg",
+			message: dto.Message{
+				AutoOrient: &noAutoOrient,
+				Operation:  dto.Operation{Task: Redact, Redact: dto.Redact{Regions: []dto.Region{{X: 0, Y: 150, Width: 100, Height: 50}}}},
+			},
+			expected: ErrInvalidRedact,
+		},
+		{
+			name:   "redacted region outside the image",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operation: dto.Operation{
+				Task:   Redact,
+				Redact: dto.Redact{Regions: []dto.Region{{X: 150, Y: 5, Width: 100, Height: 10}}},
+			}},
+			expected: ErrInvalidRedact,
+		},
+		{
+			name:     "blur without sigma",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Blur}},
+			expected: ErrInvalidBlur,
+		},
+		{
+			name:     "convolve with 4x4 kernel",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Convolve, Convolve: dto.Convolve{Kernel: make([]float64, 16)}}},
+			expected: ErrInvalidConvolve,
+		},
+		{
+			name:     "adjust without options",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Adjust}},
+			expected: ErrInvalidAdjust,
+		},
+		{
+			name:     "unknown watermark font",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Watermark, WatermarkText: "text", Watermark: dto.Watermark{Font: "comic-sans"}}},
+			expected: ErrInvalidWatermark,
+		},
+		{
+			name:     "unknown watermark mode",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Watermark, WatermarkText: "text", Watermark: dto.Watermark{Mode: "grid"}}},
+			expected: ErrInvalidWatermark,
+		},
+		{
+			name:     "unknown watermark position",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Watermark, WatermarkText: "text", Watermark: dto.Watermark{Position: "top"}}},
+			expected: ErrInvalidWatermark,
+		},
+		{
+			name:     "watermark opacity out of range",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Watermark, WatermarkText: "text", Watermark: dto.Watermark{Opacity: 1.5}}},
+			expected: ErrInvalidWatermark,
+		},
+		{
+			name:   "padding grows the image beyond the limit",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Resize, Resize: dto.Resize{Width: 9000}},
+				{Task: Padding, Padding: dto.Padding{Left: 1000}},
+				{Task: Border, Border: dto.Border{Width: 1000}},
+			}},
+			expected: ErrInvalidOperations,
+		},
+		{
+			name:   "growth within the limit",
+			data:   png,
+			format: "png",
+			message: dto.Message{Operations: []dto.Operation{
+				{Task: Resize, Resize: dto.Resize{Width: 9000}},
+				{Task: Padding, Padding: dto.Padding{Left: 500, Right: 500}},
+			}},
+		},
+		{
+			name:     "rotation grows the image beyond the limit",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Operations: []dto.Operation{{Task: Resize, Resize: dto.Resize{Width: 10000}}, {Task: Rotate, Rotate: dto.Rotate{Angle: 45}}}},
+			expected: ErrInvalidOperations,
+		},
+		{
+			name:     "unreadable image",
+			data:     []byte("fake image data"),
+			format:   "png",
+			message:  dto.Message{Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidImage,
+		},
+		{
+			name:     "webp output beyond its limit",
+			data:     wide,
+			format:   "png",
+			message:  dto.Message{Output: dto.Output{Format: "webp"}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidImage,
+		},
+		{
+			name:   "webp variant beyond its limit",
+			data:   wide,
+			format: "png",
+			message: dto.Message{Variants: []dto.Variant{
+				{Name: "lossless"},
+				{Name: "web", Output: dto.Output{Format: "webp"}},
+			}},
+			expected: ErrInvalidImage,
+		},
+		{
+			name:    "webp output of downscaled image",
+			data:    wide,
+			format:  "png",
+			message: dto.Message{Output: dto.Output{Format: "webp"}, Operation: dto.Operation{Task: Resize, Resize: dto.Resize{Width: 1000}}},
+		},
+		{
+			name:    "png output beyond the webp limit",
+			data:    wide,
+			format:  "png",
+			message: dto.Message{Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+		},
+		{
+			name:     "unsupported output format",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Output: dto.Output{Format: "avif"}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidOutput,
+		},
+		{
+			name:     "jpeg quality out of range",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Output: dto.Output{JPEGQuality: 101}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidOutput,
+		},
+		{
+			name:     "unknown png compression",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Output: dto.Output{PNGCompression: "maximum"}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidOutput,
+		},
+		{
+			name:     "too few gif colors",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Output: dto.Output{GIFColors: 1}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidOutput,
+		},
+		{
+			name:     "unknown gif quantizer",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Output: dto.Output{GIFQuantizer: "octree"}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidOutput,
+		},
+		{
+			name:     "unknown colour profile",
+			data:     png,
+			format:   "png",
+			message:  dto.Message{Output: dto.Output{ColorProfile: "adobe"}, Operation: dto.Operation{Task: Flip, Flip: FlipVertical}},
+			expected: ErrInvalidOutput,
+		},
+		{
+			name:   "invalid variant background",
+			data:   png,
+			format: "png",
+			message: dto.Message{Variants: []dto.Variant{
+				{Name: "small", Output: dto.Output{Format: "jpeg", Background: "white"}},
+			}},
+			expected: ErrInvalidOutput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMessage(tt.data, tt.format, tt.message)
+			assert.Equal(t, tt.expected, err)
+		})
+	}
+}
+
+func TestResolveOutput(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name     string
+		job      dto.Output
+		variant  dto.Output
+		expected dto.Output
+	}{
+		{"defaults", dto.Output{}, dto.Output{}, dto.Output{Format: "png", Background: defaultBackground}},
+		{"job options", dto.Output{Format: "jpg", Background: "#000"}, dto.Output{}, dto.Output{Format: "jpeg", Background: "#000"}},
+		{"variant overrides job", dto.Output{Format: "gif", Background: "#000"}, dto.Output{Format: "webp"}, dto.Output{Format: "webp", Background: "#000"}},
+		{"variant overrides switches", dto.Output{StripMetadata: &enabled, KeepCopyright: &enabled}, dto.Output{StripMetadata: &disabled}, dto.Output{Format: "png", Background: defaultBackground, StripMetadata: &disabled, KeepCopyright: &enabled}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := resolveOutput(tt.job, tt.variant, "png")
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	t.Run("transparent image", func(t *testing.T) {
+		src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+		src.SetNRGBA(0, 0, color.NRGBA{0, 0, 255, 255})
+		src.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 0})
+
+		result, err := flatten(src, "#00ff00")
+		assert.NoError(t, err)
+
+		assert.Equal(t, color.RGBA{0, 0, 255, 255}, result.At(0, 0))
+		assert.Equal(t, color.RGBA{0, 255, 0, 255}, result.At(1, 0))
+	})
+
+	t.Run("opaque image", func(t *testing.T) {
+		src := createSolidImage(2, 2, color.White)
+
+		result, err := flatten(src, "#00ff00")
+		assert.NoError(t, err)
+		assert.Same(t, src, result)
+	})
+}
+
+func TestSaveImage_EncoderOptions(t *testing.T) {
+	defer cleanupTestDirs()
+
+	fileSize := func(t *testing.T, name string, opts dto.Output, img image.Image) int64 {
+		assert.NoError(t, saveImage(name, opts, still(img)))
+		info, err := os.Stat(processedDirName + "/" + name)
+		assert.NoError(t, err)
+		return info.Size()
+	}
+
+	t.Run("jpeg quality", func(t *testing.T) {
+		createTestService()
+		defer cleanupTestDirs()
+
+		img := createGradientImage(200, 200)
+		low := fileSize(t, "low.jpeg", dto.Output{Format: "jpeg", JPEGQuality: 10}, img)
+		high := fileSize(t, "high.jpeg", dto.Output{Format: "jpeg", JPEGQuality: 95}, img)
+
+		assert.Less(t, low, high)
+	})
+
+	t.Run("png compression", func(t *testing.T) {
+		createTestService()
+		defer cleanupTestDirs()
+
+		img := createGradientImage(200, 200)
+		none := fileSize(t, "none.png", dto.Output{Format: "png", PNGCompression: CompressionNone}, img)
+		best := fileSize(t, "best.png", dto.Output{Format: "png", PNGCompression: CompressionBest}, img)
+
+		assert.Less(t, best, none)
+	})
+
+	t.Run("gif palette", func(t *testing.T) {
+		createTestService()
+		defer cleanupTestDirs()
+
+		dither := false
+		img := createGradientImage(64, 64)
+		opts := dto.Output{Format: "gif", GIFColors: 8, GIFQuantizer: QuantizerMedianCut, GIFDither: &dither}
+		assert.NoError(t, saveImage("palette.gif", opts, still(img)))
+
+		decoded, err := gif.Decode(mustOpen(t, processedDirName+"/palette.gif"))
+		assert.NoError(t, err)
+
+		paletted, ok := decoded.(*image.Paletted)
+		assert.True(t, ok)
+		assert.LessOrEqual(t, len(paletted.Palette), 8)
+	})
+
+	t.Run("gif dither by default", func(t *testing.T) {
+		// Encoder defaults of a config without the gif_dither key.
+		defaults := dto.Output{GIFColors: 64}
+
+		assert.Nil(t, gifOptions(mergeOutput(defaults, dto.Output{Format: "gif"})).Drawer)
+
+		dither := false
+		assert.Equal(t, draw.Src, gifOptions(mergeOutput(defaults, dto.Output{GIFDither: &dither})).Drawer)
+	})
+}
+
+func TestMedianCutQuantizer(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 5 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	t.Run("two colors", func(t *testing.T) {
+		result := medianCutQuantizer{}.Quantize(make(color.Palette, 0, 2), img)
+
+		assert.ElementsMatch(t, color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}, result)
+	})
+
+	t.Run("more colors than the image has", func(t *testing.T) {
+		result := medianCutQuantizer{}.Quantize(make(color.Palette, 0, 16), img)
+
+		assert.Len(t, result, 2)
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		gradient := createGradientImage(50, 50)
+		first := medianCutQuantizer{}.Quantize(make(color.Palette, 0, 16), gradient)
+		second := medianCutQuantizer{}.Quantize(make(color.Palette, 0, 16), gradient)
+
+		assert.Len(t, first, 16)
+		assert.Equal(t, first, second)
+	})
+}
+
+// encodeTestAnimation builds a 20x10 three-frame GIF: a red background, a
+// blue square over its left half and a green square that is disposed to the
+// previous frame.
+func encodeTestAnimation(t *testing.T) []byte {
+	pal := color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}}
+
+	background := image.NewPaletted(image.Rect(0, 0, 20, 10), pal)
+	blue := image.NewPaletted(image.Rect(0, 0, 10, 10), pal)
+	for i := range blue.Pix {
+		blue.Pix[i] = 1
+	}
+	green := image.NewPaletted(image.Rect(10, 0, 20, 10), pal)
+	for i := range green.Pix {
+		green.Pix[i] = 2
+	}
+
+	anim := &gif.GIF{
+		Image:     []*image.Paletted{background, blue, green},
+		Delay:     []int{10, 20, 30},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalPrevious},
+		LoopCount: 3,
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("could not encode test animation: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeAnimation(t *testing.T) {
+	pic, err := decodeAnimation(bytes.NewReader(encodeTestAnimation(t)))
+	assert.NoError(t, err)
+
+	assert.Len(t, pic.frames, 3)
+	assert.Equal(t, []int{10, 20, 30}, pic.delays)
+	assert.Equal(t, []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalPrevious}, pic.disposals)
+	assert.Equal(t, 3, pic.loopCount)
+
+	red, blue, green := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}
+	for _, frame := range pic.frames {
+		assert.Equal(t, image.Rect(0, 0, 20, 10), frame.Bounds())
+	}
+	assert.Equal(t, []color.Color{red, red}, []color.Color{pic.frames[0].At(2, 2), pic.frames[0].At(15, 2)})
+	assert.Equal(t, []color.Color{blue, red}, []color.Color{pic.frames[1].At(2, 2), pic.frames[1].At(15, 2)})
+	assert.Equal(t, []color.Color{blue, green}, []color.Color{pic.frames[2].At(2, 2), pic.frames[2].At(15, 2)})
+}
+
+func TestProcessImage_Animation(t *testing.T) {
+	defer cleanupTestDirs()
+
+	tests := []struct {
+		name       string
+		operations []dto.Operation
+		size       image.Point
+	}{
+		{"resize", []dto.Operation{{Task: Resize, Resize: dto.Resize{Width: 10}}}, image.Pt(10, 5)},
+		{"thumbnail", []dto.Operation{{Task: Thumbnail}}, image.Pt(200, 200)},
+		{"crop", []dto.Operation{{Task: Crop, Crop: dto.Crop{X: 5, Y: 0, Width: 10, Height: 10}}}, image.Pt(10, 10)},
+		{"watermark", []dto.Operation{{Task: Watermark, WatermarkText: "text"}}, image.Pt(20, 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _, _, _ := createTestService()
+			defer cleanupTestDirs()
+
+			assert.NoError(t, os.WriteFile(originDirName+"/test.gif", encodeTestAnimation(t), 0666))
+
+			message := dto.Message{
+				FileName:    "test.gif",
+				ContentType: "image/gif",
+				Operations:  tt.operations,
+			}
+
+			err := service.ProcessImage(message)
+			assert.NoError(t, err)
+
+			result, err := gif.DecodeAll(mustOpen(t, processedDirName+"/test.gif"))
+			assert.NoError(t, err)
+			assert.Len(t, result.Image, 3)
+			assert.Equal(t, []int{10, 20, 30}, result.Delay)
+			assert.Equal(t, []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalPrevious}, result.Disposal)
+			assert.Equal(t, 3, result.LoopCount)
+			for _, frame := range result.Image {
+				assert.Equal(t, tt.size, frame.Bounds().Size())
+			}
+		})
+	}
+
+	t.Run("crop keeps composited frames", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		assert.NoError(t, os.WriteFile(originDirName+"/test.gif", encodeTestAnimation(t), 0666))
+
+		message := dto.Message{
+			FileName:    "test.gif",
+			ContentType: "image/gif",
+			Operation:   dto.Operation{Task: Crop, Crop: dto.Crop{X: 5, Y: 0, Width: 10, Height: 10}},
+		}
+
+		assert.NoError(t, service.ProcessImage(message))
+
+		result, err := gif.DecodeAll(mustOpen(t, processedDirName+"/test.gif"))
+		assert.NoError(t, err)
+
+		r, g, b, _ := result.Image[2].At(2, 5).RGBA()
+		assert.Equal(t, []uint32{0, 0, 0xffff}, []uint32{r, g, b})
+		r, g, b, _ = result.Image[2].At(7, 5).RGBA()
+		assert.Equal(t, []uint32{0, 0xffff, 0}, []uint32{r, g, b})
+	})
+
+	t.Run("still output format", func(t *testing.T) {
+		service, _, _, _ := createTestService()
+		defer cleanupTestDirs()
+
+		assert.NoError(t, os.WriteFile(originDirName+"/test.gif", encodeTestAnimation(t), 0666))
+
+		message := dto.Message{
+			FileName:    "test.gif",
+			ContentType: "image/gif",
+			Output:      dto.Output{Format: "png"},
+			Operation:   dto.Operation{Task: Flip, Flip: FlipHorizontal},
+		}
+
+		assert.NoError(t, service.ProcessImage(message))
+
+		result, err := png.Decode(mustOpen(t, processedDirName+"/test.png"))
+		assert.NoError(t, err)
+		assert.Equal(t, color.NRGBA{255, 0, 0, 255}, color.NRGBAModel.Convert(result.At(2, 2)))
+	})
+
+	smartTests := []struct {
+		name      string
+		operation dto.Operation
+	}{
+		{"smart crop", dto.Operation{Task: Crop, Crop: dto.Crop{Width: 100, Height: 100, Gravity: GravitySmart}}},
+		{"thumbnail", dto.Operation{Task: Thumbnail}},
+	}
+
+	for _, tt := range smartTests {
+		t.Run(tt.name+" of moving subject", func(t *testing.T) {
+			service, _, _, _ := createTestService()
+			defer cleanupTestDirs()
+
+			// The subject is in a different place in every frame, far enough
+			// apart that a single region holds it in one frame only.
+			data := encodeMovingSubject(t, []image.Rectangle{
+				image.Rect(40, 40, 60, 60),
+				image.Rect(190, 40, 210, 60),
+				image.Rect(340, 40, 360, 60),
+			})
+			assert.NoError(t, os.WriteFile(originDirName+"/test.gif", data, 0666))
+
+			message := dto.Message{
+				FileName:    "test.gif",
+				ContentType: "image/gif",
+				Operation:   tt.operation,
+			}
+
+			assert.NoError(t, service.ProcessImage(message))
+
+			result, err := gif.DecodeAll(mustOpen(t, processedDirName+"/test.gif"))
+			assert.NoError(t, err)
+
+			// Every frame is cut at the same place, so the subject is only
+			// seen while it passes through that region.
+			visible := 0
+			for _, frame := range result.Image {
+				if hasSubject(frame) {
+					visible++
+				}
+			}
+			assert.Len(t, result.Image, 3)
+			assert.Equal(t, 1, visible)
+		})
+	}
+}
+
+// encodeMovingSubject encodes a 400x100 animation with a checkered subject at
+// the given place of every frame.
+func encodeMovingSubject(t *testing.T, subjects []image.Rectangle) []byte {
+	anim := &gif.GIF{}
+	for _, subject := range subjects {
+		scene := smartScene(400, 100, subject)
+		frame := image.NewPaletted(scene.Bounds(), palette.Plan9)
+		draw.Draw(frame, frame.Bounds(), scene, image.Point{}, draw.Src)
+
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("could not encode test animation: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// hasSubject reports whether the yellow squares of smartScene are visible.
+func hasSubject(img image.Image) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.R > 200 && c.G > 160 && c.B < 120 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestIsCorrectAdjust(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     dto.Adjust
+		expected bool
+	}{
+		{name: "brightness", opts: dto.Adjust{Brightness: -0.5}, expected: true},
+		{name: "all options", opts: dto.Adjust{Brightness: 1, Contrast: -1, Gamma: 2.2, Saturation: 0.5, Hue: -180, Grayscale: 1, Sepia: 0.3, Invert: 1}, expected: true},
+		{name: "nothing set", opts: dto.Adjust{}, expected: false},
+		{name: "brightness out of range", opts: dto.Adjust{Brightness: 1.5}, expected: false},
+		{name: "negative gamma", opts: dto.Adjust{Gamma: -1}, expected: false},
+		{name: "hue out of range", opts: dto.Adjust{Hue: 270}, expected: false},
+		{name: "sepia out of range", opts: dto.Adjust{Sepia: 2}, expected: false},
+		{name: "not a number", opts: dto.Adjust{Contrast: math.NaN()}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isCorrectAdjust(tt.opts))
+		})
+	}
+}
 
-			if tt.expectError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-				assert.Equal(t, tt.expected, result)
-			}
+func TestAdjustImage(t *testing.T) {
+	pixel := func(c color.NRGBA, opts dto.Adjust) color.NRGBA {
+		src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		src.SetNRGBA(0, 0, c)
+		return adjustImage(src, opts).(*image.NRGBA).NRGBAAt(0, 0)
+	}
+
+	tests := []struct {
+		name     string
+		src      color.NRGBA
+		opts     dto.Adjust
+		expected color.NRGBA
+	}{
+		{name: "invert", src: color.NRGBA{10, 20, 30, 255}, opts: dto.Adjust{Invert: 1}, expected: color.NRGBA{245, 235, 225, 255}},
+		{name: "half invert", src: color.NRGBA{0, 255, 100, 255}, opts: dto.Adjust{Invert: 0.5}, expected: color.NRGBA{128, 128, 128, 255}},
+		{name: "grayscale", src: color.NRGBA{255, 0, 0, 255}, opts: dto.Adjust{Grayscale: 1}, expected: color.NRGBA{54, 54, 54, 255}},
+		{name: "sepia of white", src: color.NRGBA{255, 255, 255, 255}, opts: dto.Adjust{Sepia: 1}, expected: color.NRGBA{255, 255, 239, 255}},
+		{name: "brightness", src: color.NRGBA{100, 200, 50, 255}, opts: dto.Adjust{Brightness: -0.5}, expected: color.NRGBA{50, 100, 25, 255}},
+		{name: "contrast", src: color.NRGBA{64, 128, 192, 255}, opts: dto.Adjust{Contrast: 1}, expected: color.NRGBA{0, 129, 255, 255}},
+		{name: "gamma", src: color.NRGBA{64, 0, 255, 255}, opts: dto.Adjust{Gamma: 2}, expected: color.NRGBA{128, 0, 255, 255}},
+		{name: "desaturate", src: color.NRGBA{0, 0, 255, 255}, opts: dto.Adjust{Saturation: -1}, expected: color.NRGBA{18, 18, 18, 255}},
+		{name: "hue keeps gray", src: color.NRGBA{90, 90, 90, 255}, opts: dto.Adjust{Hue: 120}, expected: color.NRGBA{90, 90, 90, 255}},
+		{name: "alpha is kept", src: color.NRGBA{10, 20, 30, 100}, opts: dto.Adjust{Invert: 1}, expected: color.NRGBA{245, 235, 225, 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, pixel(tt.src, tt.opts))
 		})
 	}
 }
 
-func TestIsCorrectTask(t *testing.T) {
+// goldenSource is a hue sweep from left to right fading to white at the top,
+// with a semi-transparent band at the bottom.
+func goldenSource() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 48, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 48; x++ {
+			h := float64(x) / 48 * 6
+			f := h - math.Floor(h)
+			rgb := [6][3]float64{{1, f, 0}, {1 - f, 1, 0}, {0, 1, f}, {0, 1 - f, 1}, {f, 0, 1}, {1, 0, 1 - f}}[int(h)]
+
+			white := float64(y) / 24
+			c := color.NRGBA{A: 255}
+			if y >= 20 {
+				c.A = 128
+			}
+			c.R = uint8(math.Round((rgb[0] + (1-rgb[0])*white*0.5) * 255))
+			c.G = uint8(math.Round((rgb[1] + (1-rgb[1])*white*0.5) * 255))
+			c.B = uint8(math.Round((rgb[2] + (1-rgb[2])*white*0.5) * 255))
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// assertGolden compares the image with testdata/<name>.png, run the tests
+// with -update to regenerate the golden images.
+func assertGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".png")
+	if *updateGolden {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, encodeTestPNG(t, img), 0644))
+		return
+	}
+
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+
+	golden, err := png.Decode(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	bounds := img.Bounds()
+	if !assert.Equal(t, golden.Bounds().Size(), bounds.Size()) {
+		return
+	}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			want := color.NRGBAModel.Convert(golden.At(golden.Bounds().Min.X+x, golden.Bounds().Min.Y+y))
+			got := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y))
+			if !assert.Equal(t, want, got, "pixel (%d, %d)", x, y) {
+				return
+			}
+		}
+	}
+}
+
+func TestAdjustImage_Golden(t *testing.T) {
+	src := goldenSource()
+
 	tests := []struct {
-		task     string
-		expected bool
+		name string
+		opts dto.Adjust
 	}{
-		{"resize", true},
-		{"watermark", true},
-		{"minature generating", true},
-		{"invalid_task", false},
-		{"", false},
+		{name: "grayscale", opts: dto.Adjust{Grayscale: 1}},
+		{name: "sepia", opts: dto.Adjust{Sepia: 1}},
+		{name: "invert", opts: dto.Adjust{Invert: 1}},
+		{name: "brightness", opts: dto.Adjust{Brightness: 0.3}},
+		{name: "contrast", opts: dto.Adjust{Contrast: -0.5}},
+		{name: "saturation", opts: dto.Adjust{Saturation: -0.6}},
+		{name: "gamma", opts: dto.Adjust{Gamma: 2.2}},
+		{name: "hue", opts: dto.Adjust{Hue: 90}},
+		{name: "combined", opts: dto.Adjust{Brightness: -0.1, Contrast: 0.2, Saturation: 0.3, Hue: -30, Sepia: 0.4}},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.task, func(t *testing.T) {
-			result := isCorrectTask(tt.task)
-			assert.Equal(t, tt.expected, result)
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "adjust/"+tt.name, adjustImage(src, tt.opts))
 		})
 	}
 }
 
-func TestAddWatermark(t *testing.T) {
+func TestIsCorrectFilter(t *testing.T) {
+	identity := []float64{0, 0, 0, 0, 1, 0, 0, 0, 0}
+
+	assert.True(t, isCorrectBlur(dto.Blur{Sigma: 2}))
+	assert.True(t, isCorrectBlur(dto.Blur{Type: BlurBox, Radius: 3}))
+	assert.False(t, isCorrectBlur(dto.Blur{}))
+	assert.False(t, isCorrectBlur(dto.Blur{Type: BlurBox, Sigma: 2}))
+	assert.False(t, isCorrectBlur(dto.Blur{Sigma: maxBlurSigma + 1}))
+	assert.False(t, isCorrectBlur(dto.Blur{Type: "motion", Sigma: 2}))
+
+	assert.True(t, isCorrectSharpen(dto.Sharpen{}))
+	assert.True(t, isCorrectSharpen(dto.Sharpen{Sigma: 1.5, Amount: 2, Threshold: 10}))
+	assert.False(t, isCorrectSharpen(dto.Sharpen{Amount: -1}))
+	assert.False(t, isCorrectSharpen(dto.Sharpen{Threshold: 256}))
+
+	assert.True(t, isCorrectConvolve(dto.Convolve{Kernel: identity}))
+	assert.True(t, isCorrectConvolve(dto.Convolve{Kernel: make([]float64, 25), Bias: 128}))
+	assert.False(t, isCorrectConvolve(dto.Convolve{Kernel: make([]float64, 16)}))
+	assert.False(t, isCorrectConvolve(dto.Convolve{Kernel: identity, Bias: 300}))
+	assert.False(t, isCorrectConvolve(dto.Convolve{Kernel: []float64{0, 0, 0, 0, math.NaN(), 0, 0, 0, 0}}))
+}
+
+func TestBlurImage(t *testing.T) {
+	t.Run("uniform image is unchanged", func(t *testing.T) {
+		src := createSolidImage(20, 10, color.RGBA{40, 80, 120, 255})
+		for _, opts := range []dto.Blur{{Sigma: 3}, {Type: BlurBox, Radius: 4}} {
+			result := blurImage(src, opts).(*image.RGBA)
+			assert.Equal(t, src.Pix, result.Pix)
+		}
+	})
+
+	t.Run("box blur averages the neighbourhood", func(t *testing.T) {
+		src := createSolidImage(5, 5, color.Black)
+		src.Set(2, 2, color.White)
+
+		result := blurImage(src, dto.Blur{Type: BlurBox, Radius: 1}).(*image.RGBA)
+
+		assert.Equal(t, color.RGBA{28, 28, 28, 255}, result.RGBAAt(1, 1))
+		assert.Equal(t, color.RGBA{28, 28, 28, 255}, result.RGBAAt(2, 2))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, result.RGBAAt(0, 0))
+	})
+
+	t.Run("gaussian kernel is normalized", func(t *testing.T) {
+		kernel := gaussianKernel(2.5)
+		sum := 0.0
+		for _, weight := range kernel {
+			sum += weight
+		}
+		assert.InDelta(t, 1, sum, 1e-9)
+		assert.Len(t, kernel, 2*8+1)
+	})
+}
+
+func TestSharpenImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 10, 1))
+	for x := 0; x < 10; x++ {
+		v := uint8(100)
+		if x >= 5 {
+			v = 150
+		}
+		src.SetRGBA(x, 0, color.RGBA{v, v, v, 255})
+	}
+
+	result := sharpenImage(src, dto.Sharpen{Amount: 1}).(*image.RGBA)
+
+	// Unsharp masking overshoots on both sides of the edge.
+	assert.Less(t, result.RGBAAt(4, 0).R, uint8(100))
+	assert.Greater(t, result.RGBAAt(5, 0).R, uint8(150))
+	assert.Equal(t, uint8(100), result.RGBAAt(0, 0).R)
+	assert.Equal(t, uint8(150), result.RGBAAt(9, 0).R)
+
+	thresholded := sharpenImage(src, dto.Sharpen{Amount: 1, Threshold: 100}).(*image.RGBA)
+	assert.Equal(t, src.Pix, thresholded.Pix)
+}
+
+func TestConvolveImage(t *testing.T) {
+	src := goldenSource()
+
+	t.Run("identity", func(t *testing.T) {
+		result := convolveImage(src, dto.Convolve{Kernel: []float64{0, 0, 0, 0, 1, 0, 0, 0, 0}}).(*image.NRGBA)
+		assert.Equal(t, src.Pix, result.Pix)
+	})
+
+	t.Run("edge detection of uniform image", func(t *testing.T) {
+		uniform := createSolidImage(8, 8, color.RGBA{90, 90, 90, 255})
+		kernel := []float64{-1, -1, -1, -1, 8, -1, -1, -1, -1}
+
+		result := convolveImage(uniform, dto.Convolve{Kernel: kernel, Bias: 10}).(*image.NRGBA)
+
+		assert.Equal(t, color.NRGBA{10, 10, 10, 255}, result.NRGBAAt(4, 4))
+		assert.Equal(t, color.NRGBA{10, 10, 10, 255}, result.NRGBAAt(0, 7))
+	})
+}
+
+func TestFilters_Golden(t *testing.T) {
+	src := goldenSource()
+
+	tests := []struct {
+		name      string
+		operation dto.Operation
+	}{
+		{name: "blur_gaussian", operation: dto.Operation{Task: Blur, Blur: dto.Blur{Sigma: 1.5}}},
+		{name: "blur_box", operation: dto.Operation{Task: Blur, Blur: dto.Blur{Type: BlurBox, Radius: 2}}},
+		{name: "sharpen", operation: dto.Operation{Task: Sharpen, Sharpen: dto.Sharpen{Sigma: 1, Amount: 2}}},
+		{name: "emboss", operation: dto.Operation{Task: Convolve, Convolve: dto.Convolve{Kernel: []float64{-2, -1, 0, -1, 1, 1, 0, 1, 2}}}},
+		{name: "edges_5x5", operation: dto.Operation{Task: Convolve, Convolve: dto.Convolve{
+			Kernel: []float64{
+				0, 0, -1, 0, 0,
+				0, -1, -2, -1, 0,
+				-1, -2, 16, -2, -1,
+				0, -1, -2, -1, 0,
+				0, 0, -1, 0, 0,
+			},
+			Bias: 32,
+		}}},
+	}
+
+	service, _, _, _ := createTestService()
 	defer cleanupTestDirs()
 
-	t.Run("successful watermark", func(t *testing.T) {
-		service, _, _, _ := createTestService()
-		defer cleanupTestDirs()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := service.applyOperation(src, tt.operation, 1)
+			assert.NoError(t, err)
+			assertGolden(t, "filter/"+tt.name, result)
+		})
+	}
+}
 
-		testImage := image.NewRGBA(image.Rect(0, 0, 100, 100))
-		for y := 0; y < 100; y++ {
-			for x := 0; x < 100; x++ {
-				testImage.Set(x, y, color.RGBA{255, 255, 255, 255})
+func TestIsCorrectRedact(t *testing.T) {
+	region := []dto.Region{{X: 10, Y: 10, Width: 20, Height: 20}}
+
+	assert.True(t, isCorrectRedact(dto.Redact{Regions: region}))
+	assert.True(t, isCorrectRedact(dto.Redact{Regions: region, Method: RedactBlur, Sigma: 20}))
+	assert.True(t, isCorrectRedact(dto.Redact{Regions: region, Method: RedactFill, Color: "#00ff00"}))
+	assert.False(t, isCorrectRedact(dto.Redact{}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: region, Method: "erase"}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: []dto.Region{{X: -1, Width: 10, Height: 10}}}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: []dto.Region{{Width: 10}}}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: region, BlockSize: -4}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: region, BlockSize: 1}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: region, BlockSize: minRedactBlockSize - 1}))
+	assert.True(t, isCorrectRedact(dto.Redact{Regions: region, BlockSize: minRedactBlockSize}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: region, Method: RedactBlur, Sigma: 0.01}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: region, Method: RedactBlur, Sigma: 1.9}))
+	assert.True(t, isCorrectRedact(dto.Redact{Regions: region, Method: RedactBlur, Sigma: minRedactSigma}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: region, Method: RedactFill, Color: "green"}))
+	assert.False(t, isCorrectRedact(dto.Redact{Regions: make([]dto.Region, maxRedactRegions+1)}))
+
+	assert.NoError(t, checkRedactRegions(image.Pt(30, 30), dto.Redact{Regions: region}))
+	assert.ErrorIs(t, checkRedactRegions(image.Pt(29, 30), dto.Redact{Regions: region}), ErrInvalidRedact)
+}
+
+func TestRedactImage(t *testing.T) {
+	// Left half black, right half white.
+	src := createSolidImage(8, 4, color.White)
+	draw.Draw(src, image.Rect(0, 0, 4, 4), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	original := append([]uint8(nil), src.Pix...)
+
+	t.Run("pixelate averages blocks", func(t *testing.T) {
+		result, err := redactImage(src, dto.Redact{BlockSize: 4, Regions: []dto.Region{{X: 2, Y: 0, Width: 4, Height: 4}}})
+		assert.NoError(t, err)
+
+		rgba := result.(*image.RGBA)
+		assert.Equal(t, color.RGBA{128, 128, 128, 255}, rgba.RGBAAt(2, 0))
+		assert.Equal(t, color.RGBA{128, 128, 128, 255}, rgba.RGBAAt(5, 3))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(1, 0))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(6, 0))
+	})
+
+	t.Run("fill", func(t *testing.T) {
+		result, err := redactImage(src, dto.Redact{Method: RedactFill, Color: "#ff0000", Regions: []dto.Region{{X: 6, Y: 1, Width: 2, Height: 2}}})
+		assert.NoError(t, err)
+
+		rgba := result.(*image.RGBA)
+		assert.Equal(t, color.RGBA{255, 0, 0, 255}, rgba.RGBAAt(7, 2))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(7, 0))
+	})
+
+	t.Run("blur stays inside the region", func(t *testing.T) {
+		result, err := redactImage(src, dto.Redact{Method: RedactBlur, Regions: []dto.Region{{X: 0, Y: 0, Width: 6, Height: 4}}})
+		assert.NoError(t, err)
+
+		rgba := result.(*image.RGBA)
+		assert.NotEqual(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(3, 2))
+		assert.NotEqual(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(5, 2))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(6, 2))
+	})
+
+	assert.Equal(t, original, src.Pix, "source image must not be modified")
+}
+
+func TestRedactionsFirst(t *testing.T) {
+	ops := []dto.Operation{
+		{Task: Resize},
+		{Task: Redact, Redact: dto.Redact{Method: RedactBlur}},
+		{Task: Flip},
+		{Task: Redact, Redact: dto.Redact{Method: RedactFill}},
+	}
+
+	ordered := redactionsFirst(ops)
+
+	var tasks []string
+	for _, operation := range ordered {
+		tasks = append(tasks, operation.Task)
+	}
+	assert.Equal(t, []string{Redact, Redact, Resize, Flip}, tasks)
+	assert.Equal(t, RedactBlur, ordered[0].Redact.Method)
+	assert.Equal(t, Resize, ops[0].Task)
+
+	t.Run("explicit auto orient goes first", func(t *testing.T) {
+		ops := []dto.Operation{
+			{Task: Resize},
+			{Task: Redact},
+			{Task: AutoOrient},
+			{Task: Flip},
+		}
+
+		var tasks []string
+		for _, operation := range redactionsFirst(ops) {
+			tasks = append(tasks, operation.Task)
+		}
+		assert.Equal(t, []string{AutoOrient, Redact, Resize, Flip}, tasks)
+	})
+}
+
+func TestIsCorrectCanvasOptions(t *testing.T) {
+	assert.True(t, isCorrectBorder(dto.Border{Width: 5}))
+	assert.True(t, isCorrectBorder(dto.Border{Width: 5, Color: "#ff0000"}))
+	assert.False(t, isCorrectBorder(dto.Border{}))
+	assert.False(t, isCorrectBorder(dto.Border{Width: maxBorderWidth + 1}))
+	assert.False(t, isCorrectBorder(dto.Border{Width: 5, Color: "red"}))
+
+	assert.True(t, isCorrectPadding(dto.Padding{Top: 10}))
+	assert.False(t, isCorrectPadding(dto.Padding{}))
+	assert.False(t, isCorrectPadding(dto.Padding{Top: 10, Left: -1}))
+
+	assert.True(t, isCorrectRoundCorners(dto.RoundCorners{Radius: 20}))
+	assert.False(t, isCorrectRoundCorners(dto.RoundCorners{}))
+
+	assert.True(t, isCorrectCanvas(dto.Canvas{Width: 400, Height: 300, Gravity: GravityNorth}))
+	assert.False(t, isCorrectCanvas(dto.Canvas{Width: 400}))
+	assert.False(t, isCorrectCanvas(dto.Canvas{Width: 400, Height: 300, Gravity: "top"}))
+	assert.False(t, isCorrectCanvas(dto.Canvas{Width: maxDimension + 1, Height: 300}))
+}
+
+func TestAddBorder(t *testing.T) {
+	src := createSolidImage(10, 6, color.White)
+
+	result, err := addBorder(src, dto.Border{Width: 2, Color: "#ff0000"})
+	assert.NoError(t, err)
+
+	rgba := result.(*image.RGBA)
+	assert.Equal(t, image.Rect(0, 0, 14, 10), rgba.Bounds())
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, rgba.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, rgba.RGBAAt(13, 9))
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(2, 2))
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(11, 7))
+}
+
+func TestAddPadding(t *testing.T) {
+	src := createSolidImage(10, 6, color.White)
+
+	result, err := addPadding(src, dto.Padding{Top: 1, Right: 2, Bottom: 3, Left: 4})
+	assert.NoError(t, err)
+
+	rgba := result.(*image.RGBA)
+	assert.Equal(t, image.Rect(0, 0, 16, 10), rgba.Bounds())
+	assert.Equal(t, color.RGBA{}, rgba.RGBAAt(3, 1))
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(4, 1))
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(13, 6))
+	assert.Equal(t, color.RGBA{}, rgba.RGBAAt(14, 6))
+	assert.Equal(t, color.RGBA{}, rgba.RGBAAt(13, 7))
+}
+
+func TestRoundCorners(t *testing.T) {
+	src := createSolidImage(40, 20, color.White)
+
+	rgba := roundCorners(src, dto.RoundCorners{Radius: 8}).(*image.RGBA)
+
+	for _, corner := range []image.Point{{0, 0}, {39, 0}, {0, 19}, {39, 19}} {
+		assert.Equal(t, uint8(0), rgba.RGBAAt(corner.X, corner.Y).A, "corner %v", corner)
+	}
+	assert.Equal(t, uint8(255), rgba.RGBAAt(8, 0).A)
+	assert.Equal(t, uint8(255), rgba.RGBAAt(0, 10).A)
+	assert.Equal(t, uint8(255), rgba.RGBAAt(20, 10).A)
+
+	// Pixels on the arc are partially covered.
+	edge := rgba.RGBAAt(2, 2)
+	assert.Greater(t, edge.A, uint8(0))
+	assert.Less(t, edge.A, uint8(255))
+	assert.LessOrEqual(t, edge.R, edge.A)
+
+	t.Run("large radius makes a circle", func(t *testing.T) {
+		square := createSolidImage(20, 20, color.White)
+		circle := roundCorners(square, dto.RoundCorners{Radius: 1000}).(*image.RGBA)
+
+		assert.Equal(t, uint8(0), circle.RGBAAt(2, 2).A)
+		assert.Greater(t, circle.RGBAAt(10, 0).A, uint8(250))
+		assert.Equal(t, uint8(255), circle.RGBAAt(10, 10).A)
+	})
+}
+
+func TestPlaceOnCanvas(t *testing.T) {
+	t.Run("small image keeps its size", func(t *testing.T) {
+		src := createSolidImage(10, 10, color.Black)
+
+		result, err := placeOnCanvas(src, dto.Canvas{Width: 30, Height: 20, Gravity: GravityEast})
+		assert.NoError(t, err)
+
+		rgba := result.(*image.RGBA)
+		assert.Equal(t, image.Rect(0, 0, 30, 20), rgba.Bounds())
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(19, 10))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(20, 5))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(29, 14))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(29, 15))
+	})
+
+	t.Run("large image is scaled down", func(t *testing.T) {
+		src := createSolidImage(100, 50, color.Black)
+
+		result, err := placeOnCanvas(src, dto.Canvas{Width: 40, Height: 40, Color: "transparent"})
+		assert.NoError(t, err)
+
+		rgba := result.(*image.RGBA)
+		assert.Equal(t, image.Rect(0, 0, 40, 40), rgba.Bounds())
+		assert.Equal(t, color.RGBA{}, rgba.RGBAAt(20, 9))
+		assert.Equal(t, uint8(255), rgba.RGBAAt(20, 10).A)
+		assert.Equal(t, uint8(255), rgba.RGBAAt(20, 29).A)
+		assert.Equal(t, color.RGBA{}, rgba.RGBAAt(20, 30))
+	})
+}
+
+func TestCanvasOperations_Golden(t *testing.T) {
+	src := goldenSource()
+
+	tests := []struct {
+		name       string
+		operations []dto.Operation
+	}{
+		{name: "border", operations: []dto.Operation{{Task: Border, Border: dto.Border{Width: 4, Color: "#202020"}}}},
+		{name: "avatar", operations: []dto.Operation{
+			{Task: Resize, Resize: dto.Resize{Width: 48, Height: 48, Mode: ResizeFill}},
+			{Task: RoundCorners, RoundCorners: dto.RoundCorners{Radius: 24}},
+		}},
+		{name: "card", operations: []dto.Operation{
+			{Task: RoundCorners, RoundCorners: dto.RoundCorners{Radius: 8}},
+			{Task: Padding, Padding: dto.Padding{Top: 4, Right: 4, Bottom: 4, Left: 4}},
+			{Task: Canvas, Canvas: dto.Canvas{Width: 96, Height: 64, Color: "#3060a0", Gravity: GravityNorth}},
+		}},
+	}
+
+	service, _, _, _ := createTestService()
+	defer cleanupTestDirs()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.Image(src)
+			for _, operation := range tt.operations {
+				var err error
+				img, err = service.applyOperation(img, operation, 1)
+				assert.NoError(t, err)
 			}
+			assertGolden(t, "canvas/"+tt.name, img)
+		})
+	}
+}
+
+func TestIsCorrectCaption(t *testing.T) {
+	assert.True(t, isCorrectCaption(dto.Caption{Text: "Hello"}))
+	assert.True(t, isCorrectCaption(dto.Caption{Text: "Hello", Font: "go-bold", Align: AlignLeft, LineSpacing: 1.5, Width: 0.8, Background: "#00000080", Padding: 12}))
+	assert.False(t, isCorrectCaption(dto.Caption{}))
+	assert.False(t, isCorrectCaption(dto.Caption{Text: strings.Repeat("a", maxCaptionLength+1)}))
+	assert.False(t, isCorrectCaption(dto.Caption{Text: "Hello", Align: "justify"}))
+	assert.False(t, isCorrectCaption(dto.Caption{Text: "Hello", LineSpacing: 0.2}))
+	assert.False(t, isCorrectCaption(dto.Caption{Text: "Hello", Width: 1.5}))
+	assert.False(t, isCorrectCaption(dto.Caption{Text: "Hello", Font: "comic-sans"}))
+	assert.False(t, isCorrectCaption(dto.Caption{Text: "Hello", Background: "black"}))
+	assert.False(t, isCorrectCaption(dto.Caption{Text: "Hello", Padding: -1}))
+}
+
+func TestWrapText(t *testing.T) {
+	ft, err := opentype.Parse(goregular.TTF)
+	assert.NoError(t, err)
+
+	face, err := opentype.NewFace(ft, &opentype.FaceOptions{Size: 20, DPI: 72})
+	assert.NoError(t, err)
+	defer face.Close()
+
+	width := font.MeasureString(face, "lorem ipsum")
+
+	t.Run("wraps at word boundaries", func(t *testing.T) {
+		lines := wrapText(face, "lorem ipsum dolor sit amet", width)
+		assert.Equal(t, []string{"lorem ipsum", "dolor sit", "amet"}, lines)
+	})
+
+	t.Run("keeps explicit line breaks", func(t *testing.T) {
+		lines := wrapText(face, "lorem\n\nipsum", width)
+		assert.Equal(t, []string{"lorem", "", "ipsum"}, lines)
+	})
+
+	t.Run("breaks long words", func(t *testing.T) {
+		lines := wrapText(face, "loremipsumdolorsitamet", width)
+		assert.Greater(t, len(lines), 1)
+		assert.Equal(t, "loremipsumdolorsitamet", strings.Join(lines, ""))
+		for _, line := range lines {
+			assert.LessOrEqual(t, font.MeasureString(face, line), width)
 		}
+	})
+}
 
-		err := service.addWatermark("test.jpg", "jpeg", "Test Watermark")
+func TestAddCaption(t *testing.T) {
+	service, _, _, _ := createTestService()
+	defer cleanupTestDirs()
 
-		assert.Error(t, err)
+	t.Run("band at the bottom", func(t *testing.T) {
+		src := createSolidImage(200, 100, color.White)
+
+		result, err := service.addCaption(src, dto.Caption{Text: "Hello", Background: "#000000", Padding: 4})
+		assert.NoError(t, err)
+
+		rgba := result.(*image.RGBA)
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(0, 99))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(199, 99))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(0, 0))
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, src.RGBAAt(0, 99), "source image must not be modified")
+	})
+
+	t.Run("alignment", func(t *testing.T) {
+		src := createSolidImage(200, 100, color.Black)
+
+		left, err := service.addCaption(src, dto.Caption{Text: "Hi", Align: AlignLeft, Position: GravityNorth})
+		assert.NoError(t, err)
+		right, err := service.addCaption(src, dto.Caption{Text: "Hi", Align: AlignRight, Position: GravityNorth})
+		assert.NoError(t, err)
+
+		leftInk, rightInk := inkBounds(left.(*image.RGBA)), inkBounds(right.(*image.RGBA))
+		assert.Less(t, leftInk.Max.X, 100)
+		assert.Greater(t, rightInk.Min.X, 100)
+		assert.Equal(t, leftInk.Dy(), rightInk.Dy())
+	})
+
+	t.Run("long text is wrapped and fits", func(t *testing.T) {
+		src := createSolidImage(120, 60, color.Black)
+		text := strings.Repeat("wrapped caption text ", 20)
+
+		result, err := service.addCaption(src, dto.Caption{Text: text, FontSize: 0.2, Margin: 5})
+		assert.NoError(t, err)
+
+		ink := inkBounds(result.(*image.RGBA))
+		assert.False(t, ink.Empty())
+		assert.True(t, ink.In(image.Rect(5, 5, 115, 55)), "ink %v", ink)
 	})
 }
 
-func TestCreateThumbnail(t *testing.T) {
+// inkBounds returns the bounds of the pixels that differ from the top left one.
+func inkBounds(img *image.RGBA) image.Rectangle {
+	background := img.RGBAAt(0, 0)
+	var ink image.Rectangle
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			if img.RGBAAt(x, y) != background {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return ink
+}
+
+func TestAddCaption_Golden(t *testing.T) {
+	tests := []struct {
+		name string
+		opts dto.Caption
+	}{
+		{name: "band", opts: dto.Caption{Text: "Share this card with your friends", FontSize: 0.08, Background: "#000000a0", Padding: 6}},
+		{name: "left", opts: dto.Caption{Text: "First line\nSecond, much longer line that wraps", Font: "go-bold", FontSize: 0.07, Align: AlignLeft, Position: GravityNorthWest, Width: 0.6, Margin: 8, LineSpacing: 1.3, Color: "#202020"}},
+	}
+
+	service, _, _, _ := createTestService()
 	defer cleanupTestDirs()
 
-	t.Run("successful thumbnail", func(t *testing.T) {
-		service, _, _, _ := createTestService()
-		defer cleanupTestDirs()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := createSolidImage(160, 90, color.RGBA{120, 170, 220, 255})
+			result, err := service.addCaption(src, tt.opts)
+			assert.NoError(t, err)
+			assertGolden(t, "caption/"+tt.name, result)
+		})
+	}
+}
+
+// smartScene is a flat image with a detailed subject at the given rectangle.
+func smartScene(width, height int, subject image.Rectangle) *image.RGBA {
+	img := createSolidImage(width, height, color.RGBA{90, 140, 200, 255})
+	for y := subject.Min.Y; y < subject.Max.Y; y++ {
+		for x := subject.Min.X; x < subject.Max.X; x++ {
+			if (x/4+y/4)%2 == 0 {
+				img.SetRGBA(x, y, color.RGBA{250, 220, 40, 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{30, 30, 30, 255})
+			}
+		}
+	}
+	return img
+}
 
-		err := service.createThumbnail("test.jpg", "jpeg")
+func TestSmartRect(t *testing.T) {
+	t.Run("finds the subject", func(t *testing.T) {
+		subject := image.Rect(220, 30, 260, 70)
+		rect := smartRect(smartScene(300, 100, subject), 100, 100)
 
-		assert.Error(t, err)
+		assert.Equal(t, 100, rect.Dx())
+		assert.Equal(t, 100, rect.Dy())
+		assert.True(t, subject.In(rect), "rect %v", rect)
+	})
+
+	t.Run("downsampled analysis", func(t *testing.T) {
+		subject := image.Rect(100, 500, 260, 660)
+		rect := smartRect(smartScene(1200, 800, subject), 400, 400)
+
+		assert.True(t, subject.In(rect), "rect %v", rect)
+		assert.True(t, rect.In(image.Rect(0, 0, 1200, 800)), "rect %v", rect)
+	})
+
+	t.Run("flat image keeps the centre", func(t *testing.T) {
+		rect := smartRect(createSolidImage(300, 100, color.White), 100, 100)
+		assert.Equal(t, image.Rect(100, 0, 200, 100), rect)
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		img := goldenSource()
+		assert.Equal(t, smartRect(img, 20, 20), smartRect(img, 20, 20))
 	})
 }
 
-func TestResizeImage(t *testing.T) {
+func TestSmartGravity(t *testing.T) {
+	service, _, _, _ := createTestService()
 	defer cleanupTestDirs()
 
-	t.Run("successful resize", func(t *testing.T) {
-		service, _, _, _ := createTestService()
-		defer cleanupTestDirs()
+	subject := image.Rect(10, 20, 50, 60)
+	scene := smartScene(400, 100, subject)
 
-		err := service.resizeImage("test.jpg", "jpeg", 50, 50)
+	t.Run("crop", func(t *testing.T) {
+		result, err := service.cropImage(scene, dto.Crop{Width: 80, Height: 80, Gravity: GravitySmart})
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 80, 80), result.Bounds())
+		assert.NotEqual(t, scene.RGBAAt(0, 0), result.At(40, 40), "subject must be kept")
+	})
 
-		assert.Error(t, err)
+	t.Run("cover resize", func(t *testing.T) {
+		result, err := service.resizeImage(scene, dto.Resize{Width: 50, Height: 50, Mode: ResizeCover, Gravity: GravitySmart})
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 50, 50), result.Bounds())
+
+		centre, err := service.resizeImage(scene, dto.Resize{Width: 50, Height: 50, Mode: ResizeCover})
+		assert.NoError(t, err)
+		assert.NotEqual(t, toRGBA(centre).Pix, toRGBA(result).Pix)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		_, err := cropRect(scene.Bounds(), dto.Crop{Width: 80, Height: 80, Gravity: GravitySmart})
+		assert.NoError(t, err)
+		assert.False(t, isCorrectWatermark(dto.Watermark{Position: GravitySmart}))
 	})
 }
+
+func TestSmartGravity_Golden(t *testing.T) {
+	service, _, _, _ := createTestService()
+	defer cleanupTestDirs()
+
+	scene := smartScene(96, 48, image.Rect(6, 10, 30, 34))
+
+	thumbnail, err := service.resizeImage(scene, dto.Resize{Width: 32, Height: 32, Mode: ResizeFill, Gravity: GravitySmart})
+	assert.NoError(t, err)
+	assertGolden(t, "smart/cover", thumbnail)
+
+	crop, err := service.cropImage(goldenSource(), dto.Crop{Width: 16, Height: 16, Gravity: GravitySmart})
+	assert.NoError(t, err)
+	assertGolden(t, "smart/crop", crop)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/Komilov31/image-processor/internal/model"
+	"github.com/google/uuid"
+)
+
+// DefaultMaxDistance is used by searches that do not set the distance, it
+// tolerates rescaling and recompression but not a different picture.
+const (
+	DefaultMaxDistance = 10
+	maxHashDistance    = hashBits
+)
+
+// GetSimilarImages returns the images whose pHash is at most maxDistance bits
+// away from the one of the image, nearest first. The hashes are computed by
+// the worker, so they are not known before the job is picked up; images
+// uploaded before hashing was introduced have no similar images.
+func (s *Service) GetSimilarImages(id uuid.UUID, maxDistance int) ([]model.SimilarImage, error) {
+	if maxDistance < 0 || maxDistance > maxHashDistance {
+		return nil, ErrInvalidSimilarSearch
+	}
+
+	imageInfo, err := s.storage.GetImageInfo(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if imageInfo.Hashes == nil {
+		if imageInfo.Status == statusInProgress {
+			return nil, ErrNotProcessdYet
+		}
+		return []model.SimilarImage{}, nil
+	}
+
+	return s.storage.FindSimilarImages(*imageInfo.Hashes, maxDistance, id)
+}
+
+// SearchSimilarImages returns the images similar to an uploaded one, which
+// is only hashed and not stored. Its format is detected from the data.
+func (s *Service) SearchSimilarImages(data []byte, maxDistance int) ([]model.SimilarImage, error) {
+	if maxDistance < 0 || maxDistance > maxHashDistance {
+		return nil, ErrInvalidSimilarSearch
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	if !isCorrectFormat(format) {
+		return nil, ErrInvalidImageFormat
+	}
+
+	hashes, err := hashData(data, format)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	return s.storage.FindSimilarImages(hashes, maxDistance, uuid.Nil)
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+)
+
+// smartAnalysisSize bounds the longer side of the image the crop is scored
+// on, larger images are averaged down in square blocks first.
+const smartAnalysisSize = 256
+
+// smartRect returns the width x height region of the image with the highest
+// edge density. Edges are measured with the Sobel operator on the luminance,
+// which favours detailed subjects over flat backgrounds and sky. Scoring uses
+// integer arithmetic only, so the result is the same on every platform, and
+// ties are resolved towards the centre of the image.
+func smartRect(img image.Image, width, height int) image.Rectangle {
+	return framesSmartRect([]image.Image{img}, width, height)
+}
+
+// framesSmartRect is smartRect for frames of the same size, the edge density
+// of all frames is summed so an animation is cut at the same place in every
+// frame instead of following a moving subject.
+func framesSmartRect(frames []image.Image, width, height int) image.Rectangle {
+	bounds := frames[0].Bounds()
+	if width >= bounds.Dx() && height >= bounds.Dy() {
+		return gravityRect(bounds, width, height, GravityCenter)
+	}
+
+	step := max((max(bounds.Dx(), bounds.Dy())+smartAnalysisSize-1)/smartAnalysisSize, 1)
+	var edges []int64
+	var sw, sh int
+	for _, frame := range frames {
+		gray := downsampleGray(frame, step)
+		sw, sh = gray.Rect.Dx(), gray.Rect.Dy()
+
+		frameEdges := edgeDensity(gray)
+		if edges == nil {
+			edges = frameEdges
+			continue
+		}
+		for i, edge := range frameEdges {
+			edges[i] += edge
+		}
+	}
+
+	sum := integralImage(edges, sw, sh)
+	ww, wh := min(max(width/step, 1), sw), min(max(height/step, 1), sh)
+
+	best, bestScore, bestDistance := image.Point{}, int64(-1), 0
+	for y := 0; y <= sh-wh; y++ {
+		for x := 0; x <= sw-ww; x++ {
+			score := sum.at(x+ww, y+wh) - sum.at(x, y+wh) - sum.at(x+ww, y) + sum.at(x, y)
+			distance := abs(2*x+ww-sw) + abs(2*y+wh-sh)
+			if score > bestScore || (score == bestScore && distance < bestDistance) {
+				best, bestScore, bestDistance = image.Pt(x, y), score, distance
+			}
+		}
+	}
+
+	// The region is centred on the best window mapped back to the image and
+	// kept inside the bounds, which absorbs the rounding of the block size.
+	x := min(max((2*best.X+ww)*step/2-width/2, 0), bounds.Dx()-width)
+	y := min(max((2*best.Y+wh)*step/2-height/2, 0), bounds.Dy()-height)
+
+	return image.Rect(x, y, x+width, y+height).Add(bounds.Min)
+}
+
+// downsampleGray converts the image to grayscale and averages every step x
+// step block into a single pixel.
+func downsampleGray(img image.Image, step int) *image.Gray {
+	bounds := img.Bounds()
+	full := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(full, full.Bounds(), img, bounds.Min, draw.Src)
+	if step == 1 {
+		return full
+	}
+
+	w, h := (bounds.Dx()+step-1)/step, (bounds.Dy()+step-1)/step
+	small := image.NewGray(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			block := image.Rect(x*step, y*step, (x+1)*step, (y+1)*step).Intersect(full.Rect)
+
+			total := 0
+			for by := block.Min.Y; by < block.Max.Y; by++ {
+				for bx := block.Min.X; bx < block.Max.X; bx++ {
+					total += int(full.Pix[full.PixOffset(bx, by)])
+				}
+			}
+
+			n := block.Dx() * block.Dy()
+			small.Pix[small.PixOffset(x, y)] = uint8((total + n/2) / n)
+		}
+	}
+
+	return small
+}
+
+// edgeDensity returns the Sobel gradient magnitude, approximated by the sum of
+// the absolute horizontal and vertical gradients, of every pixel.
+func edgeDensity(gray *image.Gray) []int64 {
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	at := func(x, y int) int {
+		x, y = min(max(x, 0), w-1), min(max(y, 0), h-1)
+		return int(gray.Pix[gray.PixOffset(x, y)])
+	}
+
+	edges := make([]int64, w*h)
+	for y := range h {
+		for x := range w {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			edges[y*w+x] = int64(abs(gx) + abs(gy))
+		}
+	}
+
+	return edges
+}
+
+// summedArea is a summed-area table, the sum of any rectangle of the scores
+// takes four lookups.
+type summedArea struct {
+	sums   []int64
+	stride int
+}
+
+func integralImage(scores []int64, w, h int) summedArea {
+	table := summedArea{sums: make([]int64, (w+1)*(h+1)), stride: w + 1}
+	for y := range h {
+		var row int64
+		for x := range w {
+			row += scores[y*w+x]
+			table.sums[(y+1)*table.stride+x+1] = table.sums[y*table.stride+x+1] + row
+		}
+	}
+	return table
+}
+
+// at returns the sum of the scores above and to the left of (x, y).
+func (t summedArea) at(x, y int) int64 {
+	return t.sums[y*t.stride+x]
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
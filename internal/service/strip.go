@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+)
+
+const (
+	tagInteropIFD      = 0xa005
+	tagMakerNote       = 0x927c
+	tagPixelXDimension = 0xa002
+	tagPixelYDimension = 0xa003
+)
+
+// subDirectoryTags are the entries pointing to the Exif, GPS and
+// interoperability directories, which are rewritten with the directory.
+var subDirectoryTags = map[uint16]struct{}{
+	tagExifIFD:    struct{}{},
+	tagGPSIFD:     struct{}{},
+	tagInteropIFD: struct{}{},
+}
+
+// droppedTags are not carried to the outputs. They describe the pixel data of
+// the original, which TIFF files keep in the same directory as the EXIF
+// tags, or are written as blocks of their own. Maker notes hold offsets into
+// the original structure that the rewritten one would break.
+var droppedTags = map[uint16]struct{}{
+	0x00fe:             struct{}{}, // NewSubfileType
+	0x0100:             struct{}{}, // ImageWidth
+	0x0101:             struct{}{}, // ImageLength
+	0x0102:             struct{}{}, // BitsPerSample
+	0x0103:             struct{}{}, // Compression
+	0x0106:             struct{}{}, // PhotometricInterpretation
+	0x0111:             struct{}{}, // StripOffsets
+	0x0115:             struct{}{}, // SamplesPerPixel
+	0x0116:             struct{}{}, // RowsPerStrip
+	0x0117:             struct{}{}, // StripByteCounts
+	0x011c:             struct{}{}, // PlanarConfiguration
+	0x013d:             struct{}{}, // Predictor
+	0x0140:             struct{}{}, // ColorMap
+	0x0142:             struct{}{}, // TileWidth
+	0x0143:             struct{}{}, // TileLength
+	0x0144:             struct{}{}, // TileOffsets
+	0x0145:             struct{}{}, // TileByteCounts
+	0x014a:             struct{}{}, // SubIFDs
+	0x0152:             struct{}{}, // ExtraSamples
+	0x0153:             struct{}{}, // SampleFormat
+	0x0201:             struct{}{}, // JPEGInterchangeFormat
+	0x0202:             struct{}{}, // JPEGInterchangeFormatLength
+	tagXMP:             struct{}{},
+	tagIPTC:            struct{}{},
+	tagICCProfile:      struct{}{},
+	tagMakerNote:       struct{}{},
+	tagPixelXDimension: struct{}{},
+	tagPixelYDimension: struct{}{},
+}
+
+// optionEnabled reports whether an encoder switch is on, switches that are
+// not set are on.
+func optionEnabled(option *bool) bool {
+	return option == nil || *option
+}
+
+// outputMetadata returns the metadata blocks written to an output. Stripped
+// outputs keep at most the colour profile and the copyright notices, the
+// others get the metadata of the original. The embedded thumbnail is never
+// kept, it would show the original even when it was cropped or redacted.
+// The orientation is reset when it was applied to the pixels.
+func outputMetadata(src metadataBlocks, opts dto.Output, oriented bool) metadataBlocks {
+	if !optionEnabled(opts.StripMetadata) {
+		return metadataBlocks{
+			exif: rewriteEXIF(src.exif, oriented),
+			iptc: src.iptc,
+			xmp:  src.xmp,
+			icc:  src.icc,
+		}
+	}
+
+	var result metadataBlocks
+	if optionEnabled(opts.KeepICCProfile) {
+		result.icc = src.icc
+	}
+	if optionEnabled(opts.KeepCopyright) {
+		result.exif, result.iptc, result.xmp = copyrightBlocks(src)
+	}
+	return result
+}
+
+// tiffDirectory is an EXIF directory with the directories its entries point
+// to.
+type tiffDirectory struct {
+	entries  []tiffEntry
+	children map[uint16]*tiffDirectory
+}
+
+// rewriteEXIF returns a new EXIF structure with the primary directory and
+// the Exif, GPS and interoperability directories of the original. Following
+// directories, which hold the thumbnail, are left out.
+func rewriteEXIF(payload []byte, resetOrientation bool) []byte {
+	if payload == nil {
+		return nil
+	}
+
+	reader, err := newTIFFReader(payload)
+	if err != nil {
+		return nil
+	}
+
+	root := reader.directory(reader.firstIFD(), 0)
+	if root == nil || len(root.entries) == 0 {
+		return nil
+	}
+
+	if resetOrientation {
+		for i, entry := range root.entries {
+			if entry.tag == tagOrientation {
+				value := make([]byte, 2)
+				reader.order.PutUint16(value, 1)
+				root.entries[i] = tiffEntry{tag: tagOrientation, typ: 3, count: 1, value: value}
+			}
+		}
+	}
+
+	return encodeTIFF(reader.order, root)
+}
+
+// directory reads the directory at offset and the directories it points to.
+// Only the primary directory and the Exif directory have children, which
+// keeps malformed structures from pointing back to their parents.
+func (t *tiffReader) directory(offset uint32, depth int) *tiffDirectory {
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return nil
+	}
+
+	dir := &tiffDirectory{children: make(map[uint16]*tiffDirectory)}
+	for _, entry := range entries {
+		if _, ok := droppedTags[entry.tag]; ok {
+			continue
+		}
+
+		if _, ok := subDirectoryTags[entry.tag]; ok {
+			childOffset, ok := t.uint(entry, 0)
+			if !ok || depth >= 2 {
+				continue
+			}
+
+			child := t.directory(childOffset, depth+1)
+			if child == nil || len(child.entries) == 0 {
+				continue
+			}
+
+			dir.children[entry.tag] = child
+			entry = tiffEntry{tag: entry.tag, typ: 4, count: 1, value: make([]byte, 4)}
+		}
+
+		dir.entries = append(dir.entries, entry)
+	}
+
+	return dir
+}
+
+// encodeTIFF writes the directory as a TIFF structure in the given byte
+// order, the values are expected in the same order.
+func encodeTIFF(order binary.ByteOrder, root *tiffDirectory) []byte {
+	header := []byte("II\x2a\x00\x08\x00\x00\x00")
+	if order == binary.BigEndian {
+		header = []byte("MM\x00\x2a\x00\x00\x00\x08")
+	}
+	return append(header, root.encode(order, uint32(len(header)))...)
+}
+
+// encode lays out the directory at the offset, followed by the values that
+// do not fit into the entries and by the directories it points to.
+func (d *tiffDirectory) encode(order binary.ByteOrder, offset uint32) []byte {
+	table := make([]byte, 2+12*len(d.entries)+4)
+	order.PutUint16(table, uint16(len(d.entries)))
+
+	end := offset + uint32(len(table))
+	var data []byte
+	for i, entry := range d.entries {
+		raw := table[2+12*i:]
+		order.PutUint16(raw, entry.tag)
+		order.PutUint16(raw[2:], entry.typ)
+		order.PutUint32(raw[4:], entry.count)
+
+		if len(entry.value) <= 4 {
+			copy(raw[8:12], entry.value)
+			continue
+		}
+
+		order.PutUint32(raw[8:], end+uint32(len(data)))
+		data = append(data, entry.value...)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+
+	end += uint32(len(data))
+	for i, entry := range d.entries {
+		child, ok := d.children[entry.tag]
+		if !ok {
+			continue
+		}
+
+		order.PutUint32(table[2+12*i+8:], end)
+		encoded := child.encode(order, end)
+		data = append(data, encoded...)
+		end += uint32(len(encoded))
+	}
+
+	return append(table, data...)
+}
+
+// copyrightBlocks returns the copyright notices of the original: the EXIF
+// Copyright tag, the IPTC copyright notice and the XMP dc:rights property.
+func copyrightBlocks(src metadataBlocks) (exif, iptc, xmp []byte) {
+	if parsed := parseEXIF(src.exif); parsed != nil && parsed.Copyright != "" {
+		value := append([]byte(parsed.Copyright), 0)
+		exif = encodeTIFF(binary.BigEndian, &tiffDirectory{entries: []tiffEntry{
+			{tag: tagCopyright, typ: 2, count: uint32(len(value)), value: value},
+		}})
+	}
+
+	if notices := parseIPTC(src.iptc)["copyright_notice"]; len(notices) > 0 {
+		iptc = encodeIPTC(notices)
+	}
+
+	if rights := parseXMP(src.xmp)["dc:rights"]; len(rights) > 0 {
+		xmp = rightsXMP(rights[0])
+	}
+
+	return exif, iptc, xmp
+}
+
+// encodeIPTC writes the copyright notices as IPTC datasets, marked as UTF-8
+// since every value is decoded to it.
+func encodeIPTC(notices []string) []byte {
+	var data []byte
+	dataset := func(record, number byte, value []byte) {
+		data = append(data, 0x1c, record, number)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(value)))
+		data = append(data, value...)
+	}
+
+	dataset(1, 90, []byte("\x1b%G"))
+	dataset(2, 0, []byte{0, 4})
+	for _, notice := range notices {
+		if len(notice) <= 0x7fff {
+			dataset(2, 116, []byte(notice))
+		}
+	}
+	return data
+}
+
+// rightsXMP returns an XMP packet with the dc:rights property only.
+func rightsXMP(rights string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	buf.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	buf.WriteString(`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">`)
+	_ = xml.EscapeText(&buf, []byte(rights))
+	buf.WriteString(`</rdf:li></rdf:Alt></dc:rights></rdf:Description></rdf:RDF></x:xmpmeta>`)
+	return buf.Bytes()
+}
@@ -9,13 +9,17 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"os"
+	"strconv"
 	"strings"
 
-	res "github.com/nfnt/resize"
+	"github.com/Komilov31/image-processor/internal/dto"
+	webpenc "github.com/Komilov31/image-processor/internal/webp"
+	"golang.org/x/image/bmp"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
 	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
 )
 
 func isCorrectFormat(format string) bool {
@@ -24,6 +28,9 @@ func isCorrectFormat(format string) bool {
 		"jpg":  struct{}{},
 		"png":  struct{}{},
 		"gif":  struct{}{},
+		"webp": struct{}{},
+		"bmp":  struct{}{},
+		"tiff": struct{}{},
 	}
 
 	_, ok := formats[format]
@@ -31,6 +38,13 @@ func isCorrectFormat(format string) bool {
 	return ok
 }
 
+// formatAliases maps alternative content type subtypes to the format names used in storage.
+var formatAliases = map[string]string{
+	"jpg":      "jpeg",
+	"tif":      "tiff",
+	"x-ms-bmp": "bmp",
+}
+
 func parseFormat(contentType string) (string, error) {
 	parsed := strings.Split(contentType, "/")
 	if len(parsed) < 2 {
@@ -38,8 +52,8 @@ func parseFormat(contentType string) (string, error) {
 	}
 
 	format := parsed[1]
-	if format == "jpg" {
-		format = "jpeg"
+	if alias, ok := formatAliases[format]; ok {
+		format = alias
 	}
 
 	if !isCorrectFormat(format) {
@@ -51,9 +65,23 @@ func parseFormat(contentType string) (string, error) {
 
 func isCorrectTask(task string) bool {
 	tasks := map[string]struct{}{
-		Resize:    struct{}{},
-		Watermark: struct{}{},
-		Thumbnail: struct{}{},
+		Resize:       struct{}{},
+		Watermark:    struct{}{},
+		Thumbnail:    struct{}{},
+		Crop:         struct{}{},
+		Rotate:       struct{}{},
+		Flip:         struct{}{},
+		AutoOrient:   struct{}{},
+		Adjust:       struct{}{},
+		Blur:         struct{}{},
+		Sharpen:      struct{}{},
+		Convolve:     struct{}{},
+		Redact:       struct{}{},
+		Border:       struct{}{},
+		Padding:      struct{}{},
+		RoundCorners: struct{}{},
+		Canvas:       struct{}{},
+		Caption:      struct{}{},
 	}
 
 	_, ok := tasks[task]
@@ -61,20 +89,7 @@ func isCorrectTask(task string) bool {
 	return ok
 }
 
-func resize(format string, r *os.File, w *os.File, width, height int) error {
-	src, err := decode(format, r)
-	if err != nil {
-		return err
-	}
-
-	resized := res.Resize(uint(width), uint(height), src, res.Lanczos3)
-
-	return encode(format, resized, w)
-}
-
-func decode(format string, r *os.File) (image.Image, error) {
-	defer r.Close()
-
+func decode(format string, r io.Reader) (image.Image, error) {
 	switch format {
 	case "jpeg":
 		return jpeg.Decode(r)
@@ -82,63 +97,126 @@ func decode(format string, r *os.File) (image.Image, error) {
 		return png.Decode(r)
 	case "gif":
 		return gif.Decode(r)
+	case "webp":
+		return webp.Decode(r)
+	case "bmp":
+		return bmp.Decode(r)
+	case "tiff":
+		return tiff.Decode(r)
 	}
 
 	return nil, fmt.Errorf("invalid file format")
 }
 
-func encode(format string, dst image.Image, w *os.File) error {
-	defer w.Close()
-
-	switch format {
+func encode(opts dto.Output, dst image.Image, w io.Writer) error {
+	switch opts.Format {
 	case "jpeg":
-		return jpeg.Encode(w, dst, nil)
+		return jpeg.Encode(w, dst, jpegOptions(opts))
 	case "png":
-		return png.Encode(w, dst)
+		encoder := png.Encoder{CompressionLevel: pngCompressionLevel(opts.PNGCompression)}
+		return encoder.Encode(w, dst)
 	case "gif":
-		return gif.Encode(w, dst, nil)
+		return gif.Encode(w, dst, gifOptions(opts))
+	case "webp":
+		return webpenc.Encode(w, dst)
+	case "bmp":
+		return bmp.Encode(w, dst)
+	case "tiff":
+		return tiff.Encode(w, dst, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
 	}
 
 	return fmt.Errorf("invalid file format")
 }
 
-func addLabel(img draw.Image, x, y int, label string, fontSize float64) error {
-	bytes, err := os.Open("static/font.ttf")
-	if err != nil {
-		return err
+// encodeAnimation writes every frame of the picture as an animated GIF, each
+// frame gets its own palette.
+func encodeAnimation(opts dto.Output, pic *picture, w io.Writer) error {
+	options := gifOptions(opts)
+	drawer := options.Drawer
+	if drawer == nil {
+		drawer = draw.FloydSteinberg
 	}
 
-	ftBytes, err := io.ReadAll(bytes)
-	if err != nil {
-		return err
+	anim := &gif.GIF{
+		Delay:     pic.delays,
+		Disposal:  pic.disposals,
+		LoopCount: pic.loopCount,
 	}
 
-	ft, err := opentype.Parse(ftBytes)
-	if err != nil {
-		return err
+	for _, frame := range pic.frames {
+		bounds := frame.Bounds()
+		palette := options.Quantizer.Quantize(make(color.Palette, 0, options.NumColors), frame)
+		paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+		drawer.Draw(paletted, paletted.Bounds(), frame, bounds.Min)
+		anim.Image = append(anim.Image, paletted)
 	}
 
+	return gif.EncodeAll(w, anim)
+}
+
+// labelImage renders text onto a transparent image that is exactly as large
+// as the measured text: the advance width from font.MeasureString and the
+// line height of the face, extended by any glyph parts reaching beyond them.
+func labelImage(ft *opentype.Font, text string, size float64, col color.Color) (*image.RGBA, error) {
 	face, err := opentype.NewFace(ft, &opentype.FaceOptions{
-		Size:    fontSize,
+		Size:    size,
 		DPI:     72,
 		Hinting: font.HintingFull,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer face.Close()
 
-	col := color.RGBA{255, 20, 100, 100} //red
+	metrics := face.Metrics()
+	ink, _ := font.BoundString(face, text)
+
+	box := fixed.Rectangle26_6{
+		Min: fixed.Point26_6{X: min(0, ink.Min.X), Y: min(-metrics.Ascent, ink.Min.Y)},
+		Max: fixed.Point26_6{X: max(font.MeasureString(face, text), ink.Max.X), Y: max(metrics.Descent, ink.Max.Y)},
+	}
+
+	// Whole pixel offsets keep the glyphs rendered exactly as on any other canvas.
+	origin := image.Pt(box.Min.X.Floor(), box.Min.Y.Floor())
+	img := image.NewRGBA(image.Rect(0, 0, max(box.Max.X.Ceil()-origin.X, 1), max(box.Max.Y.Ceil()-origin.Y, 1)))
 
 	d := &font.Drawer{
 		Dst:  img,
 		Src:  image.NewUniform(col),
 		Face: face,
-		Dot: fixed.Point26_6{
-			X: fixed.I(x),
-			Y: fixed.I(y),
-		},
+		Dot:  fixed.P(-origin.X, -origin.Y),
+	}
+	d.DrawString(text)
+
+	return img, nil
+}
+
+// parseColor parses colours in #rgb, #rrggbb and #rrggbbaa notation.
+func parseColor(s string) (color.NRGBA, error) {
+	if s == "transparent" {
+		return color.NRGBA{}, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	switch len(hex) {
+	case 3:
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]}) + "ff"
+	case 6:
+		hex += "ff"
+	case 8:
+	default:
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
 	}
-	d.DrawString(label)
 
-	return nil
+	return color.NRGBA{
+		R: uint8(value >> 24),
+		G: uint8(value >> 16),
+		B: uint8(value >> 8),
+		A: uint8(value),
+	}, nil
 }
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"regexp"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	"github.com/Komilov31/image-processor/internal/model"
+	"github.com/google/uuid"
+)
+
+const (
+	maxVariants = 10
+)
+
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// output is a single rendition produced by a job. Jobs without variants
+// produce one output with an empty variant name.
+type output struct {
+	variant    string
+	fileName   string
+	operations []dto.Operation
+	encoding   dto.Output
+}
+
+func outputs(message dto.Message, format string) []output {
+	if len(message.Variants) == 0 {
+		encoding := resolveOutput(message.Output, dto.Output{}, format)
+		return []output{{
+			fileName:   processedFileName(message.FileName, encoding.Format),
+			operations: operations(message),
+			encoding:   encoding,
+		}}
+	}
+
+	result := make([]output, 0, len(message.Variants))
+	for _, variant := range message.Variants {
+		encoding := resolveOutput(message.Output, variant.Output, format)
+		result = append(result, output{
+			variant:    variant.Name,
+			fileName:   variantFileName(message.ID, variant.Name, encoding.Format),
+			operations: variant.Operations,
+			encoding:   encoding,
+		})
+	}
+
+	return result
+}
+
+func variantFileName(id uuid.UUID, name, format string) string {
+	return id.String() + "_" + name + "." + format
+}
+
+func validateVariants(variants []dto.Variant) error {
+	if len(variants) > maxVariants {
+		return ErrInvalidVariants
+	}
+
+	names := make(map[string]struct{}, len(variants))
+	for _, variant := range variants {
+		if !variantNamePattern.MatchString(variant.Name) {
+			return ErrInvalidVariants
+		}
+
+		if _, ok := names[variant.Name]; ok {
+			return ErrInvalidVariants
+		}
+		names[variant.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// findVariant returns the requested variant of the image. The first declared
+// variant is returned when no name is given.
+func findVariant(image *model.Image, name string) (*model.Variant, error) {
+	if len(image.Variants) == 0 {
+		return nil, ErrNoSuchVariant
+	}
+
+	if name == "" {
+		return &image.Variants[0], nil
+	}
+
+	for i := range image.Variants {
+		if image.Variants[i].Name == name {
+			return &image.Variants[i], nil
+		}
+	}
+
+	return nil, ErrNoSuchVariant
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/Komilov31/image-processor/internal/dto"
+	"github.com/google/uuid"
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	WatermarkSingle = "single"
+	WatermarkTiled  = "tiled"
+)
+
+const (
+	defaultWatermarkPosition = GravitySouth
+	defaultWatermarkMargin   = 10
+	defaultWatermarkColor    = "#ff1464"
+	defaultWatermarkOpacity  = 0.4
+	defaultWatermarkFontSize = 0.05
+	maxWatermarkMargin       = 1000
+	maxWatermarkSpacing      = 1000
+
+	// Tiled watermarks run diagonally unless a rotation is given.
+	defaultTiledRotation = -45
+
+	// maxLabelAttempts bounds the number of times the label is re-rendered
+	// with a smaller font while it does not fit into the image.
+	maxLabelAttempts = 8
+	minLabelFontSize = 1
+)
+
+func isCorrectWatermark(opts dto.Watermark) bool {
+	if opts.Mode != "" && opts.Mode != WatermarkSingle && opts.Mode != WatermarkTiled {
+		return false
+	}
+
+	if opts.Spacing < 0 || opts.Spacing > maxWatermarkSpacing {
+		return false
+	}
+
+	if opts.Position != "" && !isCorrectGravity(opts.Position) {
+		return false
+	}
+
+	if opts.Margin < 0 || opts.Margin > maxWatermarkMargin {
+		return false
+	}
+
+	if opts.Color != "" {
+		if _, err := parseColor(opts.Color); err != nil {
+			return false
+		}
+	}
+
+	if math.IsNaN(opts.Opacity) || opts.Opacity < 0 || opts.Opacity > 1 {
+		return false
+	}
+
+	if math.IsNaN(opts.FontSize) || opts.FontSize < 0 || opts.FontSize > 1 {
+		return false
+	}
+
+	if opts.OverlayID != "" {
+		if _, err := uuid.Parse(opts.OverlayID); err != nil {
+			return false
+		}
+	}
+
+	if opts.Font != "" && !isCorrectFont(opts.Font) {
+		return false
+	}
+
+	if math.IsNaN(opts.Scale) || opts.Scale < 0 || opts.Scale > 1 {
+		return false
+	}
+
+	return !math.IsNaN(opts.Rotation) && math.Abs(opts.Rotation) <= 360
+}
+
+// watermarkDefaults fills in every option that is not set.
+func watermarkDefaults(opts dto.Watermark) dto.Watermark {
+	if opts.Mode == "" {
+		opts.Mode = WatermarkSingle
+	}
+	if opts.Mode == WatermarkTiled && opts.Rotation == 0 {
+		opts.Rotation = defaultTiledRotation
+	}
+	if opts.Position == "" {
+		opts.Position = defaultWatermarkPosition
+	}
+	if opts.Margin == 0 {
+		opts.Margin = defaultWatermarkMargin
+	}
+	if opts.Color == "" {
+		opts.Color = defaultWatermarkColor
+	}
+	if opts.Opacity == 0 {
+		opts.Opacity = defaultWatermarkOpacity
+	}
+	if opts.FontSize == 0 {
+		opts.FontSize = defaultWatermarkFontSize
+	}
+	return opts
+}
+
+// checkReferences makes sure every overlay and font the job references exists.
+func (s *Service) checkReferences(message dto.Message) error {
+	pipelines := [][]dto.Operation{operations(message)}
+	for _, variant := range message.Variants {
+		pipelines = append(pipelines, variant.Operations)
+	}
+
+	for _, ops := range pipelines {
+		for _, operation := range ops {
+			var err error
+			switch operation.Task {
+			case Watermark:
+				err = s.checkWatermark(operation.Watermark)
+			case Caption:
+				_, err = s.loadFont(operation.Caption.Font)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) checkWatermark(opts dto.Watermark) error {
+	if opts.OverlayID != "" {
+		id, err := uuid.Parse(opts.OverlayID)
+		if err != nil {
+			return ErrInvalidWatermark
+		}
+
+		_, err = s.loadOverlay(id)
+		return err
+	}
+
+	_, err := s.loadFont(opts.Font)
+	return err
+}
+
+// watermarkArea is the part of the image the watermark is placed in. Margins
+// that do not leave any room are ignored.
+func watermarkArea(bounds image.Rectangle, margin int) image.Rectangle {
+	area := bounds.Inset(margin)
+	if area.Empty() {
+		return bounds
+	}
+	return area
+}
+
+// watermarkLabel renders the text of a watermark for an image with the given
+// bounds. The font size is relative to the image width and is reduced until
+// the label, once rotated, fits into the margins, so the text is never
+// clipped. It returns nil when the text cannot be made small enough.
+func watermarkLabel(bounds image.Rectangle, ft *opentype.Font, text string, opts dto.Watermark) (image.Image, error) {
+	col, err := parseColor(opts.Color)
+	if err != nil {
+		return nil, err
+	}
+	col.A = 0xff
+
+	area := watermarkArea(bounds, opts.Margin)
+	size := opts.FontSize * float64(bounds.Dx())
+
+	for range maxLabelAttempts {
+		if size < minLabelFontSize {
+			break
+		}
+
+		label, err := labelImage(ft, text, size, col)
+		if err != nil {
+			return nil, err
+		}
+
+		rotated := rotatedSize(label.Bounds().Size(), opts.Rotation)
+		w, h := rotated.X, rotated.Y
+		if w <= area.Dx() && h <= area.Dy() {
+			return label, nil
+		}
+
+		// Hinting makes glyph sizes slightly non-linear, so shrink a bit more
+		// than the ratio to converge in a few attempts.
+		scale := min(float64(area.Dx())/float64(w), float64(area.Dy())/float64(h))
+		size *= scale * 0.95
+	}
+
+	return nil, nil
+}
+
+func watermarkMask(opacity float64) image.Image {
+	return image.NewUniform(color.Alpha{A: uint8(math.Round(opacity * 0xff))})
+}
+
+// drawWatermark rotates the mark and composites it onto dst at the anchor
+// position with the given opacity.
+func drawWatermark(dst draw.Image, mark image.Image, opts dto.Watermark) error {
+	rotated, err := rotateImage(mark, dto.Rotate{Angle: opts.Rotation, Background: "transparent"})
+	if err != nil {
+		return err
+	}
+
+	area := watermarkArea(dst.Bounds(), opts.Margin)
+	markBounds := rotated.Bounds()
+	rect := gravityRect(area, markBounds.Dx(), markBounds.Dy(), opts.Position)
+
+	draw.DrawMask(dst, rect, rotated, markBounds.Min, watermarkMask(opts.Opacity), image.Point{}, draw.Over)
+
+	return nil
+}
+
+// drawTiledWatermark repeats the mark over the whole image on a grid rotated
+// by the watermark rotation, so it cannot be cropped out. Every other row is
+// shifted by half a cell so the marks do not line up into columns.
+func drawTiledWatermark(dst draw.Image, mark image.Image, opts dto.Watermark) error {
+	rotated, err := rotateImage(mark, dto.Rotate{Angle: opts.Rotation, Background: "transparent"})
+	if err != nil {
+		return err
+	}
+
+	size := mark.Bounds().Size()
+	spacing := opts.Spacing
+	if spacing == 0 {
+		spacing = max(size.X, size.Y) / 2
+	}
+	cellW, cellH := float64(size.X+spacing), float64(size.Y+spacing)
+
+	bounds := dst.Bounds()
+	centerX := float64(bounds.Min.X) + float64(bounds.Dx())/2
+	centerY := float64(bounds.Min.Y) + float64(bounds.Dy())/2
+
+	// Enough cells in every direction to cover the image at any angle.
+	diagonal := math.Hypot(float64(bounds.Dx()), float64(bounds.Dy()))
+	cols := int(math.Ceil(diagonal/cellW/2)) + 1
+	rows := int(math.Ceil(diagonal/cellH/2)) + 1
+
+	sin, cos := math.Sincos(opts.Rotation * math.Pi / 180)
+	markBounds := rotated.Bounds()
+	mask := watermarkMask(opts.Opacity)
+
+	for row := -rows; row <= rows; row++ {
+		shift := 0.0
+		if row%2 != 0 {
+			shift = cellW / 2
+		}
+
+		for col := -cols; col <= cols; col++ {
+			u, v := float64(col)*cellW+shift, float64(row)*cellH
+			x := centerX + u*cos - v*sin - float64(markBounds.Dx())/2
+			y := centerY + u*sin + v*cos - float64(markBounds.Dy())/2
+
+			topLeft := image.Pt(int(math.Round(x)), int(math.Round(y)))
+			rect := image.Rectangle{Min: topLeft, Max: topLeft.Add(markBounds.Size())}
+			if !rect.Overlaps(bounds) {
+				continue
+			}
+
+			draw.DrawMask(dst, rect, rotated, markBounds.Min, mask, image.Point{}, draw.Over)
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/google/uuid"
 	"github.com/wb-go/wbf/zlog"
 )
 
@@ -36,6 +38,9 @@ func (s *Service) worker(ctx context.Context) {
 	}
 }
 
+// handleMessage processes the next queued job. The original is removed from
+// the temp directory whatever the outcome, and an image whose job fails is
+// marked as failed so that clients stop waiting for it.
 func (s *Service) handleMessage() error {
 	message, err := s.queue.ConsumeMessage()
 	if err != nil {
@@ -43,30 +48,125 @@ func (s *Service) handleMessage() error {
 	}
 
 	oPath := originDirName + "/" + message.FileName
-	pPath := processedDirName + "/" + message.FileName
-	file, err := os.Create(pPath)
+	finished := false
+	defer func() {
+		if !finished {
+			if err := s.storage.UpdateImageStatus(message.ID, statusFailed); err != nil {
+				zlog.Logger.Error().Msgf("could not mark image %s as failed: %s", message.ID, err.Error())
+			}
+		}
+
+		if err := os.Remove(oPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			zlog.Logger.Error().Msgf("could not delete temp file: %s", err.Error())
+		}
+	}()
+
+	format, err := parseFormat(message.ContentType)
 	if err != nil {
-		return fmt.Errorf("could not creaet file to save image from storage: %s", err.Error())
+		return fmt.Errorf("could not process image: %s", err.Error())
 	}
-	file.Close()
 
-	if err := s.ProcessImage(*message); err != nil {
-		return fmt.Errorf("could not process image: %s", err.Error())
+	// Missing metadata does not make the image unusable, so it is processed
+	// anyway.
+	if err := s.storeMetadata(message.ID, oPath, format); err != nil {
+		zlog.Logger.Error().Msg(err.Error())
 	}
 
-	if err := s.fileStorage.SaveImage(message.FileName, pPath, "processed"); err != nil {
-		return fmt.Errorf("could not save processed message to fileStorage: %s", err.Error())
+	// The original is decoded once, for the hashes and for the processing.
+	pic, orientation, processErr := loadImage(message.FileName, format)
+	if processErr == nil {
+		if err := s.storeHashes(message.ID, pic, orientation); err != nil {
+			zlog.Logger.Error().Msg(err.Error())
+		}
+		processErr = s.processPicture(pic, orientation, format, *message)
+	}
+	if processErr != nil && len(message.Variants) == 0 {
+		return fmt.Errorf("could not process image: %s", processErr.Error())
+	}
+
+	for _, out := range outputs(*message, format) {
+		if err := s.storeOutput(message.ID, out); err != nil {
+			return err
+		}
 	}
 
-	if err := s.storage.UpdateImageStatus(message.ID, "finished"); err != nil {
+	if err := s.storage.UpdateImageStatus(message.ID, statusFinished); err != nil {
 		return fmt.Errorf("could not update image processing status in db: %s", err.Error())
 	}
+	finished = true
 
-	if err := os.Remove(pPath); err != nil {
-		return fmt.Errorf("could not delete temp file: %s", err.Error())
+	if processErr != nil {
+		return fmt.Errorf("could not process some variants: %s", processErr.Error())
+	}
+
+	return nil
+}
+
+// storeMetadata extracts the EXIF, IPTC and XMP metadata of the original and
+// saves it with the image information. Images without metadata are left
+// untouched.
+func (s *Service) storeMetadata(id uuid.UUID, path, format string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read original image: %s", err.Error())
+	}
+
+	metadata := extractMetadata(data, format)
+	if metadata == nil {
+		return nil
+	}
+
+	if err := s.storage.UpdateImageMetadata(id, metadata); err != nil {
+		return fmt.Errorf("could not update image metadata in db: %s", err.Error())
+	}
+
+	return nil
+}
+
+// storeHashes computes the perceptual hashes of the decoded original and
+// saves them for the similarity search.
+func (s *Service) storeHashes(id uuid.UUID, pic *picture, orientation int) error {
+	if err := s.storage.UpdateImageHashes(id, pictureHashes(pic, orientation)); err != nil {
+		return fmt.Errorf("could not update image hashes in db: %s", err.Error())
+	}
+
+	return nil
+}
+
+// storeOutput saves a processed output to the file storage and records its
+// file information and status. Variants that failed to process have no local
+// file and are marked as failed.
+func (s *Service) storeOutput(id uuid.UUID, out output) error {
+	pPath := processedDirName + "/" + out.fileName
+
+	data, err := os.ReadFile(pPath)
+	if err != nil {
+		if err := s.storage.UpdateVariantStatus(id, out.variant, statusFailed); err != nil {
+			return fmt.Errorf("could not update variant processing status in db: %s", err.Error())
+		}
+		return nil
+	}
+
+	if err := s.fileStorage.SaveImage(out.fileName, pPath, "processed"); err != nil {
+		return fmt.Errorf("could not save processed message to fileStorage: %s", err.Error())
+	}
+
+	file := describeFile(data, out.encoding.Format)
+	if out.variant == "" {
+		if err := s.storage.UpdateProcessedFile(id, file); err != nil {
+			return fmt.Errorf("could not update processed file info in db: %s", err.Error())
+		}
+	} else {
+		if err := s.storage.UpdateVariantFile(id, out.variant, file); err != nil {
+			return fmt.Errorf("could not update variant file info in db: %s", err.Error())
+		}
+
+		if err := s.storage.UpdateVariantStatus(id, out.variant, statusFinished); err != nil {
+			return fmt.Errorf("could not update variant processing status in db: %s", err.Error())
+		}
 	}
 
-	if err := os.Remove(oPath); err != nil {
+	if err := os.Remove(pPath); err != nil {
 		return fmt.Errorf("could not delete temp file: %s", err.Error())
 	}
 
@@ -0,0 +1,244 @@
+package webp
+
+import (
+	"math/bits"
+	"sort"
+)
+
+const (
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 7
+	numCodeLengthCodes      = 19
+)
+
+var codeLengthCodeOrder = [numCodeLengthCodes]int{
+	17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// huffmanCode holds the bit-reversed canonical codes of an alphabet, ready
+// to be written LSB first. Symbols of single-symbol codes take zero bits.
+type huffmanCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func (c *huffmanCode) write(bw *bitWriter, symbol int) {
+	bw.write(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+}
+
+// writeHuffmanCode writes the prefix code for the histogram and returns it.
+func writeHuffmanCode(bw *bitWriter, histogram []uint32) huffmanCode {
+	code := huffmanCode{
+		lengths: make([]uint8, len(histogram)),
+		codes:   make([]uint16, len(histogram)),
+	}
+
+	var symbols []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	if len(symbols) == 0 {
+		symbols = append(symbols, 0)
+	}
+
+	// Codes with one or two 8-bit symbols are written as simple codes. The
+	// first symbol is coded as 0 and the second one as 1.
+	if len(symbols) <= 2 && symbols[len(symbols)-1] < 256 {
+		bw.write(1, 1)
+		bw.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbols[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbols[0]), 8)
+		}
+
+		if len(symbols) == 2 {
+			bw.write(uint32(symbols[1]), 8)
+			code.lengths[symbols[0]], code.lengths[symbols[1]] = 1, 1
+			code.codes[symbols[1]] = 1
+		}
+		return code
+	}
+
+	lengths := codeLengths(histogram, maxCodeLength)
+	bw.write(0, 1)
+	writeCodeLengths(bw, lengths)
+
+	if len(symbols) > 1 {
+		code.lengths = lengths
+		code.codes = canonicalCodes(lengths)
+	}
+
+	return code
+}
+
+// writeCodeLengths writes the code lengths of a normal prefix code, which are
+// themselves prefix coded. Runs of zeros use the repeat codes 17 and 18.
+func writeCodeLengths(bw *bitWriter, lengths []uint8) {
+	type token struct {
+		symbol    int
+		extraBits uint
+		extra     uint32
+	}
+
+	var tokens []token
+	for i := 0; i < len(lengths); {
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == lengths[i] {
+			run++
+		}
+
+		if lengths[i] == 0 {
+			left := run
+			for left >= 11 {
+				n := min(left, 138)
+				tokens = append(tokens, token{18, 7, uint32(n - 11)})
+				left -= n
+			}
+			if left >= 3 {
+				tokens = append(tokens, token{17, 3, uint32(left - 3)})
+				left = 0
+			}
+			for ; left > 0; left-- {
+				tokens = append(tokens, token{symbol: 0})
+			}
+		} else {
+			for range run {
+				tokens = append(tokens, token{symbol: int(lengths[i])})
+			}
+		}
+		i += run
+	}
+
+	histogram := make([]uint32, numCodeLengthCodes)
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	clLengths := codeLengths(histogram, maxCodeLengthCodeLength)
+
+	count := numCodeLengthCodes
+	for count > 4 && clLengths[codeLengthCodeOrder[count-1]] == 0 {
+		count--
+	}
+	bw.write(uint32(count-4), 4)
+	for _, symbol := range codeLengthCodeOrder[:count] {
+		bw.write(uint32(clLengths[symbol]), 3)
+	}
+
+	// All the code lengths are written, so max_symbol is not used.
+	bw.write(0, 1)
+
+	clCode := huffmanCode{lengths: make([]uint8, numCodeLengthCodes), codes: make([]uint16, numCodeLengthCodes)}
+	used := 0
+	for _, count := range histogram {
+		if count > 0 {
+			used++
+		}
+	}
+	if used > 1 {
+		clCode.lengths = clLengths
+		clCode.codes = canonicalCodes(clLengths)
+	}
+
+	for _, t := range tokens {
+		clCode.write(bw, t.symbol)
+		bw.write(t.extra, t.extraBits)
+	}
+}
+
+// codeLengths builds Huffman code lengths for the histogram limited to
+// maxLength bits. When the tree is too deep, rare symbols are made more
+// frequent until it fits. A single used symbol gets length 1.
+func codeLengths(histogram []uint32, maxLength int) []uint8 {
+	type node struct {
+		weight      uint64
+		symbol      int
+		left, right int
+	}
+
+	lengths := make([]uint8, len(histogram))
+	for minCount := uint64(1); ; minCount *= 2 {
+		var nodes []node
+		var queue []int
+		for symbol, count := range histogram {
+			if count > 0 {
+				nodes = append(nodes, node{weight: max(uint64(count), minCount), symbol: symbol, left: -1, right: -1})
+				queue = append(queue, len(nodes)-1)
+			}
+		}
+
+		if len(queue) == 0 {
+			return lengths
+		}
+		if len(queue) == 1 {
+			lengths[nodes[0].symbol] = 1
+			return lengths
+		}
+
+		for len(queue) > 1 {
+			sort.SliceStable(queue, func(i, j int) bool {
+				return nodes[queue[i]].weight < nodes[queue[j]].weight
+			})
+			a, b := queue[0], queue[1]
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, symbol: -1, left: a, right: b})
+			queue = append(queue[2:], len(nodes)-1)
+		}
+
+		type item struct{ node, depth int }
+		tooDeep := false
+		stack := []item{{queue[0], 0}}
+		for len(stack) > 0 {
+			it := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			n := nodes[it.node]
+			if n.left < 0 {
+				if it.depth > maxLength {
+					tooDeep = true
+					break
+				}
+				lengths[n.symbol] = uint8(it.depth)
+				continue
+			}
+			stack = append(stack, item{n.left, it.depth + 1}, item{n.right, it.depth + 1})
+		}
+
+		if !tooDeep {
+			return lengths
+		}
+		clear(lengths)
+	}
+}
+
+// canonicalCodes assigns canonical codes to the lengths and reverses their
+// bits, since the bitstream stores codes starting with the most significant bit.
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count [maxCodeLength + 1]int
+	for _, length := range lengths {
+		count[length]++
+	}
+	count[0] = 0
+
+	var next [maxCodeLength + 1]int
+	code := 0
+	for length := 1; length <= maxCodeLength; length++ {
+		code = (code + count[length-1]) << 1
+		next[length] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		codes[symbol] = bits.Reverse16(uint16(next[length])) >> (16 - length)
+		next[length]++
+	}
+
+	return codes
+}
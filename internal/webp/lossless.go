@@ -0,0 +1,162 @@
+package webp
+
+import "math/bits"
+
+const (
+	numLiteralCodes  = 256
+	numLengthCodes   = 24
+	numDistanceCodes = 40
+
+	minMatchLength = 3
+	maxMatchLength = 4096
+
+	// Distance codes 1 and 2 are the plane codes of the pixel above and the
+	// pixel to the left, larger distances are sent as distance + 120.
+	distanceCodeAbove = 1
+	distanceCodeLeft  = 2
+	numPlaneCodes     = 120
+)
+
+// bitWriter packs values LSB first, as required by the VP8L bitstream.
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(value uint32, n uint) {
+	w.bits |= uint64(value) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// symbol is either a literal ARGB pixel or, when length is positive, a
+// backward reference copying length pixels from distance pixels back.
+type symbol struct {
+	argb     uint32
+	length   int
+	distance int
+}
+
+// writeImage writes an entropy-coded image without color cache. Only the
+// main image carries the meta prefix codes flag.
+func writeImage(bw *bitWriter, pix []uint32, width int, topLevel bool) {
+	bw.write(0, 1)
+	if topLevel {
+		bw.write(0, 1)
+	}
+
+	symbols := backwardReferences(pix, width)
+
+	histograms := [5][]uint32{
+		make([]uint32, numLiteralCodes+numLengthCodes),
+		make([]uint32, numLiteralCodes),
+		make([]uint32, numLiteralCodes),
+		make([]uint32, numLiteralCodes),
+		make([]uint32, numDistanceCodes),
+	}
+	for _, s := range symbols {
+		if s.length > 0 {
+			lengthCode, _, _ := prefixEncode(s.length)
+			distanceCode, _, _ := prefixEncode(distanceCode(s.distance, width))
+			histograms[0][numLiteralCodes+lengthCode]++
+			histograms[4][distanceCode]++
+			continue
+		}
+		histograms[0][(s.argb>>8)&0xff]++
+		histograms[1][(s.argb>>16)&0xff]++
+		histograms[2][s.argb&0xff]++
+		histograms[3][s.argb>>24]++
+	}
+
+	var codes [5]huffmanCode
+	for i, histogram := range histograms {
+		codes[i] = writeHuffmanCode(bw, histogram)
+	}
+
+	for _, s := range symbols {
+		if s.length > 0 {
+			lengthCode, extraBits, extra := prefixEncode(s.length)
+			codes[0].write(bw, numLiteralCodes+lengthCode)
+			bw.write(extra, extraBits)
+
+			distanceCode, extraBits, extra := prefixEncode(distanceCode(s.distance, width))
+			codes[4].write(bw, distanceCode)
+			bw.write(extra, extraBits)
+			continue
+		}
+		codes[0].write(bw, int(s.argb>>8)&0xff)
+		codes[1].write(bw, int(s.argb>>16)&0xff)
+		codes[2].write(bw, int(s.argb)&0xff)
+		codes[3].write(bw, int(s.argb>>24))
+	}
+}
+
+// backwardReferences replaces runs of pixels repeating the pixel to the left
+// or the row above with backward references.
+func backwardReferences(pix []uint32, width int) []symbol {
+	var symbols []symbol
+	for i := 0; i < len(pix); {
+		length, distance := 0, 0
+		for _, d := range []int{1, width} {
+			if d > i {
+				continue
+			}
+			n := 0
+			for n < maxMatchLength && i+n < len(pix) && pix[i+n] == pix[i+n-d] {
+				n++
+			}
+			if n > length {
+				length, distance = n, d
+			}
+		}
+
+		if length >= minMatchLength {
+			symbols = append(symbols, symbol{length: length, distance: distance})
+			i += length
+			continue
+		}
+
+		symbols = append(symbols, symbol{argb: pix[i]})
+		i++
+	}
+
+	return symbols
+}
+
+func distanceCode(distance, width int) int {
+	switch distance {
+	case width:
+		return distanceCodeAbove
+	case 1:
+		return distanceCodeLeft
+	}
+	return distance + numPlaneCodes
+}
+
+// prefixEncode splits a length or distance code into its prefix symbol and
+// the extra bits that follow it.
+func prefixEncode(value int) (int, uint, uint32) {
+	d := value - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+
+	highest := bits.Len(uint(d)) - 1
+	second := (d >> (highest - 1)) & 1
+	extraBits := highest - 1
+
+	return 2*highest + second, uint(extraBits), uint32(d & (1<<extraBits - 1))
+}
@@ -0,0 +1,146 @@
+package webp
+
+const numPredictors = 14
+
+// predict chooses a predictor mode for every tile of the image and returns
+// the modes as a sub-image together with the prediction residuals.
+func predict(pix []uint32, width, height int) ([]uint32, []uint32) {
+	tilesX, tilesY := tiles(width), tiles(height)
+	modes := make([]uint32, tilesX*tilesY)
+	residuals := make([]uint32, len(pix))
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := tx<<predictorBits, ty<<predictorBits
+			x1, y1 := min(x0+1<<predictorBits, width), min(y0+1<<predictorBits, height)
+
+			best, bestCost := 0, -1
+			for mode := 0; mode < numPredictors; mode++ {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						cost += residualCost(sub(pix[y*width+x], predictAt(pix, width, x, y, mode)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(best)<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residuals[y*width+x] = sub(pix[y*width+x], predictAt(pix, width, x, y, best))
+				}
+			}
+		}
+	}
+
+	return modes, residuals
+}
+
+// predictAt returns the prediction for the pixel at (x, y). The first row and
+// column always use the L and T predictors regardless of the tile mode.
+func predictAt(pix []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pix[i-1]
+	case x == 0:
+		return pix[i-width]
+	}
+
+	// The TR pixel of the rightmost column is the leftmost pixel of the
+	// current row, which is exactly what the flat index gives.
+	l, t, tl, tr := pix[i-1], pix[i-width], pix[i-width-1], pix[i-width+1]
+
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPredictor(l, t, tl)
+	case 12:
+		return perChannel(l, t, tl, func(a, b, c int) int { return a + b - c })
+	default:
+		return perChannel(average2(l, t), tl, 0, func(a, b, _ int) int { return a + (a-b)/2 })
+	}
+}
+
+// sub subtracts every channel of b from a modulo 256.
+func sub(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	redBlue := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// residualCost estimates how expensive a residual is to encode: small
+// positive and negative differences are cheap.
+func residualCost(residual uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		c := int(residual>>shift) & 0xff
+		cost += min(c, 256-c)
+	}
+	return cost
+}
+
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+func selectPredictor(l, t, tl uint32) uint32 {
+	distL, distT := 0, 0
+	for shift := 0; shift < 32; shift += 8 {
+		cl, ct, ctl := channel(l, shift), channel(t, shift), channel(tl, shift)
+		distL += abs(ctl - ct)
+		distT += abs(ctl - cl)
+	}
+
+	if distL < distT {
+		return l
+	}
+	return t
+}
+
+// perChannel combines the channels of a, b and c with fn and clamps the result to [0, 255].
+func perChannel(a, b, c uint32, fn func(a, b, c int) int) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := min(max(fn(channel(a, shift), channel(b, shift), channel(c, shift)), 0), 255)
+		result |= uint32(v) << shift
+	}
+	return result
+}
+
+func channel(p uint32, shift int) int {
+	return int(p>>shift) & 0xff
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,130 @@
+// Package webp implements a lossless WebP encoder. golang.org/x/image only
+// ships a WebP decoder, so processed images are written with the VP8L
+// (lossless) bitstream described at
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// MaxDimension is the largest width and height the VP8L bitstream can
+// describe.
+const MaxDimension = 1 << 14
+
+const (
+	vp8lSignature = 0x2f
+	vp8lVersion   = 0
+
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+
+	// predictorBits is the log-2 size of the tiles that share a predictor mode.
+	predictorBits = 4
+)
+
+var ErrInvalidDimensions = errors.New("webp: image dimensions must be in [1, 16384]")
+
+// Encode writes the image m to w in lossless WebP format.
+func Encode(w io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > MaxDimension || height > MaxDimension {
+		return ErrInvalidDimensions
+	}
+
+	pix, hasAlpha := argbPixels(m)
+
+	bw := &bitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(boolBit(hasAlpha), 1)
+	bw.write(vp8lVersion, 3)
+
+	subtractGreen(pix)
+	bw.write(1, 1)
+	bw.write(transformSubtractGreen, 2)
+
+	modes, residuals := predict(pix, width, height)
+	bw.write(1, 1)
+	bw.write(transformPredictor, 2)
+	bw.write(predictorBits-2, 3)
+	writeImage(bw, modes, tiles(width), false)
+
+	bw.write(0, 1)
+	writeImage(bw, residuals, width, true)
+
+	return writeRIFF(w, bw.bytes())
+}
+
+func writeRIFF(w io.Writer, data []byte) error {
+	padded := len(data) + len(data)&1
+
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+padded))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padded != len(data) {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// argbPixels returns the non-premultiplied pixels of m packed as 0xAARRGGBB.
+func argbPixels(m image.Image) ([]uint32, bool) {
+	bounds := m.Bounds()
+	nrgba, ok := m.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), m, bounds.Min, draw.Src)
+		bounds = nrgba.Bounds()
+	}
+
+	pix := make([]uint32, 0, bounds.Dx()*bounds.Dy())
+	hasAlpha := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := nrgba.Pix[nrgba.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, a := row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]
+			pix = append(pix, uint32(a)<<24|uint32(r)<<16|uint32(g)<<8|uint32(b))
+			hasAlpha = hasAlpha || a != 0xff
+		}
+	}
+
+	return pix, hasAlpha
+}
+
+func subtractGreen(pix []uint32) {
+	for i, p := range pix {
+		green := (p >> 8) & 0xff
+		redBlue := (p&0x00ff00ff + 0x01000100 - (green<<16 | green)) & 0x00ff00ff
+		pix[i] = p&0xff00ff00 | redBlue
+	}
+}
+
+func tiles(size int) int {
+	return (size + 1<<predictorBits - 1) >> predictorBits
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
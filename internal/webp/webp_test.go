@@ -0,0 +1,102 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestEncode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	noise := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	rng.Read(noise.Pix)
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 5), uint8(x + y), uint8(255 - x)})
+		}
+	}
+
+	solid := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for i := range solid.Pix {
+		solid.Pix[i] = []uint8{10, 200, 30, 255}[i%4]
+	}
+
+	stripes := image.NewRGBA(image.Rect(5, 5, 105, 55))
+	for y := 5; y < 55; y++ {
+		for x := 5; x < 105; x++ {
+			stripes.Set(x, y, color.RGBA{uint8(x % 3 * 100), 0, 255, 255})
+		}
+	}
+
+	palette := image.NewPaletted(image.Rect(0, 0, 17, 9), color.Palette{color.Black, color.White})
+	for i := range palette.Pix {
+		palette.Pix[i] = uint8(i % 2)
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{name: "single pixel", img: image.NewNRGBA(image.Rect(0, 0, 1, 1))},
+		{name: "single column", img: gradient.SubImage(image.Rect(3, 0, 4, 48))},
+		{name: "random noise", img: noise},
+		{name: "gradient with alpha", img: gradient},
+		{name: "solid color", img: solid},
+		{name: "rgba with offset bounds", img: stripes},
+		{name: "paletted", img: palette},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Encode(&buf, tt.img)
+			assert.NoError(t, err)
+
+			decoded, err := webp.Decode(&buf)
+			assert.NoError(t, err)
+
+			bounds := tt.img.Bounds()
+			assert.Equal(t, bounds.Size(), decoded.Bounds().Size())
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(bounds.Min.X+x, bounds.Min.Y+y))
+					got := color.NRGBAModel.Convert(decoded.At(x, y))
+					if !assert.Equal(t, want, got, "pixel (%d, %d)", x, y) {
+						return
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncode_InvalidDimensions(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 10)))
+	assert.ErrorIs(t, err, ErrInvalidDimensions)
+
+	err = Encode(&buf, image.NewNRGBA(image.Rect(0, 0, MaxDimension+1, 1)))
+	assert.ErrorIs(t, err, ErrInvalidDimensions)
+}
+
+func TestEncode_Compresses(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x / 2), uint8(y / 2), 128, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Encode(&buf, img))
+	assert.Less(t, buf.Len(), len(img.Pix)/10)
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS image_variants(
+    image_id UUID REFERENCES images(id) ON DELETE CASCADE,
+    name TEXT,
+    position INT NOT NULL,
+    status TEXT CHECK (status IN ('in progress', 'finished', 'failed')),
+    PRIMARY KEY (image_id, name)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS image_variants;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS output_format TEXT CHECK (output_format IN ('jpeg', 'png', 'gif', 'webp'));
+ALTER TABLE image_variants ADD COLUMN IF NOT EXISTS format TEXT CHECK (format IN ('jpeg', 'png', 'gif', 'webp'));
+
+-- +goose Down
+ALTER TABLE image_variants DROP COLUMN IF EXISTS format;
+ALTER TABLE images DROP COLUMN IF EXISTS output_format;
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_format_check;
+ALTER TABLE images ADD CONSTRAINT images_format_check CHECK (format IN ('jpg', 'jpeg', 'png', 'gif', 'webp', 'bmp', 'tiff'));
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_output_format_check;
+ALTER TABLE images ADD CONSTRAINT images_output_format_check CHECK (output_format IN ('jpeg', 'png', 'gif', 'webp', 'bmp', 'tiff'));
+
+ALTER TABLE image_variants DROP CONSTRAINT IF EXISTS image_variants_format_check;
+ALTER TABLE image_variants ADD CONSTRAINT image_variants_format_check CHECK (format IN ('jpeg', 'png', 'gif', 'webp', 'bmp', 'tiff'));
+
+-- +goose Down
+ALTER TABLE image_variants DROP CONSTRAINT IF EXISTS image_variants_format_check;
+ALTER TABLE image_variants ADD CONSTRAINT image_variants_format_check CHECK (format IN ('jpeg', 'png', 'gif', 'webp'));
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_output_format_check;
+ALTER TABLE images ADD CONSTRAINT images_output_format_check CHECK (output_format IN ('jpeg', 'png', 'gif', 'webp'));
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_format_check;
+ALTER TABLE images ADD CONSTRAINT images_format_check CHECK (format IN ('jpg', 'jpeg', 'png', 'gif'));
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS color_space TEXT;
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS color_space;
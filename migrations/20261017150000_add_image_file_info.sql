@@ -0,0 +1,37 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS original_name TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS original_mime_type TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS original_width INT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS original_height INT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS original_size BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS original_sha256 TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processed_mime_type TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processed_width INT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processed_height INT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processed_size BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processed_sha256 TEXT;
+
+ALTER TABLE image_variants ADD COLUMN IF NOT EXISTS mime_type TEXT;
+ALTER TABLE image_variants ADD COLUMN IF NOT EXISTS width INT;
+ALTER TABLE image_variants ADD COLUMN IF NOT EXISTS height INT;
+ALTER TABLE image_variants ADD COLUMN IF NOT EXISTS size BIGINT;
+ALTER TABLE image_variants ADD COLUMN IF NOT EXISTS sha256 TEXT;
+
+-- +goose Down
+ALTER TABLE image_variants DROP COLUMN IF EXISTS sha256;
+ALTER TABLE image_variants DROP COLUMN IF EXISTS size;
+ALTER TABLE image_variants DROP COLUMN IF EXISTS height;
+ALTER TABLE image_variants DROP COLUMN IF EXISTS width;
+ALTER TABLE image_variants DROP COLUMN IF EXISTS mime_type;
+
+ALTER TABLE images DROP COLUMN IF EXISTS processed_sha256;
+ALTER TABLE images DROP COLUMN IF EXISTS processed_size;
+ALTER TABLE images DROP COLUMN IF EXISTS processed_height;
+ALTER TABLE images DROP COLUMN IF EXISTS processed_width;
+ALTER TABLE images DROP COLUMN IF EXISTS processed_mime_type;
+ALTER TABLE images DROP COLUMN IF EXISTS original_sha256;
+ALTER TABLE images DROP COLUMN IF EXISTS original_size;
+ALTER TABLE images DROP COLUMN IF EXISTS original_height;
+ALTER TABLE images DROP COLUMN IF EXISTS original_width;
+ALTER TABLE images DROP COLUMN IF EXISTS original_mime_type;
+ALTER TABLE images DROP COLUMN IF EXISTS original_name;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS ahash BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS dhash BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS phash BIGINT;
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS phash;
+ALTER TABLE images DROP COLUMN IF EXISTS dhash;
+ALTER TABLE images DROP COLUMN IF EXISTS ahash;
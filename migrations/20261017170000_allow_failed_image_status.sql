@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_status_check;
+ALTER TABLE images ADD CONSTRAINT images_status_check CHECK (status IN ('in progress', 'finished', 'failed'));
+
+-- +goose Down
+UPDATE images SET status = 'finished' WHERE status = 'failed';
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_status_check;
+ALTER TABLE images ADD CONSTRAINT images_status_check CHECK (status IN ('in progress', 'finished'));
@@ -6,10 +6,18 @@ const previewImg = document.getElementById('previewImg');
 const taskSelect = document.getElementById('taskSelect');
 const watermarkGroup = document.getElementById('watermarkGroup');
 const watermarkText = document.getElementById('watermarkText');
+const watermarkMode = document.getElementById('watermarkMode');
+const watermarkPosition = document.getElementById('watermarkPosition');
+const watermarkFont = document.getElementById('watermarkFont');
+const watermarkColor = document.getElementById('watermarkColor');
+const watermarkOpacity = document.getElementById('watermarkOpacity');
 const resizeGroup = document.getElementById('resizeGroup');
 const widthInput = document.getElementById('width');
 const heightInput = document.getElementById('height');
+const resizeModeSelect = document.getElementById('resizeMode');
+const resizeFilterSelect = document.getElementById('resizeFilter');
 const contentTypeSelect = document.getElementById('contentType');
+const outputFormatSelect = document.getElementById('outputFormat');
 const submitBtn = document.getElementById('submitBtn');
 const resultSection = document.getElementById('resultSection');
 const resultContent = document.getElementById('resultContent');
@@ -45,6 +53,12 @@ imageFileInput.addEventListener('change', function(e) {
             contentTypeSelect.value = 'image/png';
         } else if (fileName.endsWith('.gif')) {
             contentTypeSelect.value = 'image/gif';
+        } else if (fileName.endsWith('.webp')) {
+            contentTypeSelect.value = 'image/webp';
+        } else if (fileName.endsWith('.bmp')) {
+            contentTypeSelect.value = 'image/bmp';
+        } else if (fileName.endsWith('.tif') || fileName.endsWith('.tiff')) {
+            contentTypeSelect.value = 'image/tiff';
         }
     } else {
         fileText.textContent = 'Файл не выбран';
@@ -88,7 +102,7 @@ function validateForm() {
     if (task === 'resize') {
         const width = widthInput.value;
         const height = heightInput.value;
-        if (!width || !height || width <= 0 || height <= 0) {
+        if ((!width && !height) || width < 0 || height < 0) {
             return false;
         }
     }
@@ -122,11 +136,21 @@ submitBtn.addEventListener('click', async function() {
     // Prepare metadata
     const metadata = {
         content_type: contentType,
+        output_format: outputFormatSelect.value,
         task: task,
         watermark_string: watermark,
+        watermark: {
+            mode: watermarkMode.value,
+            position: watermarkPosition.value,
+            font: watermarkFont.value,
+            color: watermarkColor.value,
+            opacity: parseFloat(watermarkOpacity.value)
+        },
         resize: {
             width: parseInt(widthInput.value) || 0,
-            height: parseInt(heightInput.value) || 0
+            height: parseInt(heightInput.value) || 0,
+            mode: resizeModeSelect.value,
+            filter: resizeFilterSelect.value
         }
     };
 